@@ -1,70 +1,97 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
-	"os"
+	"sync"
+	"time"
 )
 
-// AIService взаимодействует с моделью AI
+// AIService генерирует аналитику с помощью языковой модели
 type AIService struct {
-	apiKey            string
-	apiURL            string
-	modelName         string
+	provider          LLMProvider
+	timeout           time.Duration // максимальное время генерации одного выпуска
+	outputRetries     int           // повторных запросов, если ответ не прошел проверку схемы
+	factChecker       *FactChecker
+	usage             *UsageTracker
+	overLimitProvider LLMProvider // дешевая модель после превышения лимита расходов, nil - шаблон
 	marketDataService *MarketDataService
+	cache             *AnalyticsCache
+	history           *RecommendationHistory
+	prompts           *PromptRegistry
+	tools             *MarketTools // инструменты модели, nil - отключены
+	compliance        *Compliance  // проверка соответствия требованиям, nil - отключена
+
+	// Выпуски, которые генерируются прямо сейчас: одновременные запросы
+	// одного и того же выпуска ждут одну генерацию
+	mu       sync.Mutex
+	inflight map[AnalyticsCacheKey]*analyticsCall
+}
+
+// analyticsCall генерация выпуска, которую ждут одновременные запросы
+type analyticsCall struct {
+	done   chan struct{}
+	report *AnalyticsReport
+	err    error
+	// abandoned генерацию прервала отмена запроса, который ее запустил:
+	// ошибка относится к нему, а не к ожидающим запросам
+	abandoned bool
 }
 
 // NewAIService создает новый экземпляр AIService
-func NewAIService(apiKey, modelName string) *AIService {
+func NewAIService(provider LLMProvider, timeout time.Duration, outputRetries int, factChecker *FactChecker, usage *UsageTracker, overLimitProvider LLMProvider, marketDataService *MarketDataService, cache *AnalyticsCache, history *RecommendationHistory, prompts *PromptRegistry, tools *MarketTools, compliance *Compliance) *AIService {
 	return &AIService{
-		apiKey:            apiKey,
-		apiURL:            "https://api.openai.com/v1/chat/completions",
-		modelName:         modelName,
-		marketDataService: NewMarketDataService(),
+		provider:          provider,
+		timeout:           timeout,
+		outputRetries:     outputRetries,
+		factChecker:       factChecker,
+		usage:             usage,
+		overLimitProvider: overLimitProvider,
+		marketDataService: marketDataService,
+		cache:             cache,
+		history:           history,
+		prompts:           prompts,
+		tools:             tools,
+		compliance:        compliance,
+		inflight:          make(map[AnalyticsCacheKey]*analyticsCall),
 	}
 }
 
-// AIRequest представляет собой запрос к API
-type AIRequest struct {
-	Model       string      `json:"model"`
-	Messages    []AIMessage `json:"messages"`
-	Temperature float64     `json:"temperature"`
-}
+// AnalyticsKind определяет вид выпуска аналитики
+type AnalyticsKind string
+
+const (
+	AnalyticsDaily     AnalyticsKind = "daily"     // ежедневная аналитика
+	AnalyticsPreMarket AnalyticsKind = "premarket" // короткий обзор перед открытием торгов
+	AnalyticsPostClose AnalyticsKind = "postclose" // итоги торговой сессии
+	AnalyticsWeekly    AnalyticsKind = "weekly"    // воскресный дайджест недели
+	AnalyticsRecap     AnalyticsKind = "recap"     // обзор для выходного или праздничного дня
+)
 
-// AIMessage представляет собой сообщение в запросе к API
-type AIMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+// GenerateAnalytics генерирует аналитику указанного вида в стиле persona на основе
+// текущего состояния рынка, с рекомендацией на сумму budget рублей (0 - defaultBudget).
+// Генерация прерывается при отмене ctx или по истечении таймаута сервиса.
+func (s *AIService) GenerateAnalytics(ctx context.Context, kind AnalyticsKind, persona Persona, budget float64) (string, error) {
+	return s.GenerateAnalyticsStream(ctx, kind, persona, budget, nil)
 }
 
-// AIResponse представляет собой ответ от API
-type AIResponse struct {
-	ID      string `json:"id"`
-	Object  string `json:"object"`
-	Created int64  `json:"created"`
-	Model   string `json:"model"`
-	Choices []struct {
-		Index        int       `json:"index"`
-		Message      AIMessage `json:"message"`
-		FinishReason string    `json:"finish_reason"`
-	} `json:"choices"`
-	Usage struct {
-		PromptTokens     int `json:"prompt_tokens"`
-		CompletionTokens int `json:"completion_tokens"`
-		TotalTokens      int `json:"total_tokens"`
-	} `json:"usage"`
-	Error struct {
-		Message string `json:"message"`
-		Type    string `json:"type"`
-	} `json:"error"`
+// GenerateAnalyticsStream генерирует аналитику, передавая в onText текст по мере
+// генерации, если провайдер поддерживает потоковую передачу. Возвращает выпуск
+// в разметке Markdown для Telegram. Выпуск, уже сгенерированный сегодня по
+// достаточно близким данным биржи, берется из кэша без обращения к модели.
+func (s *AIService) GenerateAnalyticsStream(ctx context.Context, kind AnalyticsKind, persona Persona, budget float64, onText StreamFunc) (string, error) {
+	report, err := s.GenerateIssue(ctx, kind, persona, budget, onText)
+	if err != nil {
+		return "", err
+	}
+	return RenderIssue(report, persona, budget)
 }
 
-// GenerateAnalytics генерирует аналитику на основе текущего состояния рынка
-func (s *AIService) GenerateAnalytics() (string, error) {
+// GenerateIssue возвращает выпуск вида kind в стиле persona с рекомендацией на
+// сумму budget: из кэша, из уже идущей генерации того же выпуска или новый.
+// Возвращенный выпуск общий для всех запросов и не должен изменяться.
+func (s *AIService) GenerateIssue(ctx context.Context, kind AnalyticsKind, persona Persona, budget float64, onText StreamFunc) (*AnalyticsReport, error) {
 	// Получаем данные о рынке
 	marketData, err := s.marketDataService.GetMarketData()
 	if err != nil {
@@ -72,106 +99,239 @@ func (s *AIService) GenerateAnalytics() (string, error) {
 		// Продолжаем без данных о рынке
 	}
 
-	// Формируем системный промпт
-	systemPrompt := LoadAIPrompt()
+	key := AnalyticsCacheKey{
+		Date:    analyticsDate(marketData),
+		Kind:    kind,
+		Persona: persona.OrDefault(),
+		Budget:  budgetOrDefault(budget),
+	}
+	if report, ok := s.cache.Get(key, marketData); ok {
+		log.Printf("Выпуск %s (%s, %s ₽) за %s взят из кэша", kind, key.Persona, formatMoney(key.Budget), key.Date)
+		return report, nil
+	}
 
-	// Добавляем данные о рынке, если доступны
-	var marketDataText string
-	if marketData != nil {
-		marketDataText = s.marketDataService.FormatMarketDataForAI(marketData)
-	} else {
-		marketDataText = "ДАННЫЕ О РЫНКЕ НЕДОСТУПНЫ"
-	}
-
-	// Формируем сообщение с запросом на аналитику
-	userPrompt := fmt.Sprintf("Сгенерируй актуальную аналитику по российскому фондовому рынку на сегодня. "+
-		"Фокус на возможности инвестировать 1000 рублей. "+
-		"Используй дружелюбный тон, добавь эмодзи. "+
-		"Включи совет по инвестированию, который будет отличаться от предыдущих.\n\n"+
-		"Вот текущие данные о рынке:\n\n%s", marketDataText)
-
-	// Формируем запрос к API
-	req := AIRequest{
-		Model: s.modelName,
-		Messages: []AIMessage{
-			{
-				Role:    "system",
-				Content: systemPrompt,
-			},
-			{
-				Role:    "user",
-				Content: userPrompt,
-			},
-		},
-		Temperature: 0.7,
+	s.mu.Lock()
+	for {
+		call, ok := s.inflight[key]
+		if !ok {
+			break
+		}
+		s.mu.Unlock()
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if !call.abandoned {
+			return call.report, call.err
+		}
+		// Запрос, запустивший генерацию, отменен - генерирует следующий ожидающий
+		s.mu.Lock()
 	}
+	call := &analyticsCall{done: make(chan struct{})}
+	s.inflight[key] = call
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.inflight, key)
+		s.mu.Unlock()
+		close(call.done)
+	}()
+
+	call.report, call.err = s.generateIssue(ctx, key, marketData, onText)
+	call.abandoned = call.err != nil && ctx.Err() != nil
+	return call.report, call.err
+}
 
-	// Преобразуем запрос в JSON
-	reqBytes, err := json.Marshal(req)
+// generateIssue генерирует выпуск, сохраняет его в кэш и запоминает рекомендацию
+// в истории. Выпуски, собранные по шаблону без AI, в кэш не попадают.
+func (s *AIService) generateIssue(ctx context.Context, key AnalyticsCacheKey, marketData *MarketData, onText StreamFunc) (*AnalyticsReport, error) {
+	report, err := s.GenerateReport(ctx, key.Kind, key.Persona, key.Budget, marketData, onText)
 	if err != nil {
-		return "", fmt.Errorf("ошибка маршалинга JSON: %w", err)
+		return nil, err
 	}
 
-	// Создаем HTTP запрос
-	httpReq, err := http.NewRequest("POST", s.apiURL, bytes.NewBuffer(reqBytes))
+	if !report.FromTemplate {
+		s.cache.Put(key, marketData, report)
+	}
+	s.history.Record(report, key.Kind, key.Persona, key.Budget)
+	return report, nil
+}
+
+// RenderIssue оформляет выпуск для Telegram в стиле persona. В заголовке
+// рекомендации указывается бюджет чата budget: выпуск рассылки генерируется
+// на ступень бюджета, а чат видит свою сумму.
+func RenderIssue(report *AnalyticsReport, persona Persona, budget float64) (string, error) {
+	issue := *report
+	issue.Budget = budgetOrDefault(budget)
+	text, err := issue.Render(FormatTelegram, persona)
 	if err != nil {
-		return "", fmt.Errorf("ошибка создания HTTP запроса: %w", err)
+		return "", fmt.Errorf("ошибка оформления выпуска: %w", err)
 	}
+	return text, nil
+}
 
-	// Добавляем заголовки
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.apiKey))
+// InvalidateCache сбрасывает кэш выпусков и снимок рынка, чтобы следующий
+// запрос сгенерировал выпуск заново по свежим данным. Возвращает количество
+// сброшенных выпусков.
+func (s *AIService) InvalidateCache() int {
+	s.marketDataService.Invalidate()
+	return s.cache.Clear()
+}
 
-	// Отправляем запрос
-	client := &http.Client{}
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		return "", fmt.Errorf("ошибка отправки запроса: %w", err)
+// analyticsDate возвращает день выпуска: день торговой сессии из данных
+// о рынке или, если данных нет, сегодняшний день по Москве
+func analyticsDate(marketData *MarketData) string {
+	if marketData != nil && marketData.Session.Date != "" {
+		return marketData.Session.Date
+	}
+	return time.Now().In(moscowLocation()).Format(calendarDateLayout)
+}
+
+// GenerateReport запрашивает у модели выпуск в виде JSON, проверяет его по схеме
+// и сверяет числа с данными биржи. Если ответ не прошел проверку или повторяет
+// рекомендации прошлых выпусков, модель получает список ошибок и отвечает заново,
+// не более outputRetries раз. Повтор, оставшийся после всех попыток, допускается,
+// а расхождения с данными биржи исправляются или помечаются в выпуске. Затем
+// выпуск проходит проверку соответствия требованиям: запрещенные утверждения
+// модель переписывает, пока есть попытки, а оставшиеся блокируют выпуск.
+// Во время генерации в onText передаются уже сгенерированные текстовые поля, а не сам JSON.
+// Модель видит только акции, лот которых укладывается в budget рублей.
+// marketData может быть nil, если данные о рынке недоступны.
+func (s *AIService) GenerateReport(ctx context.Context, kind AnalyticsKind, persona Persona, budget float64, marketData *MarketData, onText StreamFunc) (*AnalyticsReport, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	budget = budgetOrDefault(budget)
+	if marketData != nil {
+		marketData = marketData.ForBudget(budget)
+	}
+
+	// Переменные шаблонов промптов
+	data := PromptData{
+		Date:    analyticsDate(marketData),
+		Kind:    kind,
+		Persona: persona.OrDefault(),
+		Budget:  budget,
+		Market:  marketData,
+		Schema:  analyticsSchemaPrompt,
 	}
-	defer resp.Body.Close()
+	if marketData != nil {
+		data.Session = marketData.Session
+		data.MarketText = s.marketDataService.FormatMarketDataForAI(marketData)
+	}
+	date := data.Date
 
-	// Читаем ответ
-	body, err := io.ReadAll(resp.Body)
+	// После превышения месячного лимита расходов - дешевая модель или шаблон
+	provider := s.provider
+	if s.usage.OverLimit() {
+		if s.overLimitProvider == nil {
+			if marketData == nil {
+				return nil, fmt.Errorf("лимит расходов на AI исчерпан, а данные о рынке недоступны")
+			}
+			log.Printf("Лимит расходов на AI исчерпан, выпуск %s собран по шаблону", kind)
+			report := TemplateReport(marketData, date, budget)
+			if err := s.compliance.Report(ctx, report, kind); err != nil {
+				return nil, err
+			}
+			return report, nil
+		}
+		provider = s.overLimitProvider
+	}
+
+	// Рекомендации прошлых выпусков, которые модель не должна повторять
+	previous, err := s.history.Recent(date, kind)
 	if err != nil {
-		return "", fmt.Errorf("ошибка чтения ответа: %w", err)
+		log.Printf("Ошибка чтения истории рекомендаций: %v", err)
 	}
+	data.History = previous
 
-	// Парсим ответ
-	var aiResp AIResponse
-	err = json.Unmarshal(body, &aiResp)
+	// Формируем системный промпт и запрос на аналитику
+	systemPrompt, err := s.prompts.Render(persona.Style().Prompt, data)
 	if err != nil {
-		return "", fmt.Errorf("ошибка парсинга ответа: %w", err)
+		return nil, err
+	}
+	userPrompt, err := s.prompts.Render(PromptAnalytics, data)
+	if err != nil {
+		return nil, err
 	}
 
-	// Проверка на ошибки в ответе API
-	if aiResp.Error.Message != "" {
-		return "", fmt.Errorf("ошибка API: %s", aiResp.Error.Message)
+	// Промежуточный текст проходит проверку соответствия до показа в чате
+	onText = s.compliance.Preview(onText)
+	var preview StreamFunc
+	if onText != nil {
+		preview = func(text string) {
+			if partial := PreviewPartialReport(text); partial != "" {
+				onText(partial)
+			}
+		}
 	}
 
-	// Проверка наличия ответа
-	if len(aiResp.Choices) == 0 {
-		return "", fmt.Errorf("пустой ответ от API")
+	messages := []AIMessage{
+		{
+			Role:    "user",
+			Content: userPrompt,
+		},
 	}
+	for attempt := 0; ; attempt++ {
+		// Запрашиваем ответ у провайдера (или цепочки резервных провайдеров)
+		completion, err := s.complete(ctx, provider, CompletionRequest{
+			System:      systemPrompt,
+			Messages:    messages,
+			Temperature: 0.7,
+			JSON:        true,
+		}, preview)
+		if err != nil {
+			return nil, err
+		}
 
-	// Возвращаем содержимое ответа
-	return aiResp.Choices[0].Message.Content, nil
-}
+		report, err := ParseAnalyticsReport(completion.Text)
+		if err == nil {
+			err = report.Validate(date, budget)
+		}
+		if err == nil {
+			if repeats := s.history.Check(report, previous); repeats != nil {
+				if attempt < s.outputRetries {
+					err = repeats
+				} else {
+					log.Printf("Выпуск %s повторяет прошлые рекомендации: %v", kind, repeats)
+				}
+			}
+		}
+		if err == nil && attempt < s.outputRetries {
+			// Запрещенные утверждения модель переписывает сама, пока есть попытки
+			err = s.compliance.Blocked(ctx, report, kind)
+		}
+		if err == nil {
+			report.Budget = budget
+			discrepancies := s.factChecker.Check(report, marketData)
+			if len(discrepancies) > 0 && (s.factChecker.Mode() != FactCheckRegenerate || attempt >= s.outputRetries) {
+				s.factChecker.Resolve(report, discrepancies, budget)
+				discrepancies = nil
+			}
+			if len(discrepancies) == 0 {
+				if err := s.compliance.Report(ctx, report, kind); err != nil {
+					return nil, err
+				}
+				return report, nil
+			}
+			err = discrepancyError(discrepancies)
+		}
+		if attempt >= s.outputRetries {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidReport, err)
+		}
 
-// LoadAIPrompt загружает промпт для AI из файла
-func LoadAIPrompt() string {
-	// Попытка загрузки из файла
-	content, err := os.ReadFile("ai_prompt.txt")
-	if err == nil && len(content) > 0 {
-		return string(content)
-	}
-
-	// Если файл не найден, используем стандартный промпт
-	return `Ты финансовый аналитик, специализирующийся на российском фондовом рынке. 
-Твоя задача - давать полезные и понятные советы по инвестированию начинающим инвесторам.
-Говори простым и дружелюбным языком, избегай сложных терминов. 
-Используй эмодзи, чтобы сделать текст более живым.
-Включай конкретные рекомендации по акциям, которые стоит купить на 1000 рублей.
-Подкрепляй свои рекомендации актуальными данными и трендами.
-Добавляй уникальные советы по инвестированию, которые будут интересны и полезны новичкам.
-Твои советы должны быть актуальными и учитывать реальную ситуацию на рынке.`
+		log.Printf("Ответ %s не прошел проверку (попытка %d из %d): %v",
+			completion.Provider, attempt+1, s.outputRetries+1, err)
+		data.Errors = err.Error()
+		feedback, renderErr := s.prompts.Render(PromptFeedback, data)
+		if renderErr != nil {
+			return nil, renderErr
+		}
+		messages = append(messages,
+			AIMessage{Role: "assistant", Content: completion.Text},
+			AIMessage{Role: "user", Content: feedback},
+		)
+	}
 }
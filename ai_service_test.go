@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gatedProvider зависает на первом запросе до отмены его ctx, остальные
+// запросы передает provider
+type gatedProvider struct {
+	provider LLMProvider
+	started  chan struct{}
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (p *gatedProvider) Name() string {
+	return p.provider.Name()
+}
+
+func (p *gatedProvider) Complete(ctx context.Context, req CompletionRequest) (*Completion, error) {
+	p.mu.Lock()
+	p.calls++
+	first := p.calls == 1
+	p.mu.Unlock()
+
+	if first {
+		close(p.started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return p.provider.Complete(ctx, req)
+}
+
+func TestGenerateIssueLeaderCanceled(t *testing.T) {
+	data := testMarketData()
+	data.RecommendedStock = data.TopStocks[0]
+	market := NewMarketDataService(MarketDataConfig{}, nil)
+	market.Pin(data)
+	prompts, err := NewPromptRegistry(PromptsConfig{})
+	if err != nil {
+		t.Fatalf("NewPromptRegistry: %v", err)
+	}
+
+	provider := &gatedProvider{
+		provider: &evalStub{data: data, budget: defaultBudget},
+		started:  make(chan struct{}),
+	}
+	service := NewAIService(provider, time.Minute, 0, NewFactChecker(FactCheckConfig{}), nil, nil,
+		market, NewAnalyticsCache(AnalyticsCacheConfig{}), nil, prompts, nil, nil)
+
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, err := service.GenerateIssue(leaderCtx, AnalyticsDaily, DefaultPersona, defaultBudget, nil)
+		leaderErr <- err
+	}()
+	<-provider.started
+
+	waiterDone := make(chan error, 1)
+	go func() {
+		report, err := service.GenerateIssue(context.Background(), AnalyticsDaily, DefaultPersona, defaultBudget, nil)
+		if err == nil && report == nil {
+			err = errors.New("пустой выпуск")
+		}
+		waiterDone <- err
+	}()
+	// Второй запрос успевает встать в ожидание общей генерации
+	time.Sleep(20 * time.Millisecond)
+	cancelLeader()
+
+	if err := <-leaderErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("запустивший генерацию запрос: ошибка %v, ожидалась отмена", err)
+	}
+	select {
+	case err := <-waiterDone:
+		if err != nil {
+			t.Errorf("ожидающий запрос получил ошибку отмененного: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ожидающий запрос не завершился")
+	}
+	if provider.calls != 2 {
+		t.Errorf("обращений к модели %d, ожидалось 2", provider.calls)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// AnalyticsCacheKey определяет выпуск, который можно отдать повторно
+type AnalyticsCacheKey struct {
+	Date    string // день выпуска по Москве, ГГГГ-ММ-ДД
+	Kind    AnalyticsKind
+	Persona Persona
+	Budget  float64
+}
+
+// analyticsCacheEntry сгенерированный выпуск и данные биржи, по которым он написан
+type analyticsCacheEntry struct {
+	report    *AnalyticsReport
+	snapshot  *MarketData
+	hash      string // хеш снимка рынка
+	createdAt time.Time
+}
+
+// AnalyticsCache хранит выпуски аналитики в течение дня. Выпуск отдается повторно,
+// пока не истек TTL и рынок не сдвинулся дальше порогов из настроек: при точном
+// совпадении хеша снимка рынка сравнение не нужно, иначе сравниваются индексы,
+// курсы валют и цены акций.
+type AnalyticsCache struct {
+	config AnalyticsCacheConfig
+
+	mu      sync.Mutex
+	entries map[AnalyticsCacheKey]*analyticsCacheEntry
+}
+
+// NewAnalyticsCache создает кэш выпусков
+func NewAnalyticsCache(config AnalyticsCacheConfig) *AnalyticsCache {
+	return &AnalyticsCache{
+		config:  config,
+		entries: make(map[AnalyticsCacheKey]*analyticsCacheEntry),
+	}
+}
+
+// Get возвращает выпуск для key, если он еще актуален для снимка рынка snapshot
+func (c *AnalyticsCache) Get(key AnalyticsCacheKey, snapshot *MarketData) (*AnalyticsReport, bool) {
+	if c.config.TTL <= 0 || snapshot == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if age := time.Since(entry.createdAt); age > c.config.TTL {
+		delete(c.entries, key)
+		log.Printf("Кэш аналитики: выпуск %s устарел (%s)", key.Kind, age.Round(time.Minute))
+		return nil, false
+	}
+	if entry.hash != snapshotHash(snapshot) {
+		if reason := c.moved(entry.snapshot, snapshot); reason != "" {
+			delete(c.entries, key)
+			log.Printf("Кэш аналитики: выпуск %s сброшен, %s", key.Kind, reason)
+			return nil, false
+		}
+	}
+	return entry.report, true
+}
+
+// Put сохраняет выпуск и удаляет выпуски прошлых дней. Сохраненный выпуск
+// не должен изменяться.
+func (c *AnalyticsCache) Put(key AnalyticsCacheKey, snapshot *MarketData, report *AnalyticsReport) {
+	if c.config.TTL <= 0 || snapshot == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k := range c.entries {
+		if k.Date != key.Date {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = &analyticsCacheEntry{
+		report:    report,
+		snapshot:  snapshot,
+		hash:      snapshotHash(snapshot),
+		createdAt: time.Now(),
+	}
+}
+
+// Clear удаляет все выпуски и возвращает их количество
+func (c *AnalyticsCache) Clear() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := len(c.entries)
+	c.entries = make(map[AnalyticsCacheKey]*analyticsCacheEntry)
+	return n
+}
+
+// moved возвращает причину, по которой выпуск по снимку old не подходит для
+// снимка current, или пустую строку, если рынок сдвинулся в пределах порогов
+func (c *AnalyticsCache) moved(old, current *MarketData) string {
+	if old.Session.Status != current.Session.Status {
+		return fmt.Sprintf("сменилась торговая сессия: %s → %s", old.Session.Status, current.Session.Status)
+	}
+	if old.StubIndices != current.StubIndices || old.StubStocks != current.StubStocks {
+		return "изменилась доступность данных биржи"
+	}
+
+	checks := []struct {
+		name      string
+		old, cur  float64
+		threshold float64
+	}{
+		{"индекс Мосбиржи", old.IndexMOEX, current.IndexMOEX, c.config.IndexThreshold},
+		{"индекс РТС", old.IndexRTS, current.IndexRTS, c.config.IndexThreshold},
+		{"курс доллара", old.USDRate, current.USDRate, c.config.FXThreshold},
+		{"курс евро", old.EURRate, current.EURRate, c.config.FXThreshold},
+	}
+	for _, check := range checks {
+		if change := relativeChange(check.old, check.cur); change > check.threshold {
+			return fmt.Sprintf("%s изменился на %.1f%%", check.name, change*100)
+		}
+	}
+
+	oldPrices := make(map[string]float64, len(old.TopStocks)+1)
+	for _, stock := range append(old.TopStocks, old.RecommendedStock) {
+		oldPrices[stock.Ticker] = stock.Price
+	}
+	for _, stock := range append(current.TopStocks, current.RecommendedStock) {
+		price, ok := oldPrices[stock.Ticker]
+		if !ok {
+			continue
+		}
+		if change := relativeChange(price, stock.Price); change > c.config.PriceThreshold {
+			return fmt.Sprintf("цена %s изменилась на %.1f%%", stock.Ticker, change*100)
+		}
+	}
+	return ""
+}
+
+// relativeChange возвращает модуль относительного изменения значения
+func relativeChange(old, current float64) float64 {
+	if old == 0 {
+		if current == 0 {
+			return 0
+		}
+		return math.Inf(1)
+	}
+	return math.Abs(current-old) / math.Abs(old)
+}
+
+// snapshotHash вычисляет хеш рыночной части снимка; новости не учитываются
+func snapshotHash(data *MarketData) string {
+	content, _ := json.Marshal(struct {
+		IndexMOEX, IndexRTS, USDRate, EURRate float64
+		TopStocks                             []StockInfo
+		RecommendedStock                      StockInfo
+		Session                               SessionInfo
+	}{data.IndexMOEX, data.IndexRTS, data.USDRate, data.EURRate, data.TopStocks, data.RecommendedStock, data.Session})
+
+	h := fnv.New64a()
+	h.Write(content)
+	return fmt.Sprintf("%016x", h.Sum64())
+}
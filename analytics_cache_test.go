@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAnalyticsCache(t *testing.T) {
+	config := AnalyticsCacheConfig{
+		TTL:            time.Hour,
+		IndexThreshold: 0.01,
+		FXThreshold:    0.005,
+		PriceThreshold: 0.02,
+	}
+	key := AnalyticsCacheKey{Date: "2026-10-16", Kind: "daily", Persona: DefaultPersona, Budget: 10000}
+
+	tests := []struct {
+		name   string
+		change func(data *MarketData)
+		wantOK bool
+	}{
+		{"тот же снимок", func(*MarketData) {}, true},
+		{"индекс в пределах порога", func(data *MarketData) { data.IndexMOEX = 3220 }, true},
+		{"индекс за порогом", func(data *MarketData) { data.IndexMOEX = 3300 }, false},
+		{"курс за порогом", func(data *MarketData) { data.USDRate = 91 }, false},
+		{"цена акции за порогом", func(data *MarketData) { data.TopStocks[0].Price = 310 }, false},
+		{"новая акция не сравнивается", func(data *MarketData) {
+			data.TopStocks = append(data.TopStocks, StockInfo{Ticker: "GAZP", Price: 150})
+		}, true},
+		{"сменилась сессия", func(data *MarketData) { data.Session.Status = SessionClosed }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := NewAnalyticsCache(config)
+			report := &AnalyticsReport{Date: key.Date}
+			cache.Put(key, testMarketData(), report)
+
+			current := testMarketData()
+			tt.change(current)
+			got, ok := cache.Get(key, current)
+			if ok != tt.wantOK {
+				t.Fatalf("Get = %v, ожидалось %v", ok, tt.wantOK)
+			}
+			if ok && got != report {
+				t.Errorf("Get = %p, ожидался сохраненный выпуск %p", got, report)
+			}
+			// Сброшенный выпуск удаляется из кэша
+			if _, again := cache.Get(key, testMarketData()); again != tt.wantOK {
+				t.Errorf("повторный Get = %v, ожидалось %v", again, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestAnalyticsCacheExpiry(t *testing.T) {
+	cache := NewAnalyticsCache(AnalyticsCacheConfig{TTL: time.Hour})
+	today := AnalyticsCacheKey{Date: "2026-10-16", Kind: "daily"}
+	yesterday := AnalyticsCacheKey{Date: "2026-10-15", Kind: "daily"}
+
+	cache.Put(yesterday, testMarketData(), &AnalyticsReport{Date: yesterday.Date})
+	cache.Put(today, testMarketData(), &AnalyticsReport{Date: today.Date})
+	if _, ok := cache.Get(yesterday, testMarketData()); ok {
+		t.Error("выпуск прошлого дня не удален при сохранении нового")
+	}
+
+	cache.entries[today].createdAt = time.Now().Add(-2 * time.Hour)
+	if _, ok := cache.Get(today, testMarketData()); ok {
+		t.Error("выпуск старше TTL отдан из кэша")
+	}
+
+	cache.Put(today, testMarketData(), &AnalyticsReport{Date: today.Date})
+	if n := cache.Clear(); n != 1 {
+		t.Errorf("Clear = %d, ожидалось 1", n)
+	}
+
+	// С нулевым TTL кэш отключен
+	disabled := NewAnalyticsCache(AnalyticsCacheConfig{})
+	disabled.Put(today, testMarketData(), &AnalyticsReport{Date: today.Date})
+	if _, ok := disabled.Get(today, testMarketData()); ok {
+		t.Error("отключенный кэш вернул выпуск")
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	htmltemplate "html/template"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// ReportFormat формат вывода выпуска аналитики
+type ReportFormat string
+
+const (
+	FormatTelegram ReportFormat = "telegram" // Markdown для Telegram
+	FormatPlain    ReportFormat = "plain"    // текст без разметки
+	FormatHTML     ReportFormat = "html"     // фрагмент HTML
+)
+
+// telegramReportTemplate выпуск в разметке Markdown для Telegram. Текст модели
+// экранируется, разметка задается только шаблоном.
+const telegramReportTemplate = `📅 *Аналитика на {{date .Date}}*
+{{if .Style.Greeting}}{{with .Greeting}}
+{{md .}}
+{{end}}{{end}}
+📊 *Ситуация на рынке*
+{{md .MarketSummary}}
+
+💰 *Куда вложить {{money .Budget}} ₽*
+{{with .Recommendation}}{{md .Instrument}}{{if .Ticker}} ({{md .Ticker}}){{end}}
+{{if .Lots}}{{.Lots}} {{lots .Lots}} по {{.LotSize}} шт. × {{money .Price}} ₽ = *{{money .Total}} ₽*{{else}}Сумма: *{{money .Total}} ₽*{{end}}{{end}}
+
+💡 *Почему это выгодно*
+{{md .Rationale}}
+{{if .Style.SideHustle}}
+🚀 *Идея для подработки: {{mdEntity .SideHustle.Title}}*
+{{md .SideHustle.Text}}
+{{end}}{{if .Style.Greeting}}{{with .Farewell}}
+{{md .}}
+{{end}}{{end}}{{with .Warnings}}
+❗ *Цифры расходятся с данными биржи:*
+{{range .}}• {{md .}}
+{{end}}{{end}}
+⚠️ {{md .Disclaimer}}`
+
+// plainReportTemplate выпуск простым текстом
+const plainReportTemplate = `📅 Аналитика на {{date .Date}}
+{{if .Style.Greeting}}{{with .Greeting}}
+{{.}}
+{{end}}{{end}}
+📊 Ситуация на рынке
+{{.MarketSummary}}
+
+💰 Куда вложить {{money .Budget}} ₽
+{{with .Recommendation}}{{.Instrument}}{{if .Ticker}} ({{.Ticker}}){{end}}
+{{if .Lots}}{{.Lots}} {{lots .Lots}} по {{.LotSize}} шт. × {{money .Price}} ₽ = {{money .Total}} ₽{{else}}Сумма: {{money .Total}} ₽{{end}}{{end}}
+
+💡 Почему это выгодно
+{{.Rationale}}
+{{if .Style.SideHustle}}
+🚀 Идея для подработки: {{.SideHustle.Title}}
+{{.SideHustle.Text}}
+{{end}}{{if .Style.Greeting}}{{with .Farewell}}
+{{.}}
+{{end}}{{end}}{{with .Warnings}}
+❗ Цифры расходятся с данными биржи:
+{{range .}}• {{.}}
+{{end}}{{end}}
+⚠️ {{.Disclaimer}}`
+
+// htmlReportTemplate выпуск фрагментом HTML; экранирование выполняет html/template
+const htmlReportTemplate = `<article class="analytics">
+<h2>📅 Аналитика на {{date .Date}}</h2>
+{{if .Style.Greeting}}{{with .Greeting}}<p>{{.}}</p>
+{{end}}{{end}}<h3>📊 Ситуация на рынке</h3>
+<p>{{.MarketSummary}}</p>
+<h3>💰 Куда вложить {{money .Budget}} ₽</h3>
+{{with .Recommendation}}<p>{{.Instrument}}{{if .Ticker}} ({{.Ticker}}){{end}}<br>
+{{if .Lots}}{{.Lots}} {{lots .Lots}} по {{.LotSize}} шт. × {{money .Price}} ₽ = <b>{{money .Total}} ₽</b>{{else}}Сумма: <b>{{money .Total}} ₽</b>{{end}}</p>
+{{end}}<h3>💡 Почему это выгодно</h3>
+<p>{{.Rationale}}</p>
+{{if .Style.SideHustle}}<h3>🚀 Идея для подработки: {{.SideHustle.Title}}</h3>
+<p>{{.SideHustle.Text}}</p>
+{{end}}{{if .Style.Greeting}}{{with .Farewell}}<p>{{.}}</p>
+{{end}}{{end}}{{with .Warnings}}<p class="warnings">❗ Цифры расходятся с данными биржи:</p>
+<ul>
+{{range .}}<li>{{.}}</li>
+{{end}}</ul>
+{{end}}<p><i>⚠️ {{.Disclaimer}}</i></p>
+</article>`
+
+// reportTemplates разобранные шаблоны; ошибка в шаблоне обнаруживается при запуске
+var reportTemplates = struct {
+	telegram *template.Template
+	plain    *template.Template
+	html     *htmltemplate.Template
+}{
+	telegram: template.Must(template.New("telegram").Funcs(reportFuncs).Parse(telegramReportTemplate)),
+	plain:    template.Must(template.New("plain").Funcs(reportFuncs).Parse(plainReportTemplate)),
+	html:     htmltemplate.Must(htmltemplate.New("html").Funcs(reportFuncs).Parse(htmlReportTemplate)),
+}
+
+// reportFuncs функции, доступные в шаблонах выпуска
+var reportFuncs = map[string]interface{}{
+	"md":       escapeMarkdown,
+	"mdEntity": stripMarkdown,
+	"date":     formatReportDate,
+	"money":    formatMoney,
+	"lots":     pluralLots,
+}
+
+// reportView отчет и правила оформления стиля, которые видят шаблоны выпуска
+type reportView struct {
+	*AnalyticsReport
+	Style  PersonaStyle
+	Budget float64 // бюджет отчета или defaultBudget, если он не указан
+}
+
+// Render выводит отчет в указанном формате по правилам оформления стиля persona
+func (r *AnalyticsReport) Render(format ReportFormat, persona Persona) (string, error) {
+	view := reportView{AnalyticsReport: r, Style: persona.Style(), Budget: r.Budget}
+	if view.Budget <= 0 {
+		view.Budget = defaultBudget
+	}
+
+	var sb strings.Builder
+	var err error
+	switch format {
+	case FormatPlain:
+		err = reportTemplates.plain.Execute(&sb, view)
+	case FormatHTML:
+		err = reportTemplates.html.Execute(&sb, view)
+	default:
+		err = reportTemplates.telegram.Execute(&sb, view)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	text := sb.String()
+	if !view.Style.Emoji {
+		text = stripEmoji(text)
+	}
+	return strings.TrimSpace(text), nil
+}
+
+// escapeMarkdown экранирует символы разметки Markdown Telegram в тексте модели
+func escapeMarkdown(s string) string {
+	return strings.NewReplacer("_", "\\_", "*", "\\*", "`", "\\`", "[", "\\[").Replace(s)
+}
+
+// stripMarkdown удаляет символы разметки: внутри выделенного фрагмента
+// Telegram не поддерживает экранирование
+func stripMarkdown(s string) string {
+	return strings.TrimSpace(strings.NewReplacer("_", " ", "*", "", "`", "", "[", "(", "]", ")").Replace(s))
+}
+
+// formatReportDate выводит дату ГГГГ-ММ-ДД в виде ДД.ММ.ГГГГ
+func formatReportDate(date string) string {
+	parts := strings.Split(date, "-")
+	if len(parts) != 3 {
+		return date
+	}
+	return parts[2] + "." + parts[1] + "." + parts[0]
+}
+
+// formatMoney выводит сумму в рублях: без копеек для целых сумм, с запятой в качестве разделителя
+func formatMoney(amount float64) string {
+	if amount == float64(int64(amount)) {
+		return strconv.FormatInt(int64(amount), 10)
+	}
+	return strings.Replace(strconv.FormatFloat(amount, 'f', 2, 64), ".", ",", 1)
+}
+
+// pluralLots склоняет слово "лот" после числа
+func pluralLots(n int) string {
+	switch {
+	case n%10 == 1 && n%100 != 11:
+		return "лот"
+	case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+		return "лота"
+	default:
+		return "лотов"
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// defaultBudget сумма, которую аналитика предлагает вложить, в рублях
+const defaultBudget = 1000.0
+
+// ErrInvalidReport модель так и не прислала ответ, прошедший проверку
+var ErrInvalidReport = errors.New("ответ модели не соответствует схеме")
+
+// Типы инструментов в рекомендации
+const (
+	InstrumentShare   = "share"   // акция
+	InstrumentBond    = "bond"    // облигация
+	InstrumentFund    = "fund"    // биржевой фонд
+	InstrumentDeposit = "deposit" // вклад или накопительный счет
+	InstrumentOther   = "other"   // другое
+)
+
+// exchangeInstruments инструменты, которые покупаются на бирже лотами
+var exchangeInstruments = map[string]bool{
+	InstrumentShare: true,
+	InstrumentBond:  true,
+	InstrumentFund:  true,
+}
+
+// AnalyticsReport структурированный выпуск аналитики, который возвращает модель
+type AnalyticsReport struct {
+	Date           string         `json:"date"`     // дата выпуска, ГГГГ-ММ-ДД
+	Greeting       string         `json:"greeting"` // приветствие (необязательно)
+	MarketSummary  string         `json:"market_summary"`
+	Recommendation Recommendation `json:"recommendation"`
+	Rationale      string         `json:"rationale"` // почему это выгодно
+	SideHustle     SideHustleTip  `json:"side_hustle"`
+	Farewell       string         `json:"farewell"` // прощание (необязательно)
+	Disclaimer     string         `json:"disclaimer"`
+
+	// Warnings расхождения с данными биржи, которые не удалось исправить
+	Warnings []string `json:"-"`
+	// FromTemplate выпуск собран по шаблону без AI
+	FromTemplate bool `json:"-"`
+	// Budget сумма, на которую рассчитана рекомендация, в рублях
+	Budget float64 `json:"-"`
+}
+
+// Recommendation конкретная рекомендация, куда вложить бюджет
+type Recommendation struct {
+	Instrument string  `json:"instrument"` // название инструмента
+	Type       string  `json:"type"`       // share, bond, fund, deposit, other
+	Ticker     string  `json:"ticker"`     // тикер на Мосбирже, для биржевых инструментов
+	Price      float64 `json:"price"`      // цена одной бумаги в рублях
+	LotSize    int     `json:"lot_size"`   // бумаг в лоте
+	Lots       int     `json:"lots"`       // сколько лотов купить
+	Total      float64 `json:"total"`      // итоговая сумма вложения в рублях
+}
+
+// SideHustleTip совет по подработке
+type SideHustleTip struct {
+	Title string `json:"title"`
+	Text  string `json:"text"`
+	Topic string `json:"topic"` // тема одним-двумя словами, для истории рекомендаций
+}
+
+// topic возвращает тему совета, а если модель ее не указала - заголовок
+func (t SideHustleTip) topic() string {
+	if topic := strings.TrimSpace(t.Topic); topic != "" {
+		return topic
+	}
+	return strings.TrimSpace(t.Title)
+}
+
+// analyticsSchemaPrompt описание формата ответа для модели
+const analyticsSchemaPrompt = `Ответь строго одним JSON-объектом без пояснений и без блока кода, по схеме:
+{
+  "date": "дата выпуска в формате ГГГГ-ММ-ДД",
+  "greeting": "приветствие (можно пустую строку)",
+  "market_summary": "краткое описание текущей ситуации на рынке: индексы, тренды, курсы валют",
+  "recommendation": {
+    "instrument": "название инструмента",
+    "type": "share | bond | fund | deposit | other",
+    "ticker": "тикер на Московской бирже (для share, bond, fund), иначе пустая строка",
+    "price": цена одной бумаги в рублях числом (для share, bond, fund),
+    "lot_size": количество бумаг в лоте (для share, bond, fund),
+    "lots": сколько лотов купить (для share, bond, fund),
+    "total": итоговая сумма вложения в рублях числом
+  },
+  "rationale": "почему это выгодно и какой потенциал у вложения",
+  "side_hustle": {"title": "заголовок совета по подработке", "text": "сам совет", "topic": "тема подработки одним-двумя словами, например: фриланс, кешбэк, репетиторство"},
+  "farewell": "прощание (можно пустую строку)",
+  "disclaimer": "напоминание, что это не индивидуальная инвестиционная рекомендация"
+}
+Для биржевых инструментов total должен равняться price * lot_size * lots. Текстовые поля могут содержать эмодзи, но не разметку Markdown.`
+
+// ParseAnalyticsReport извлекает отчет из ответа модели. Модели иногда
+// оборачивают JSON в блок кода или добавляют текст вокруг - он отбрасывается.
+func ParseAnalyticsReport(text string) (*AnalyticsReport, error) {
+	start := strings.Index(text, "{")
+	end := strings.LastIndex(text, "}")
+	if start == -1 || end < start {
+		return nil, fmt.Errorf("в ответе нет JSON-объекта")
+	}
+
+	var report AnalyticsReport
+	if err := json.Unmarshal([]byte(text[start:end+1]), &report); err != nil {
+		return nil, fmt.Errorf("некорректный JSON: %w", err)
+	}
+	return &report, nil
+}
+
+// Validate проверяет отчет и возвращает все найденные ошибки сразу
+func (r *AnalyticsReport) Validate(date string, budget float64) error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if _, err := time.Parse(calendarDateLayout, r.Date); err != nil {
+		fail("поле date должно быть датой в формате ГГГГ-ММ-ДД, получено %q", r.Date)
+	} else if r.Date != date {
+		fail("поле date должно быть сегодняшней датой %s, получено %s", date, r.Date)
+	}
+	if strings.TrimSpace(r.MarketSummary) == "" {
+		fail("не заполнено поле market_summary")
+	}
+	if strings.TrimSpace(r.Rationale) == "" {
+		fail("не заполнено поле rationale")
+	}
+	if strings.TrimSpace(r.SideHustle.Title) == "" || strings.TrimSpace(r.SideHustle.Text) == "" {
+		fail("не заполнены поля side_hustle.title и side_hustle.text")
+	}
+	if strings.TrimSpace(r.Disclaimer) == "" {
+		fail("не заполнено поле disclaimer")
+	}
+
+	rec := r.Recommendation
+	if strings.TrimSpace(rec.Instrument) == "" {
+		fail("не заполнено поле recommendation.instrument")
+	}
+	switch {
+	case exchangeInstruments[rec.Type]:
+		if strings.TrimSpace(rec.Ticker) == "" {
+			fail("для биржевого инструмента нужно поле recommendation.ticker")
+		}
+		if rec.Price <= 0 || rec.LotSize < 1 || rec.Lots < 1 {
+			fail("для биржевого инструмента поля price, lot_size и lots должны быть положительными")
+		} else if expected := rec.Price * float64(rec.LotSize) * float64(rec.Lots); math.Abs(expected-rec.Total) > math.Max(1, expected*0.01) {
+			fail("recommendation.total = %.2f не равен price * lot_size * lots = %.2f", rec.Total, expected)
+		}
+	case rec.Type == InstrumentDeposit || rec.Type == InstrumentOther:
+	default:
+		fail("recommendation.type должен быть одним из share, bond, fund, deposit, other, получено %q", rec.Type)
+	}
+	if rec.Total <= 0 {
+		fail("recommendation.total должен быть положительным")
+	} else if rec.Total > budget {
+		fail("recommendation.total = %.2f превышает бюджет %.0f рублей", rec.Total, budget)
+	}
+
+	return errors.Join(errs...)
+}
+
+// reportPreviewFields текстовые поля отчета в порядке вывода при потоковой генерации
+var reportPreviewFields = []string{"greeting", "market_summary", "instrument", "rationale", "title", "text", "farewell"}
+
+// PreviewPartialReport извлекает уже сгенерированные текстовые поля из неполного
+// JSON, чтобы во время генерации показывать пользователю текст, а не JSON
+func PreviewPartialReport(raw string) string {
+	var parts []string
+	for _, field := range reportPreviewFields {
+		if value := partialJSONString(raw, field); strings.TrimSpace(value) != "" {
+			parts = append(parts, value)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// partialJSONString возвращает значение строкового поля key, даже если строка
+// еще не закрыта кавычкой
+func partialJSONString(raw, key string) string {
+	idx := strings.Index(raw, `"`+key+`"`)
+	if idx == -1 {
+		return ""
+	}
+	rest := strings.TrimLeft(raw[idx+len(key)+2:], " \t\r\n")
+	if !strings.HasPrefix(rest, ":") {
+		return ""
+	}
+	rest = strings.TrimLeft(rest[1:], " \t\r\n")
+	if !strings.HasPrefix(rest, `"`) {
+		return ""
+	}
+
+	var sb strings.Builder
+	escaped := false
+	for _, r := range rest[1:] {
+		switch {
+		case escaped:
+			switch r {
+			case 'n':
+				sb.WriteRune('\n')
+			case 't':
+				sb.WriteRune('\t')
+			default:
+				sb.WriteRune(r)
+			}
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '"':
+			return sb.String()
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// templateSideHustles советы по подработке для выпуска, собранного без модели
+var templateSideHustles = []SideHustleTip{
+	{"Продажа ненужных вещей", "Разбери шкаф и антресоли: одежда, техника и книги на Авито могут принести несколько тысяч рублей за выходные 📦", "перепродажа"},
+	{"Кешбэк и бонусы", "Подключи повышенный кешбэк в категориях, где тратишь больше всего, и переводи его на брокерский счет 💳", "кешбэк"},
+	{"Репетиторство онлайн", "Если хорошо знаешь школьный предмет или язык, пара уроков в неделю на онлайн-платформах - стабильная прибавка к бюджету 📚", "репетиторство"},
+	{"Тестирование сайтов и приложений", "Сервисы юзабилити-тестирования платят за записи того, как ты пользуешься сайтом, - задания занимают 15-20 минут 🧪", "тестирование"},
+	{"Фото для фотобанков", "Удачные снимки на телефон можно загрузить на фотобанки и получать отчисления за каждую покупку 📷", "фотобанки"},
+}
+
+// TemplateReport собирает выпуск из данных биржи без обращения к модели: на
+// случай, когда месячный лимит расходов на AI исчерпан
+func TemplateReport(data *MarketData, date string, budget float64) *AnalyticsReport {
+	trend := map[string]string{
+		"up":     "рынок растет 📈",
+		"down":   "рынок снижается 📉",
+		"stable": "рынок движется в боковике ➡️",
+	}[data.MarketTrend]
+	if trend == "" {
+		trend = "рынок без выраженного тренда"
+	}
+
+	report := &AnalyticsReport{
+		Date:     date,
+		Greeting: "Привет! 👋 Сегодня короткий выпуск по данным Московской биржи.",
+		MarketSummary: fmt.Sprintf("Индекс Мосбиржи - %s п., индекс РТС - %s п., %s. Доллар - %s ₽, евро - %s ₽.",
+			formatMoney(data.IndexMOEX), formatMoney(data.IndexRTS), trend, formatMoney(data.USDRate), formatMoney(data.EURRate)),
+		SideHustle:   templateSideHustles[0],
+		Disclaimer:   "Это не индивидуальная инвестиционная рекомендация. Перед покупкой оцени риски самостоятельно.",
+		FromTemplate: true,
+		Budget:       budget,
+	}
+	if day, err := time.Parse(calendarDateLayout, date); err == nil {
+		// Советы чередуются по дням
+		report.SideHustle = templateSideHustles[day.YearDay()%len(templateSideHustles)]
+	}
+
+	stock := data.RecommendedStock
+	if stock.Ticker != "" && stock.Price > 0 && stock.LotCost() <= budget {
+		lotSize := stock.LotSize
+		if lotSize < 1 {
+			lotSize = 1
+		}
+		lots := int(budget / stock.LotCost())
+		report.Recommendation = Recommendation{
+			Instrument: stock.Name,
+			Type:       InstrumentShare,
+			Ticker:     stock.Ticker,
+			Price:      stock.Price,
+			LotSize:    lotSize,
+			Lots:       lots,
+			Total:      math.Round(stock.LotCost()*float64(lots)*100) / 100,
+		}
+		report.Rationale = fmt.Sprintf("%s - лидер роста среди самых торгуемых акций, которые укладываются в бюджет: %+.2f%% за день.",
+			stock.Name, stock.Change)
+		if stock.LotSize == 0 {
+			report.Rationale += " Уточни размер лота в приложении брокера перед покупкой."
+		}
+	} else {
+		report.Recommendation = Recommendation{
+			Instrument: "Накопительный счет или вклад",
+			Type:       InstrumentDeposit,
+			Total:      budget,
+		}
+		report.Rationale = "Подходящей акции в пределах бюджета сегодня нет, поэтому надежнее отложить деньги под процент и дождаться удобного момента."
+	}
+	return report
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Границы бюджета, который можно выбрать командой /budget, в рублях
+const (
+	minBudget = 100.0
+	maxBudget = 10000000.0
+)
+
+// budgetOrDefault возвращает бюджет или defaultBudget, если бюджет не выбран
+func budgetOrDefault(budget float64) float64 {
+	if budget <= 0 {
+		return defaultBudget
+	}
+	return budget
+}
+
+// budgetTiers суммы, на которые генерируются выпуски рассылки. Бюджет чата,
+// близкий к одной из них, округляется до нее вниз, чтобы число вариантов
+// выпуска не росло с каждой новой суммой подписчика.
+var budgetTiers = []float64{
+	100, 200, 300, 500,
+	1000, 2000, 3000, 5000,
+	10000, 20000, 30000, 50000,
+	100000, 200000, 300000, 500000,
+	1000000, 2000000, 3000000, 5000000, 10000000,
+}
+
+// budgetTierTolerance насколько ступень может быть меньше бюджета чата
+const budgetTierTolerance = 0.1
+
+// budgetTier возвращает сумму, на которую генерируется выпуск рассылки для
+// бюджета чата: наибольшую ступень из budgetTiers, которая не превышает бюджет
+// и меньше его не больше чем на budgetTierTolerance, а если такой нет - сам
+// бюджет. Рекомендация выпуска укладывается в бюджет чата и не намного меньше его.
+func budgetTier(budget float64) float64 {
+	budget = budgetOrDefault(budget)
+	tier := 0.0
+	for _, t := range budgetTiers {
+		if t > budget {
+			break
+		}
+		tier = t
+	}
+	if tier < budget*(1-budgetTierTolerance) {
+		return budget
+	}
+	return tier
+}
+
+// budgetNumber сумма после удаления разделителей: цифры и, возможно, дробная часть
+var budgetNumber = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?$`)
+
+// budgetSuffixes обозначения рублей, которые можно указать после суммы
+var budgetSuffixes = []string{"рублей", "рубля", "рубль", "руб.", "руб", "р.", "р", "₽", "rub"}
+
+// ParseBudget распознает сумму в рублях: "50000", "50 000", "50 000 ₽",
+// "1500,50 руб" или "50к". Сумма округляется до рубля и должна быть в
+// пределах от minBudget до maxBudget.
+func ParseBudget(s string) (float64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for _, suffix := range budgetSuffixes {
+		if strings.HasSuffix(s, suffix) {
+			s = strings.TrimSuffix(s, suffix)
+			break
+		}
+	}
+	s = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\u00a0', '\u202f', '_':
+			return -1
+		case ',':
+			return '.'
+		}
+		return r
+	}, s)
+
+	multiplier := 1.0
+	for _, suffix := range []string{"к", "k", "тыс.", "тыс"} {
+		if strings.HasSuffix(s, suffix) {
+			s = strings.TrimSuffix(s, suffix)
+			multiplier = 1000
+			break
+		}
+	}
+
+	// Только цифры: ParseFloat принял бы и "1e3", "0x10" или "Inf"
+	if !budgetNumber.MatchString(s) {
+		return 0, fmt.Errorf("не похоже на сумму в рублях")
+	}
+	amount, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("не похоже на сумму в рублях")
+	}
+	amount = math.Round(amount * multiplier)
+	if amount < minBudget || amount > maxBudget {
+		return 0, fmt.Errorf("сумма должна быть от %s до %s рублей", formatMoney(minBudget), formatMoney(maxBudget))
+	}
+	return amount, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseBudget(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    float64
+		wantErr bool
+	}{
+		{"50000", 50000, false},
+		{"50 000", 50000, false},
+		{"50 000 ₽", 50000, false},
+		{"50 000 руб.", 50000, false},
+		{"1500,50 руб", 1501, false},
+		{"1500.4", 1500, false},
+		{"50к", 50000, false},
+		{"2,5 тыс", 2500, false},
+		{"100 р", 100, false},
+		{"10 000 000 рублей", 10000000, false},
+		{"99", 0, true},
+		{"20000000", 0, true},
+		{"-500", 0, true},
+		{"", 0, true},
+		{"много", 0, true},
+		{"NaN", 0, true},
+		{"Inf", 0, true},
+		{"1e3", 0, true},
+		{"0x1F4", 0, true},
+		{"+500", 0, true},
+		{"1.000.000", 0, true},
+		{"1 000,5,5", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseBudget(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseBudget(%q) ошибка = %v, ожидалась ошибка: %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseBudget(%q) = %v, ожидалось %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBudgetTier(t *testing.T) {
+	tests := []struct {
+		budget float64
+		want   float64
+	}{
+		{0, defaultBudget},
+		{100, 100},
+		{105, 100},
+		{150, 150},
+		{1000, 1000},
+		{1500, 1500},
+		{5400, 5000},
+		{9000, 9000},
+		{10800, 10000},
+		{75000, 75000},
+		{999999, 999999},
+		{maxBudget, maxBudget},
+	}
+
+	for _, tt := range tests {
+		if got := budgetTier(tt.budget); got != tt.want {
+			t.Errorf("budgetTier(%v) = %v, ожидалось %v", tt.budget, got, tt.want)
+		}
+	}
+}
+
+func TestRenderIssueBudget(t *testing.T) {
+	report := &AnalyticsReport{
+		Date:           "2026-10-16",
+		MarketSummary:  "Рынок стоит на месте.",
+		Recommendation: Recommendation{Instrument: "Сбербанк", Type: "share", Ticker: "SBER", Price: 300, LotSize: 10, Lots: 3, Total: 9000},
+		Budget:         10000,
+	}
+
+	text, err := RenderIssue(report, DefaultPersona, 10800)
+	if err != nil {
+		t.Fatalf("RenderIssue: %v", err)
+	}
+	if !strings.Contains(text, "Куда вложить "+formatMoney(10800)) {
+		t.Errorf("заголовок рекомендации без бюджета чата:\n%s", text)
+	}
+	if report.Budget != 10000 {
+		t.Errorf("RenderIssue изменил общий выпуск: бюджет %v", report.Budget)
+	}
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// complianceRetention сколько хранятся записи журнала вмешательств
+const complianceRetention = 400 * 24 * time.Hour
+
+// Ограничения отчета /compliance
+const (
+	defaultComplianceDays = 7
+	maxComplianceDays     = 90
+	maxComplianceRecent   = 10 // последних вмешательств в отчете
+)
+
+// Действия правил и вмешательства, которые записываются в журнал
+const (
+	ComplianceRewrite    = "rewrite"    // фраза заменяется на допустимую
+	ComplianceBlock      = "block"      // сообщение не отправляется
+	ComplianceRegenerate = "regenerate" // модель переписывает выпуск из-за запрещенной фразы
+	ComplianceDisclaimer = "disclaimer" // добавлена оговорка, которой не было
+)
+
+// complianceActions допустимые действия правил
+var complianceActions = map[string]bool{
+	ComplianceRewrite: true,
+	ComplianceBlock:   true,
+}
+
+// defaultDisclaimerPattern распознает оговорку, что текст не является
+// инвестиционной рекомендацией
+const defaultDisclaimerPattern = `не\s+(?:является\s+)?(?:индивидуальн\S*\s+)?инвестиционн\S*\s+(?:рекомендаци|совет)`
+
+// ErrComplianceBlocked сообщение содержит запрещенное утверждение и не отправляется
+var ErrComplianceBlocked = errors.New("сообщение заблокировано проверкой соответствия требованиям")
+
+// ComplianceRecord запись журнала о вмешательстве в сообщение
+type ComplianceRecord struct {
+	Time     time.Time `json:"time"`
+	Target   string    `json:"target"`             // что проверялось: выпуск daily, ответ на вопрос
+	Field    string    `json:"field,omitempty"`    // поле выпуска
+	Rule     string    `json:"rule,omitempty"`     // сработавшее правило
+	Action   string    `json:"action"`             // rewrite, block, regenerate, disclaimer
+	Fragment string    `json:"fragment,omitempty"` // найденная фраза
+	UserID   int64     `json:"user_id,omitempty"`
+	ChatID   int64     `json:"chat_id,omitempty"`
+	Job      string    `json:"job,omitempty"`
+}
+
+// complianceRule правило с разобранным регулярным выражением
+type complianceRule struct {
+	ComplianceRule
+	re *regexp.Regexp
+}
+
+// complianceMatch фраза, найденная правилом
+type complianceMatch struct {
+	rule     *complianceRule
+	field    string
+	fragment string
+}
+
+// Compliance проверяет сообщения после генерации: добавляет оговорку, что это
+// не инвестиционная рекомендация, заменяет или блокирует запрещенные
+// утверждения и записывает каждое вмешательство в журнал
+type Compliance struct {
+	config     ComplianceConfig
+	rules      []complianceRule
+	disclaimer *regexp.Regexp
+	storage    Storage // журнал вмешательств, nil - только лог
+}
+
+// NewCompliance создает проверку с правилами из настроек; если проверка
+// отключена, возвращает nil
+func NewCompliance(config ComplianceConfig, storage Storage) (*Compliance, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	c := &Compliance{config: config, storage: storage}
+	var err error
+	if c.disclaimer, err = compileCompliancePattern(config.DisclaimerPattern); err != nil {
+		return nil, fmt.Errorf("некорректный шаблон оговорки: %w", err)
+	}
+	for _, rule := range config.Rules {
+		re, err := compileCompliancePattern(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("некорректное правило %s: %w", rule.Name, err)
+		}
+		c.rules = append(c.rules, complianceRule{ComplianceRule: rule, re: re})
+	}
+	return c, nil
+}
+
+// compileCompliancePattern разбирает регулярное выражение правила без учета регистра
+func compileCompliancePattern(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("(?i)" + pattern)
+}
+
+// Blocked возвращает ошибку со списком запрещенных утверждений выпуска, если
+// они есть: с ней модель переписывает выпуск. Попытка записывается в журнал.
+func (c *Compliance) Blocked(ctx context.Context, report *AnalyticsReport, kind AnalyticsKind) error {
+	if c == nil {
+		return nil
+	}
+
+	var lines []string
+	for _, match := range c.find(complianceFields(report), ComplianceBlock) {
+		c.record(ctx, ComplianceRecord{
+			Target:   "выпуск " + string(kind),
+			Field:    match.field,
+			Rule:     match.rule.Name,
+			Action:   ComplianceRegenerate,
+			Fragment: match.fragment,
+		})
+		lines = append(lines, fmt.Sprintf("- «%s» (поле %s)", match.fragment, match.field))
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return fmt.Errorf("выпуск содержит недопустимые утверждения: обещания доходности, отсутствия риска или роста цены и ссылки на инсайдерскую информацию. Перепиши эти места:\n%s",
+		strings.Join(lines, "\n"))
+}
+
+// Report проверяет выпуск перед оформлением: заменяет запрещенные фразы и
+// подставляет оговорку из настроек, если модель ее не написала. Если в выпуске
+// осталось утверждение, которое нужно блокировать, возвращает ErrComplianceBlocked.
+func (c *Compliance) Report(ctx context.Context, report *AnalyticsReport, kind AnalyticsKind) error {
+	if c == nil {
+		return nil
+	}
+	target := "выпуск " + string(kind)
+
+	if blocked := c.find(complianceFields(report), ComplianceBlock); len(blocked) > 0 {
+		for _, match := range blocked {
+			c.record(ctx, ComplianceRecord{Target: target, Field: match.field, Rule: match.rule.Name, Action: ComplianceBlock, Fragment: match.fragment})
+		}
+		return fmt.Errorf("%w: правило %s, «%s»", ErrComplianceBlocked, blocked[0].rule.Name, blocked[0].fragment)
+	}
+
+	for _, field := range complianceFields(report) {
+		*field.text = c.rewrite(ctx, *field.text, target, field.name)
+	}
+
+	if c.config.Disclaimer != "" && !c.disclaimer.MatchString(report.Disclaimer) {
+		c.record(ctx, ComplianceRecord{Target: target, Field: "disclaimer", Action: ComplianceDisclaimer, Fragment: report.Disclaimer})
+		report.Disclaimer = c.config.Disclaimer
+	}
+	return nil
+}
+
+// Answer проверяет ответ на вопрос: заменяет запрещенные фразы и добавляет
+// в конец оговорку из настроек, если ее нет. Ответ с утверждением, которое
+// нужно блокировать, не возвращается: вместо него ErrComplianceBlocked.
+func (c *Compliance) Answer(ctx context.Context, text string) (string, error) {
+	if c == nil {
+		return text, nil
+	}
+	const target = "ответ на вопрос"
+
+	fields := []reportField{{"text", &text}}
+	if blocked := c.find(fields, ComplianceBlock); len(blocked) > 0 {
+		for _, match := range blocked {
+			c.record(ctx, ComplianceRecord{Target: target, Rule: match.rule.Name, Action: ComplianceBlock, Fragment: match.fragment})
+		}
+		return "", fmt.Errorf("%w: правило %s, «%s»", ErrComplianceBlocked, blocked[0].rule.Name, blocked[0].fragment)
+	}
+
+	text = c.rewrite(ctx, text, target, "")
+	if c.config.Disclaimer != "" && !c.disclaimer.MatchString(text) {
+		c.record(ctx, ComplianceRecord{Target: target, Action: ComplianceDisclaimer})
+		text += "\n\n" + c.config.Disclaimer
+	}
+	return text, nil
+}
+
+// Preview оборачивает onText, чтобы промежуточный текст проверялся до показа
+// в чате: запрещенные фразы заменяются без записи в журнал (запишется итоговая
+// проверка), а текст с утверждением, которое нужно блокировать, не показывается
+func (c *Compliance) Preview(onText StreamFunc) StreamFunc {
+	if c == nil || onText == nil {
+		return onText
+	}
+	return func(text string) {
+		fields := []reportField{{"text", &text}}
+		if len(c.find(fields, ComplianceBlock)) > 0 {
+			return
+		}
+		onText(c.replace(text, nil))
+	}
+}
+
+// find возвращает фразы, найденные правилами с действием action
+func (c *Compliance) find(fields []reportField, action string) []complianceMatch {
+	var matches []complianceMatch
+	for i := range c.rules {
+		rule := &c.rules[i]
+		if rule.Action != action {
+			continue
+		}
+		for _, field := range fields {
+			for _, fragment := range rule.re.FindAllString(*field.text, -1) {
+				matches = append(matches, complianceMatch{rule: rule, field: field.name, fragment: fragment})
+			}
+		}
+	}
+	return matches
+}
+
+// rewrite заменяет фразы, найденные правилами rewrite, и записывает каждую
+// замену в журнал
+func (c *Compliance) rewrite(ctx context.Context, text, target, field string) string {
+	return c.replace(text, func(rule *complianceRule, match string) {
+		c.record(ctx, ComplianceRecord{Target: target, Field: field, Rule: rule.Name, Action: ComplianceRewrite, Fragment: match})
+	})
+}
+
+// replace заменяет фразы, найденные правилами rewrite: в замене подставляются
+// группы ($1, ${name}), а регистр берется из исходной фразы (matchCase). onMatch, если задан, вызывается для каждой замены.
+func (c *Compliance) replace(text string, onMatch func(rule *complianceRule, match string)) string {
+	for i := range c.rules {
+		rule := &c.rules[i]
+		if rule.Action != ComplianceRewrite {
+			continue
+		}
+		locs := rule.re.FindAllStringSubmatchIndex(text, -1)
+		if len(locs) == 0 {
+			continue
+		}
+
+		var sb strings.Builder
+		last := 0
+		for _, loc := range locs {
+			match := text[loc[0]:loc[1]]
+			replacement := string(rule.re.ExpandString(nil, rule.Replacement, text, loc))
+			sb.WriteString(text[last:loc[0]])
+			sb.WriteString(matchCase(match, replacement))
+			last = loc[1]
+			if onMatch != nil {
+				onMatch(rule, match)
+			}
+		}
+		sb.WriteString(text[last:])
+		text = sb.String()
+	}
+	return text
+}
+
+// matchCase переносит регистр исходной фразы на замену: фраза заглавными
+// буквами заменяется заглавными, а с заглавной буквы - с заглавной
+func matchCase(original, replacement string) string {
+	if strings.ToUpper(original) == original && strings.ToLower(original) != original {
+		return strings.ToUpper(replacement)
+	}
+	first, _ := utf8.DecodeRuneInString(original)
+	if !unicode.IsUpper(first) || replacement == "" {
+		return replacement
+	}
+	r, size := utf8.DecodeRuneInString(replacement)
+	return string(unicode.ToUpper(r)) + replacement[size:]
+}
+
+// record пишет вмешательство в лог и журнал
+func (c *Compliance) record(ctx context.Context, record ComplianceRecord) {
+	source := usageSourceFrom(ctx)
+	record.Time = time.Now()
+	record.UserID = source.UserID
+	record.ChatID = source.ChatID
+	record.Job = source.Job
+	record.Fragment = truncate(record.Fragment, 200)
+
+	log.Printf("Проверка соответствия: %s, %s %s %q", record.Target, record.Action, record.Rule, record.Fragment)
+	if c.storage == nil {
+		return
+	}
+	if err := c.storage.RecordCompliance(record); err != nil {
+		log.Printf("Ошибка записи в журнал проверки соответствия: %v", err)
+	}
+}
+
+// complianceFields возвращает поля выпуска, в которых ищутся запрещенные фразы
+func complianceFields(report *AnalyticsReport) []reportField {
+	return append(reportTextFields(report),
+		reportField{"side_hustle.title", &report.SideHustle.Title},
+		reportField{"disclaimer", &report.Disclaimer},
+	)
+}
+
+// ComplianceReport вмешательства за несколько дней
+type ComplianceReport struct {
+	Days     int
+	Total    int
+	ByRule   map[string]int // ключ - правило и действие
+	Recent   []ComplianceRecord
+	Disabled bool
+}
+
+// Audit собирает отчет о вмешательствах за последние days дней
+func (c *Compliance) Audit(days int) (*ComplianceReport, error) {
+	report := &ComplianceReport{Days: days, ByRule: make(map[string]int)}
+	if c == nil || c.storage == nil {
+		report.Disabled = true
+		return report, nil
+	}
+
+	now := time.Now().In(moscowLocation())
+	from := time.Date(now.Year(), now.Month(), now.Day()-days+1, 0, 0, 0, 0, now.Location())
+	records, err := c.storage.ComplianceLog(from)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, record := range records {
+		key := record.Action
+		if record.Rule != "" {
+			key = record.Rule + ", " + record.Action
+		}
+		report.ByRule[key]++
+		report.Total++
+	}
+	if len(records) > maxComplianceRecent {
+		records = records[len(records)-maxComplianceRecent:]
+	}
+	report.Recent = records
+	return report, nil
+}
+
+// formatComplianceReport выводит отчет /compliance
+func formatComplianceReport(report *ComplianceReport) string {
+	if report.Disabled {
+		return "🛡 Проверка соответствия требованиям отключена (COMPLIANCE_ENABLED=false)"
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("🛡 Проверка соответствия за %d дн.\n\n", report.Days))
+	if report.Total == 0 {
+		sb.WriteString("Вмешательств не было")
+		return sb.String()
+	}
+	sb.WriteString(fmt.Sprintf("Всего вмешательств: %d", report.Total))
+
+	keys := make([]string, 0, len(report.ByRule))
+	for key := range report.ByRule {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if report.ByRule[keys[i]] != report.ByRule[keys[j]] {
+			return report.ByRule[keys[i]] > report.ByRule[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	sb.WriteString("\n\n📋 По правилам:")
+	for _, key := range keys {
+		sb.WriteString(fmt.Sprintf("\n%s - %d", key, report.ByRule[key]))
+	}
+
+	sb.WriteString("\n\n🕓 Последние:")
+	for i := len(report.Recent) - 1; i >= 0; i-- {
+		record := report.Recent[i]
+		line := fmt.Sprintf("\n%s %s: %s", record.Time.In(moscowLocation()).Format("02.01 15:04"), record.Target, record.Action)
+		if record.Rule != "" {
+			line += " " + record.Rule
+		}
+		if record.Fragment != "" {
+			line += fmt.Sprintf(" «%s»", record.Fragment)
+		}
+		sb.WriteString(line)
+	}
+	return sb.String()
+}
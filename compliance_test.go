@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// testCompliance проверка с правилами и оговоркой по умолчанию без журнала
+func testCompliance(t *testing.T) *Compliance {
+	t.Helper()
+	c, err := NewCompliance(DefaultConfig().Compliance, nil)
+	if err != nil {
+		t.Fatalf("NewCompliance: %v", err)
+	}
+	return c
+}
+
+func TestComplianceAnswer(t *testing.T) {
+	disclaimer := DefaultConfig().Compliance.Disclaimer
+
+	tests := []struct {
+		name        string
+		text        string
+		want        string
+		wantBlocked bool
+	}{
+		{
+			name: "гарантированная прибыль заменяется",
+			text: "Это гарантированная прибыль.",
+			want: "Это возможная прибыль.\n\n" + disclaimer,
+		},
+		{
+			name: "замена сохраняет заглавную букву",
+			text: "Гарантированный доход уже близко.",
+			want: "Возможный доход уже близко.\n\n" + disclaimer,
+		},
+		{
+			name: "окончание подставляется из найденной фразы",
+			text: "Беспроигрышные стратегии.",
+			want: "Перспективные стратегии.\n\n" + disclaimer,
+		},
+		{
+			name: "правило без учета регистра",
+			text: "Стратегия БЕСПРОИГРЫШНАЯ.",
+			want: "Стратегия ПЕРСПЕКТИВНАЯ.\n\n" + disclaimer,
+		},
+		{
+			name: "оговорка не дублируется",
+			text: "Сбер выглядит интересно. Это не является инвестиционной рекомендацией.",
+			want: "Сбер выглядит интересно. Это не является инвестиционной рекомендацией.",
+		},
+		{
+			name:        "отсутствие риска блокируется",
+			text:        "Сделка без всякого риска.",
+			wantBlocked: true,
+		},
+		{
+			name:        "обещание роста цены блокируется",
+			text:        "Акции точно вырастут к лету.",
+			wantBlocked: true,
+		},
+		{
+			name:        "инсайдерская информация блокируется",
+			text:        "У меня есть Инсайд по Газпрому.",
+			wantBlocked: true,
+		},
+	}
+
+	c := testCompliance(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.Answer(context.Background(), tt.text)
+			if blocked := errors.Is(err, ErrComplianceBlocked); blocked != tt.wantBlocked {
+				t.Fatalf("Answer(%q) ошибка = %v, ожидалась блокировка: %v", tt.text, err, tt.wantBlocked)
+			}
+			if got != tt.want {
+				t.Errorf("Answer(%q) = %q, ожидалось %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComplianceReport(t *testing.T) {
+	disclaimer := DefaultConfig().Compliance.Disclaimer
+
+	tests := []struct {
+		name           string
+		report         AnalyticsReport
+		wantRationale  string
+		wantDisclaimer string
+		wantRegenerate bool // Blocked просит модель переписать выпуск
+		wantBlocked    bool // Report блокирует выпуск
+	}{
+		{
+			name:           "замена фразы и подстановка оговорки",
+			report:         AnalyticsReport{Rationale: "Дивиденды дают гарантированный доход."},
+			wantRationale:  "Дивиденды дают возможный доход.",
+			wantDisclaimer: disclaimer,
+		},
+		{
+			name:           "оговорка модели сохраняется",
+			report:         AnalyticsReport{Rationale: "Фонд диверсифицирован.", Disclaimer: "Не инвестиционный совет."},
+			wantRationale:  "Фонд диверсифицирован.",
+			wantDisclaimer: "Не инвестиционный совет.",
+		},
+		{
+			name:           "запрещенное утверждение в совете по подработке",
+			report:         AnalyticsReport{SideHustle: SideHustleTip{Title: "Заработок без риска"}},
+			wantRegenerate: true,
+			wantBlocked:    true,
+		},
+	}
+
+	c := testCompliance(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := tt.report
+			if err := c.Blocked(context.Background(), &report, AnalyticsDaily); (err != nil) != tt.wantRegenerate {
+				t.Errorf("Blocked() = %v, ожидалась ошибка: %v", err, tt.wantRegenerate)
+			}
+
+			err := c.Report(context.Background(), &report, AnalyticsDaily)
+			if blocked := errors.Is(err, ErrComplianceBlocked); blocked != tt.wantBlocked {
+				t.Fatalf("Report() ошибка = %v, ожидалась блокировка: %v", err, tt.wantBlocked)
+			}
+			if tt.wantBlocked {
+				return
+			}
+			if report.Rationale != tt.wantRationale {
+				t.Errorf("rationale = %q, ожидалось %q", report.Rationale, tt.wantRationale)
+			}
+			if report.Disclaimer != tt.wantDisclaimer {
+				t.Errorf("disclaimer = %q, ожидалось %q", report.Disclaimer, tt.wantDisclaimer)
+			}
+		})
+	}
+}
+
+func TestCompliancePreview(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		want     string
+		wantShow bool
+	}{
+		{"текст без нарушений", "Рынок растет", "Рынок растет", true},
+		{"замена до показа", "Гарантированная прибыль", "Возможная прибыль", true},
+		{"блокируемый текст не показывается", "Бумага точно подорожает", "", false},
+	}
+
+	c := testCompliance(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			shown := false
+			c.Preview(func(text string) {
+				got = text
+				shown = true
+			})(tt.text)
+
+			if shown != tt.wantShow {
+				t.Fatalf("Preview(%q) показан = %v, ожидалось %v", tt.text, shown, tt.wantShow)
+			}
+			if got != tt.want {
+				t.Errorf("Preview(%q) = %q, ожидалось %q", tt.text, got, tt.want)
+			}
+		})
+	}
+
+	var disabled *Compliance
+	if disabled.Preview(nil) != nil {
+		t.Error("Preview(nil) отключенной проверки должен возвращать nil")
+	}
+}
@@ -0,0 +1,815 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigFile файл настроек, который читается, если CONFIG_FILE не задан
+const defaultConfigFile = "config.yaml"
+
+// scheduleDisabled значение расписания, отключающее задачу
+const scheduleDisabled = "off"
+
+// Config содержит все настройки бота
+type Config struct {
+	Telegram   TelegramConfig       `yaml:"telegram"`
+	AI         AIConfig             `yaml:"ai"`
+	MarketData MarketDataConfig     `yaml:"market_data"`
+	FactCheck  FactCheckConfig      `yaml:"fact_check"`
+	Compliance ComplianceConfig     `yaml:"compliance"`
+	Cache      AnalyticsCacheConfig `yaml:"cache"`
+	History    HistoryConfig        `yaml:"history"`
+	Prompts    PromptsConfig        `yaml:"prompts"`
+	Chat       ChatConfig           `yaml:"chat"`
+	Usage      UsageConfig          `yaml:"usage"`
+	Schedule   ScheduleConfig       `yaml:"schedule"`
+	Storage    StorageConfig        `yaml:"storage"`
+	Owners     []int64              `yaml:"owners"` // Telegram ID владельцев бота
+	Admins     []int64              `yaml:"admins"` // Telegram ID администраторов
+	// ShutdownTimeout сколько времени после SIGINT/SIGTERM бот ждет завершения
+	// обработки сообщений и текущей рассылки
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+// TelegramConfig содержит настройки Telegram бота
+type TelegramConfig struct {
+	Token     string `yaml:"token"`
+	Workers   int    `yaml:"workers"`    // обработчиков обновлений параллельно
+	QueueSize int    `yaml:"queue_size"` // максимум обновлений в очереди
+	// Лимиты исходящих сообщений при рассылках
+	GlobalRate  int `yaml:"global_rate"`  // сообщений в секунду всего
+	GroupRate   int `yaml:"group_rate"`   // сообщений в минуту в одну группу
+	SendRetries int `yaml:"send_retries"` // повторов при временных ошибках и флуд-контроле
+}
+
+// AIConfig содержит настройки основного провайдера AI и резервных провайдеров,
+// к которым бот по очереди обращается, если основной вернул ошибку
+type AIConfig struct {
+	ProviderConfig `yaml:",inline"`
+	Fallbacks      []ProviderConfig `yaml:"fallbacks"`
+
+	Timeout          time.Duration `yaml:"timeout"`           // максимальное время генерации с учетом повторов и резервных провайдеров
+	AttemptTimeout   time.Duration `yaml:"attempt_timeout"`   // максимальное время одного запроса к провайдеру
+	MaxRetries       int           `yaml:"max_retries"`       // повторов при 429, 5xx и сетевых ошибках
+	BreakerThreshold int           `yaml:"breaker_threshold"` // ошибок подряд до временного отключения провайдера
+	BreakerCooldown  time.Duration `yaml:"breaker_cooldown"`  // на сколько отключается провайдер
+	OutputRetries    int           `yaml:"output_retries"`    // повторных запросов, если ответ не прошел проверку схемы
+	Tools            ToolsConfig   `yaml:"tools"`
+}
+
+// ToolsConfig содержит настройки инструментов, которыми модель сама
+// запрашивает котировки, свечи, дивиденды и новости во время генерации
+type ToolsConfig struct {
+	Enabled      bool          `yaml:"enabled"`
+	MaxCalls     int           `yaml:"max_calls"`     // вызовов инструментов на один ответ модели
+	CallTimeout  time.Duration `yaml:"call_timeout"`  // максимальное время одного вызова
+	TotalTimeout time.Duration `yaml:"total_timeout"` // сколько всего можно потратить на вызовы в одном ответе
+}
+
+// ProviderConfig содержит настройки доступа к одному провайдеру AI
+type ProviderConfig struct {
+	Provider string `yaml:"provider"` // openai, anthropic, ollama, gigachat, yandexgpt
+	APIKey   string `yaml:"api_key"`
+	Model    string `yaml:"model"`
+	BaseURL  string `yaml:"base_url"`  // полный URL метода генерации, по умолчанию зависит от провайдера
+	FolderID string `yaml:"folder_id"` // каталог Yandex Cloud (только yandexgpt)
+	Scope    string `yaml:"scope"`     // область доступа GigaChat API (только gigachat)
+}
+
+// MarketDataConfig содержит адреса и ключи источников рыночных данных
+type MarketDataConfig struct {
+	ISSBaseURL          string `yaml:"iss_base_url"`
+	NewsAPIKey          string `yaml:"news_api_key"`
+	GNewsAPIKey         string `yaml:"gnews_api_key"`
+	TradingCalendarFile string `yaml:"trading_calendar_file"` // пусто - DATA_DIR/trading_calendar.json
+	// SnapshotTTL сколько времени снимок рынка переиспользуется без повторных
+	// запросов к бирже, 0 - данные запрашиваются каждый раз
+	SnapshotTTL time.Duration `yaml:"snapshot_ttl"`
+}
+
+// FactCheckConfig содержит настройки сверки выпуска с данными биржи. Допуски
+// задаются долей от фактического значения: 0.03 - расхождение до 3%.
+type FactCheckConfig struct {
+	Mode           string  `yaml:"mode"`            // regenerate, correct, flag или off
+	PriceTolerance float64 `yaml:"price_tolerance"` // цены акций
+	IndexTolerance float64 `yaml:"index_tolerance"` // индексы IMOEX и RTS
+	FXTolerance    float64 `yaml:"fx_tolerance"`    // курсы доллара и евро
+}
+
+// ComplianceConfig содержит настройки проверки выпусков и ответов на соответствие
+// требованиям: обязательная оговорка и запрещенные утверждения
+type ComplianceConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Disclaimer оговорка, которая добавляется, если модель ее не написала;
+	// пусто - оговорка не добавляется
+	Disclaimer string `yaml:"disclaimer"`
+	// DisclaimerPattern регулярное выражение, по которому оговорка распознается в тексте
+	DisclaimerPattern string `yaml:"disclaimer_pattern"`
+	// Rules запрещенные утверждения; список из файла настроек заменяет встроенный
+	Rules []ComplianceRule `yaml:"rules"`
+}
+
+// ComplianceRule правило для запрещенного утверждения. Шаблон - регулярное
+// выражение RE2, регистр не учитывается.
+type ComplianceRule struct {
+	Name        string `yaml:"name"`
+	Pattern     string `yaml:"pattern"`
+	Action      string `yaml:"action"`      // rewrite или block
+	Replacement string `yaml:"replacement"` // для rewrite; $1 - группа из шаблона
+}
+
+// AnalyticsCacheConfig содержит настройки кэша выпусков аналитики. Пороги
+// задаются долей: выпуск генерируется заново, если значение изменилось больше.
+type AnalyticsCacheConfig struct {
+	TTL            time.Duration `yaml:"ttl"`             // время жизни выпуска, 0 - кэш отключен
+	IndexThreshold float64       `yaml:"index_threshold"` // индексы IMOEX и RTS
+	FXThreshold    float64       `yaml:"fx_threshold"`    // курсы доллара и евро
+	PriceThreshold float64       `yaml:"price_threshold"` // цены акций
+}
+
+// HistoryConfig содержит настройки истории рекомендаций
+type HistoryConfig struct {
+	// WindowDays сколько дней рекомендованный инструмент и тема подработки
+	// не должны повторяться, 0 - история не используется
+	WindowDays int `yaml:"window_days"`
+}
+
+// PromptsConfig содержит настройки шаблонов промптов
+type PromptsConfig struct {
+	// Dir каталог с файлами <название>.v<версия>.tmpl, которые дополняют
+	// и переопределяют встроенные шаблоны; пусто - только встроенные
+	Dir string `yaml:"dir"`
+	// Versions закрепленные версии шаблонов по названию; по умолчанию последняя
+	Versions map[string]int `yaml:"versions"`
+}
+
+// ChatConfig содержит настройки режима вопросов: бот отвечает на обычные
+// сообщения с учетом данных биржи и помнит диалог в каждом чате
+type ChatConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// MaxMessages после скольких сообщений старая часть диалога пересказывается
+	// моделью кратким содержанием
+	MaxMessages  int           `yaml:"max_messages"`
+	KeepMessages int           `yaml:"keep_messages"` // последних сообщений, которые остаются дословно
+	IdleTimeout  time.Duration `yaml:"idle_timeout"`  // после такой паузы диалог начинается заново, 0 - никогда
+	RateLimit    int           `yaml:"rate_limit"`    // вопросов от одного пользователя за RateWindow
+	RateWindow   time.Duration `yaml:"rate_window"`
+	MaxQuestion  int           `yaml:"max_question"` // максимальная длина вопроса в символах
+}
+
+// UsageConfig содержит настройки учета расходов на AI
+type UsageConfig struct {
+	Currency     string                `yaml:"currency"`      // валюта цен и лимита, только для отчетов
+	Prices       map[string]ModelPrice `yaml:"prices"`        // цены по моделям; ключ - модель или начало ее названия
+	MonthlyLimit float64               `yaml:"monthly_limit"` // лимит расходов за календарный месяц, 0 - без лимита
+	// OverLimitModel более дешевая модель основного провайдера, на которую бот
+	// переходит после превышения лимита; пусто - выпуски собираются по шаблону без AI
+	OverLimitModel string `yaml:"over_limit_model"`
+}
+
+// ModelPrice цена модели за миллион токенов
+type ModelPrice struct {
+	Prompt     float64 `yaml:"prompt"`     // токены запроса
+	Completion float64 `yaml:"completion"` // токены ответа
+}
+
+// ScheduleConfig содержит настройки расписания рассылок
+type ScheduleConfig struct {
+	Timezone     string `yaml:"timezone"`   // часовой пояс cron-выражений
+	DailyHour    int    `yaml:"daily_hour"` // время ежедневной аналитики по умолчанию
+	DailyMinute  int    `yaml:"daily_minute"`
+	PreMarket    string `yaml:"premarket"` // обзор перед открытием торгов (только в торговые дни)
+	PostClose    string `yaml:"postclose"` // итоги торговой сессии (только в торговые дни)
+	WeeklyDigest string `yaml:"weekly"`    // воскресный дайджест
+	// CatchUpGrace сколько времени после пропущенного из-за простоя запуска
+	// рассылка еще может быть отправлена
+	CatchUpGrace time.Duration `yaml:"catchup_grace_period"`
+}
+
+// StorageConfig содержит пути к данным бота
+type StorageConfig struct {
+	DataDir string `yaml:"data_dir"`
+}
+
+// DefaultConfig возвращает настройки по умолчанию
+func DefaultConfig() Config {
+	return Config{
+		Telegram: TelegramConfig{
+			Workers:     8,
+			QueueSize:   100,
+			GlobalRate:  25, // Telegram допускает около 30, оставляем запас для ответов на команды
+			GroupRate:   20,
+			SendRetries: 3,
+		},
+		AI: AIConfig{
+			ProviderConfig: ProviderConfig{
+				Provider: ProviderOpenAI,
+			},
+			Timeout:          3 * time.Minute,
+			AttemptTimeout:   90 * time.Second,
+			MaxRetries:       2,
+			BreakerThreshold: 3,
+			BreakerCooldown:  2 * time.Minute,
+			OutputRetries:    2,
+			Tools: ToolsConfig{
+				Enabled:      true,
+				MaxCalls:     6,
+				CallTimeout:  10 * time.Second,
+				TotalTimeout: 30 * time.Second,
+			},
+		},
+		MarketData: MarketDataConfig{
+			ISSBaseURL:  "https://iss.moex.com/iss",
+			SnapshotTTL: 5 * time.Minute,
+		},
+		FactCheck: FactCheckConfig{
+			Mode:           FactCheckRegenerate,
+			PriceTolerance: 0.03,
+			IndexTolerance: 0.02,
+			FXTolerance:    0.02,
+		},
+		Compliance: ComplianceConfig{
+			Enabled:           true,
+			Disclaimer:        "Информация носит ознакомительный характер и не является индивидуальной инвестиционной рекомендацией.",
+			DisclaimerPattern: defaultDisclaimerPattern,
+			Rules: []ComplianceRule{
+				{
+					Name:        "guaranteed_profit",
+					Pattern:     `гарантированн([а-яё]*)\s+((?:прибыл|доход|заработ)[а-яё]*)`,
+					Action:      ComplianceRewrite,
+					Replacement: "возможн${1} ${2}",
+				},
+				{
+					Name:        "no_lose",
+					Pattern:     `беспроигрышн([а-яё]*)`,
+					Action:      ComplianceRewrite,
+					Replacement: "перспективн${1}",
+				},
+				{
+					Name:    "risk_free",
+					Pattern: `без\s+(?:всякого\s+|какого-либо\s+|малейшего\s+)?риска`,
+					Action:  ComplianceBlock,
+				},
+				{
+					Name:    "price_promise",
+					Pattern: `(?:точно|обязательно|непременно|гарантированно)\s+(?:выраст|подорожа|взлет|выстрел|удво)[а-яё]*`,
+					Action:  ComplianceBlock,
+				},
+				{
+					Name:    "insider",
+					Pattern: `инсайд[а-яё]*`,
+					Action:  ComplianceBlock,
+				},
+			},
+		},
+		Cache: AnalyticsCacheConfig{
+			TTL:            3 * time.Hour,
+			IndexThreshold: 0.01,
+			FXThreshold:    0.01,
+			PriceThreshold: 0.03,
+		},
+		History: HistoryConfig{
+			WindowDays: 14,
+		},
+		Chat: ChatConfig{
+			Enabled:      true,
+			MaxMessages:  20,
+			KeepMessages: 6,
+			IdleTimeout:  24 * time.Hour,
+			RateLimit:    10,
+			RateWindow:   time.Hour,
+			MaxQuestion:  1000,
+		},
+		Usage: UsageConfig{
+			Currency: "USD",
+			Prices: map[string]ModelPrice{
+				"gpt-4o":            {Prompt: 2.5, Completion: 10},
+				"gpt-4o-mini":       {Prompt: 0.15, Completion: 0.6},
+				"claude-3-5-sonnet": {Prompt: 3, Completion: 15},
+				"claude-3-5-haiku":  {Prompt: 0.8, Completion: 4},
+			},
+		},
+		Schedule: ScheduleConfig{
+			Timezone:     DefaultTimezone,
+			DailyHour:    10,
+			DailyMinute:  0,
+			PreMarket:    "30 9 * * *",
+			PostClose:    "0 19 * * *",
+			WeeklyDigest: "0 12 * * 0",
+			CatchUpGrace: 3 * time.Hour,
+		},
+		Storage: StorageConfig{
+			DataDir: "data",
+		},
+		ShutdownTimeout: 25 * time.Second,
+	}
+}
+
+// LoadConfig собирает настройки: значения по умолчанию, затем YAML файл
+// (CONFIG_FILE или config.yaml, если он есть), затем переменные окружения.
+// Возвращает ошибку со списком всех некорректных параметров.
+func LoadConfig() (*Config, error) {
+	cfg, envErr, err := readConfig()
+	if err != nil {
+		return nil, err
+	}
+	// Ошибки переменных окружения выводятся вместе с ошибками проверки
+	if err := errors.Join(envErr, cfg.Validate()); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// readConfig читает настройки без проверки: команда eval проверяет только
+// те настройки, которые ей нужны. Ошибки разбора переменных окружения
+// возвращаются в envErr все сразу, а настройка с ошибкой сохраняет прежнее значение.
+func readConfig() (cfg *Config, envErr error, err error) {
+	config := DefaultConfig()
+	cfg = &config
+
+	path := os.Getenv("CONFIG_FILE")
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigFile
+	}
+	if err := cfg.loadFile(path, explicit); err != nil {
+		return nil, nil, err
+	}
+
+	envErr = cfg.loadEnv()
+
+	cfg.AI.ProviderConfig.applyDefaults()
+	for i := range cfg.AI.Fallbacks {
+		cfg.AI.Fallbacks[i].applyDefaults()
+	}
+	if cfg.MarketData.TradingCalendarFile == "" {
+		cfg.MarketData.TradingCalendarFile = filepath.Join(cfg.Storage.DataDir, "trading_calendar.json")
+	}
+
+	return cfg, envErr, nil
+}
+
+// loadFile читает YAML файл настроек. Отсутствие файла - ошибка, только если
+// путь был указан явно.
+func (c *Config) loadFile(path string, required bool) error {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) && !required {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("ошибка чтения файла настроек %s: %w", path, err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("ошибка парсинга файла настроек %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// loadEnv переопределяет настройки заданными переменными окружения и возвращает
+// все ошибки разбора сразу
+func (c *Config) loadEnv() error {
+	envOverride("TELEGRAM_BOT_TOKEN", &c.Telegram.Token)
+
+	envOverride("AI_PROVIDER", &c.AI.Provider)
+	envOverride("AI_API_KEY", &c.AI.APIKey)
+	envOverride("AI_MODEL_NAME", &c.AI.Model)
+	envOverride("AI_API_BASE_URL", &c.AI.BaseURL)
+	envOverride("AI_FOLDER_ID", &c.AI.FolderID)
+	envOverride("AI_SCOPE", &c.AI.Scope)
+
+	envOverride("MOEX_ISS_BASE_URL", &c.MarketData.ISSBaseURL)
+	envOverride("NEWS_API_KEY", &c.MarketData.NewsAPIKey)
+	envOverride("NEWS_G_API_KEY", &c.MarketData.GNewsAPIKey)
+	envOverride("TRADING_CALENDAR_FILE", &c.MarketData.TradingCalendarFile)
+
+	envOverride("FACT_CHECK_MODE", &c.FactCheck.Mode)
+	envOverride("COMPLIANCE_DISCLAIMER", &c.Compliance.Disclaimer)
+
+	envOverride("PROMPTS_DIR", &c.Prompts.Dir)
+
+	envOverride("USAGE_CURRENCY", &c.Usage.Currency)
+	envOverride("USAGE_OVER_LIMIT_MODEL", &c.Usage.OverLimitModel)
+
+	envOverride("SCHEDULE_TIMEZONE", &c.Schedule.Timezone)
+	envOverride("SCHEDULE_PREMARKET", &c.Schedule.PreMarket)
+	envOverride("SCHEDULE_POSTCLOSE", &c.Schedule.PostClose)
+	envOverride("SCHEDULE_WEEKLY", &c.Schedule.WeeklyDigest)
+
+	envOverride("DATA_DIR", &c.Storage.DataDir)
+
+	var errs []error
+	envParse(&errs, envInt, "TELEGRAM_WORKERS", &c.Telegram.Workers)
+	envParse(&errs, envInt, "TELEGRAM_QUEUE_SIZE", &c.Telegram.QueueSize)
+	envParse(&errs, envDuration, "AI_TIMEOUT", &c.AI.Timeout)
+	envParse(&errs, envDuration, "AI_ATTEMPT_TIMEOUT", &c.AI.AttemptTimeout)
+	envParse(&errs, envInt, "AI_MAX_RETRIES", &c.AI.MaxRetries)
+	envParse(&errs, envInt, "AI_BREAKER_THRESHOLD", &c.AI.BreakerThreshold)
+	envParse(&errs, envDuration, "AI_BREAKER_COOLDOWN", &c.AI.BreakerCooldown)
+	envParse(&errs, envInt, "AI_OUTPUT_RETRIES", &c.AI.OutputRetries)
+	envParse(&errs, envBool, "AI_TOOLS_ENABLED", &c.AI.Tools.Enabled)
+	envParse(&errs, envInt, "AI_TOOLS_MAX_CALLS", &c.AI.Tools.MaxCalls)
+	envParse(&errs, envDuration, "AI_TOOLS_CALL_TIMEOUT", &c.AI.Tools.CallTimeout)
+	envParse(&errs, envDuration, "AI_TOOLS_TOTAL_TIMEOUT", &c.AI.Tools.TotalTimeout)
+	envParse(&errs, envFloat, "FACT_CHECK_PRICE_TOLERANCE", &c.FactCheck.PriceTolerance)
+	envParse(&errs, envFloat, "FACT_CHECK_INDEX_TOLERANCE", &c.FactCheck.IndexTolerance)
+	envParse(&errs, envFloat, "FACT_CHECK_FX_TOLERANCE", &c.FactCheck.FXTolerance)
+	envParse(&errs, envBool, "COMPLIANCE_ENABLED", &c.Compliance.Enabled)
+	envParse(&errs, envDuration, "MARKET_DATA_TTL", &c.MarketData.SnapshotTTL)
+	envParse(&errs, envDuration, "ANALYTICS_CACHE_TTL", &c.Cache.TTL)
+	envParse(&errs, envFloat, "ANALYTICS_CACHE_INDEX_THRESHOLD", &c.Cache.IndexThreshold)
+	envParse(&errs, envFloat, "ANALYTICS_CACHE_FX_THRESHOLD", &c.Cache.FXThreshold)
+	envParse(&errs, envFloat, "ANALYTICS_CACHE_PRICE_THRESHOLD", &c.Cache.PriceThreshold)
+	envParse(&errs, envInt, "RECOMMENDATION_WINDOW_DAYS", &c.History.WindowDays)
+	envParse(&errs, envBool, "CHAT_ENABLED", &c.Chat.Enabled)
+	envParse(&errs, envInt, "CHAT_MAX_MESSAGES", &c.Chat.MaxMessages)
+	envParse(&errs, envInt, "CHAT_KEEP_MESSAGES", &c.Chat.KeepMessages)
+	envParse(&errs, envDuration, "CHAT_IDLE_TIMEOUT", &c.Chat.IdleTimeout)
+	envParse(&errs, envInt, "CHAT_RATE_LIMIT", &c.Chat.RateLimit)
+	envParse(&errs, envDuration, "CHAT_RATE_WINDOW", &c.Chat.RateWindow)
+	envParse(&errs, envInt, "CHAT_MAX_QUESTION", &c.Chat.MaxQuestion)
+	envParse(&errs, envFloat, "USAGE_MONTHLY_LIMIT", &c.Usage.MonthlyLimit)
+	envParse(&errs, envInt, "TELEGRAM_GLOBAL_RATE", &c.Telegram.GlobalRate)
+	envParse(&errs, envInt, "TELEGRAM_GROUP_RATE", &c.Telegram.GroupRate)
+	envParse(&errs, envInt, "TELEGRAM_SEND_RETRIES", &c.Telegram.SendRetries)
+	envParse(&errs, envInt, "DAILY_HOUR", &c.Schedule.DailyHour)
+	envParse(&errs, envInt, "DAILY_MINUTE", &c.Schedule.DailyMinute)
+	envParse(&errs, envDuration, "CATCHUP_GRACE_PERIOD", &c.Schedule.CatchUpGrace)
+	envParse(&errs, envDuration, "SHUTDOWN_TIMEOUT", &c.ShutdownTimeout)
+	envParse(&errs, envInt64List, "OWNER_USER_IDS", &c.Owners)
+	envParse(&errs, envInt64List, "ADMIN_USER_IDS", &c.Admins)
+	// ADMIN_USER_ID - прежнее название OWNER_USER_IDS, когда у бота был один администратор
+	if os.Getenv(legacyOwnerEnv) != "" {
+		if os.Getenv("OWNER_USER_IDS") != "" {
+			log.Printf("Переменная %s устарела и не учитывается: задана OWNER_USER_IDS", legacyOwnerEnv)
+		} else {
+			log.Printf("Переменная %s устарела, используйте OWNER_USER_IDS", legacyOwnerEnv)
+			envParse(&errs, envInt64List, legacyOwnerEnv, &c.Owners)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// legacyOwnerEnv устаревшая переменная с Telegram ID владельца
+const legacyOwnerEnv = "ADMIN_USER_ID"
+
+// Validate проверяет настройки и возвращает все найденные ошибки сразу
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Telegram.Token == "" {
+		fail("не задан токен Telegram бота (TELEGRAM_BOT_TOKEN или telegram.token)")
+	}
+	if c.Telegram.Workers < 1 {
+		fail("TELEGRAM_WORKERS должен быть больше нуля, получено %d", c.Telegram.Workers)
+	}
+	if c.Telegram.QueueSize < c.Telegram.Workers {
+		fail("TELEGRAM_QUEUE_SIZE должен быть не меньше TELEGRAM_WORKERS, получено %d", c.Telegram.QueueSize)
+	}
+	if c.Telegram.GlobalRate < 1 || c.Telegram.GlobalRate > 30 {
+		fail("TELEGRAM_GLOBAL_RATE должен быть от 1 до 30 сообщений в секунду, получено %d", c.Telegram.GlobalRate)
+	}
+	if c.Telegram.GroupRate < 1 || c.Telegram.GroupRate > 20 {
+		fail("TELEGRAM_GROUP_RATE должен быть от 1 до 20 сообщений в минуту, получено %d", c.Telegram.GroupRate)
+	}
+	if c.Telegram.SendRetries < 0 {
+		fail("TELEGRAM_SEND_RETRIES не может быть отрицательным, получено %d", c.Telegram.SendRetries)
+	}
+
+	if err := c.AI.ProviderConfig.Validate(); err != nil {
+		fail("провайдер AI (AI_PROVIDER или ai.provider): %v", err)
+	}
+	for i, fallback := range c.AI.Fallbacks {
+		if err := fallback.Validate(); err != nil {
+			fail("резервный провайдер AI №%d (ai.fallbacks): %v", i+1, err)
+		}
+	}
+	if c.AI.AttemptTimeout < time.Second {
+		fail("AI_ATTEMPT_TIMEOUT должен быть не меньше секунды, получено %s", c.AI.AttemptTimeout)
+	}
+	if c.AI.Timeout < c.AI.AttemptTimeout {
+		fail("AI_TIMEOUT должен быть не меньше AI_ATTEMPT_TIMEOUT, получено %s", c.AI.Timeout)
+	}
+	if c.AI.MaxRetries < 0 {
+		fail("AI_MAX_RETRIES не может быть отрицательным, получено %d", c.AI.MaxRetries)
+	}
+	if c.AI.BreakerThreshold < 1 {
+		fail("AI_BREAKER_THRESHOLD должен быть больше нуля, получено %d", c.AI.BreakerThreshold)
+	}
+	if c.AI.BreakerCooldown <= 0 {
+		fail("AI_BREAKER_COOLDOWN должен быть положительным, получено %s", c.AI.BreakerCooldown)
+	}
+	if c.AI.OutputRetries < 0 {
+		fail("AI_OUTPUT_RETRIES не может быть отрицательным, получено %d", c.AI.OutputRetries)
+	}
+	if c.AI.Tools.Enabled {
+		if c.AI.Tools.MaxCalls < 1 {
+			fail("AI_TOOLS_MAX_CALLS должен быть больше нуля, получено %d", c.AI.Tools.MaxCalls)
+		}
+		if c.AI.Tools.CallTimeout <= 0 {
+			fail("AI_TOOLS_CALL_TIMEOUT должен быть положительным, получено %s", c.AI.Tools.CallTimeout)
+		}
+		if c.AI.Tools.TotalTimeout < c.AI.Tools.CallTimeout {
+			fail("AI_TOOLS_TOTAL_TIMEOUT должен быть не меньше AI_TOOLS_CALL_TIMEOUT, получено %s", c.AI.Tools.TotalTimeout)
+		}
+	}
+	if c.Usage.MonthlyLimit < 0 {
+		fail("USAGE_MONTHLY_LIMIT не может быть отрицательным, получено %g", c.Usage.MonthlyLimit)
+	}
+	models := make([]string, 0, len(c.Usage.Prices))
+	for model := range c.Usage.Prices {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	for _, model := range models {
+		if price := c.Usage.Prices[model]; price.Prompt < 0 || price.Completion < 0 {
+			fail("цена модели %s (usage.prices) не может быть отрицательной", model)
+		}
+	}
+	if err := validateURL(c.MarketData.ISSBaseURL); err != nil {
+		fail("некорректный MOEX_ISS_BASE_URL: %v", err)
+	}
+	if !factCheckModes[c.FactCheck.Mode] {
+		fail("FACT_CHECK_MODE должен быть одним из regenerate, correct, flag, off, получено %q", c.FactCheck.Mode)
+	}
+	if c.Compliance.Enabled {
+		// Оговорка из настроек должна распознаваться шаблоном, иначе она добавлялась бы к каждому сообщению повторно
+		if re, err := compileCompliancePattern(c.Compliance.DisclaimerPattern); err != nil {
+			fail("некорректный шаблон оговорки compliance.disclaimer_pattern: %v", err)
+		} else if c.Compliance.Disclaimer != "" && !re.MatchString(c.Compliance.Disclaimer) {
+			fail("COMPLIANCE_DISCLAIMER не распознается шаблоном compliance.disclaimer_pattern")
+		}
+		names := map[string]bool{}
+		for i, rule := range c.Compliance.Rules {
+			if rule.Name == "" {
+				fail("у правила compliance.rules №%d не задано название", i+1)
+			} else if names[rule.Name] {
+				fail("правило compliance.rules %s задано дважды", rule.Name)
+			}
+			names[rule.Name] = true
+			if rule.Pattern == "" {
+				fail("у правила compliance.rules %s не задан шаблон", rule.Name)
+			} else if _, err := compileCompliancePattern(rule.Pattern); err != nil {
+				fail("некорректный шаблон правила compliance.rules %s: %v", rule.Name, err)
+			}
+			if !complianceActions[rule.Action] {
+				fail("действие правила compliance.rules %s должно быть rewrite или block, получено %q", rule.Name, rule.Action)
+			}
+		}
+	}
+	for _, fraction := range []struct {
+		name  string
+		value float64
+	}{
+		{"FACT_CHECK_PRICE_TOLERANCE", c.FactCheck.PriceTolerance},
+		{"FACT_CHECK_INDEX_TOLERANCE", c.FactCheck.IndexTolerance},
+		{"FACT_CHECK_FX_TOLERANCE", c.FactCheck.FXTolerance},
+		{"ANALYTICS_CACHE_INDEX_THRESHOLD", c.Cache.IndexThreshold},
+		{"ANALYTICS_CACHE_FX_THRESHOLD", c.Cache.FXThreshold},
+		{"ANALYTICS_CACHE_PRICE_THRESHOLD", c.Cache.PriceThreshold},
+	} {
+		if fraction.value <= 0 || fraction.value >= 1 {
+			fail("%s должен быть долей от 0 до 1 (например, 0.03), получено %g", fraction.name, fraction.value)
+		}
+	}
+
+	if c.MarketData.SnapshotTTL < 0 {
+		fail("MARKET_DATA_TTL не может быть отрицательным, получено %s", c.MarketData.SnapshotTTL)
+	}
+	if c.Cache.TTL < 0 {
+		fail("ANALYTICS_CACHE_TTL не может быть отрицательным, получено %s", c.Cache.TTL)
+	}
+	if c.Chat.MaxMessages < 2 {
+		fail("CHAT_MAX_MESSAGES должен быть не меньше 2, получено %d", c.Chat.MaxMessages)
+	}
+	if c.Chat.KeepMessages < 0 || c.Chat.KeepMessages >= c.Chat.MaxMessages {
+		fail("CHAT_KEEP_MESSAGES должен быть от 0 до CHAT_MAX_MESSAGES-1, получено %d", c.Chat.KeepMessages)
+	}
+	if c.Chat.IdleTimeout < 0 {
+		fail("CHAT_IDLE_TIMEOUT не может быть отрицательным, получено %s", c.Chat.IdleTimeout)
+	}
+	if c.Chat.RateLimit < 1 {
+		fail("CHAT_RATE_LIMIT должен быть больше нуля, получено %d", c.Chat.RateLimit)
+	}
+	if c.Chat.RateWindow < time.Second {
+		fail("CHAT_RATE_WINDOW должен быть не меньше секунды, получено %s", c.Chat.RateWindow)
+	}
+	if c.Chat.MaxQuestion < 1 || c.Chat.MaxQuestion > telegramMessageLimit {
+		fail("CHAT_MAX_QUESTION должен быть от 1 до %d символов, получено %d", telegramMessageLimit, c.Chat.MaxQuestion)
+	}
+	if maxDays := int(recommendationRetention / (24 * time.Hour)); c.History.WindowDays < 0 || c.History.WindowDays > maxDays {
+		fail("RECOMMENDATION_WINDOW_DAYS должен быть от 0 до %d, получено %d", maxDays, c.History.WindowDays)
+	}
+
+	if _, err := loadLocation(c.Schedule.Timezone); err != nil {
+		fail("неизвестный часовой пояс расписания %q", c.Schedule.Timezone)
+	}
+	if c.Schedule.DailyHour < 0 || c.Schedule.DailyHour > 23 {
+		fail("DAILY_HOUR должен быть от 0 до 23, получено %d", c.Schedule.DailyHour)
+	}
+	if c.Schedule.DailyMinute < 0 || c.Schedule.DailyMinute > 59 {
+		fail("DAILY_MINUTE должен быть от 0 до 59, получено %d", c.Schedule.DailyMinute)
+	}
+	specs := []struct{ name, spec string }{
+		{"SCHEDULE_PREMARKET", c.Schedule.PreMarket},
+		{"SCHEDULE_POSTCLOSE", c.Schedule.PostClose},
+		{"SCHEDULE_WEEKLY", c.Schedule.WeeklyDigest},
+	}
+	for _, s := range specs {
+		if s.spec == scheduleDisabled {
+			continue
+		}
+		if _, err := cron.ParseStandard(s.spec); err != nil {
+			fail("некорректное cron-выражение %s=%q: %v", s.name, s.spec, err)
+		}
+	}
+	if c.Schedule.CatchUpGrace < time.Minute {
+		fail("CATCHUP_GRACE_PERIOD должен быть не меньше минуты, получено %s", c.Schedule.CatchUpGrace)
+	}
+	if c.ShutdownTimeout < time.Second {
+		fail("SHUTDOWN_TIMEOUT должен быть не меньше секунды, получено %s", c.ShutdownTimeout)
+	}
+
+	if len(c.Owners) == 0 && len(c.Admins) == 0 {
+		fail("не задан ни один владелец или администратор (OWNER_USER_IDS, ADMIN_USER_IDS или owners, admins)")
+	}
+	for _, id := range append(append([]int64{}, c.Owners...), c.Admins...) {
+		if id <= 0 {
+			fail("некорректный ID владельца или администратора %d", id)
+		}
+	}
+
+	if c.Storage.DataDir == "" {
+		fail("не задан каталог данных (DATA_DIR или storage.data_dir)")
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("некорректные настройки:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// applyDefaults подставляет адрес и параметры провайдера по умолчанию
+func (p *ProviderConfig) applyDefaults() {
+	p.Provider = strings.ToLower(strings.TrimSpace(p.Provider))
+	if p.BaseURL == "" {
+		p.BaseURL = providerDefaultURLs[p.Provider]
+	}
+	if p.Model == "" {
+		p.Model = providerDefaultModels[p.Provider]
+	}
+	if p.Provider == ProviderGigaChat && p.Scope == "" {
+		p.Scope = gigaChatDefaultScope
+	}
+}
+
+// Validate проверяет настройки провайдера и возвращает все найденные ошибки
+func (p ProviderConfig) Validate() error {
+	var errs []error
+
+	if _, ok := providerDefaultURLs[p.Provider]; !ok {
+		errs = append(errs, fmt.Errorf("неизвестный провайдер %q, доступны: %s", p.Provider, strings.Join(ProviderNames(), ", ")))
+	}
+	if p.Model == "" {
+		errs = append(errs, fmt.Errorf("не задана модель (AI_MODEL_NAME или model)"))
+	}
+	if err := validateURL(p.BaseURL); err != nil {
+		errs = append(errs, fmt.Errorf("некорректный base_url: %w", err))
+	}
+	// Локальным серверам (Ollama, llama.cpp) ключ не нужен
+	if p.APIKey == "" && p.Provider != ProviderOllama && p.BaseURL == providerDefaultURLs[p.Provider] {
+		errs = append(errs, fmt.Errorf("не задан API ключ (AI_API_KEY или api_key)"))
+	}
+	if p.Provider == ProviderYandexGPT && p.FolderID == "" {
+		errs = append(errs, fmt.Errorf("для YandexGPT нужен каталог Yandex Cloud (AI_FOLDER_ID или folder_id)"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateURL проверяет, что строка - абсолютный http(s) URL
+func validateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("ожидается http(s) URL, получено %q", raw)
+	}
+	return nil
+}
+
+// envOverride записывает значение переменной окружения в target, если она задана
+func envOverride(key string, target *string) {
+	if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+		*target = value
+	}
+}
+
+// envParse записывает в target значение переменной окружения key, разобранное
+// parse. Ошибка разбора добавляется в errs, а target сохраняет прежнее значение.
+func envParse[T any](errs *[]error, parse func(key string, fallback T) (T, error), key string, target *T) {
+	value, err := parse(key, *target)
+	if err != nil {
+		*errs = append(*errs, err)
+		return
+	}
+	*target = value
+}
+
+// envInt возвращает целое значение переменной окружения или значение по умолчанию
+func envInt(key string, fallback int) (int, error) {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("переменная %s должна быть целым числом, получено %q", key, value)
+	}
+	return n, nil
+}
+
+// envFloat возвращает дробное значение переменной окружения или значение по умолчанию
+func envFloat(key string, fallback float64) (float64, error) {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("переменная %s должна быть числом, получено %q", key, value)
+	}
+	return f, nil
+}
+
+// envBool возвращает логическое значение переменной окружения (true/false, 1/0)
+// или значение по умолчанию
+func envBool(key string, fallback bool) (bool, error) {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("переменная %s должна быть true или false, получено %q", key, value)
+	}
+	return b, nil
+}
+
+// envInt64List возвращает список чисел из переменной окружения через запятую
+// или значение по умолчанию
+func envInt64List(key string, fallback []int64) ([]int64, error) {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback, nil
+	}
+	var list []int64
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("переменная %s должна быть списком чисел через запятую, получено %q", key, value)
+		}
+		list = append(list, n)
+	}
+	return list, nil
+}
+
+// envDuration возвращает длительность из переменной окружения (например, 90m, 3h)
+// или значение по умолчанию
+func envDuration(key string, fallback time.Duration) (time.Duration, error) {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("переменная %s должна быть длительностью (например, 90m или 3h), получено %q", key, value)
+	}
+	return d, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigReportsAllErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("telegram: {}\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("TELEGRAM_BOT_TOKEN", "")
+	t.Setenv("TELEGRAM_WORKERS", "много")
+	t.Setenv("AI_TIMEOUT", "3 минуты")
+	t.Setenv("OWNER_USER_IDS", "1,abc")
+
+	_, err := LoadConfig()
+	if err == nil {
+		t.Fatal("LoadConfig без ошибки")
+	}
+	// Ошибки всех переменных и проверки настроек выводятся вместе
+	for _, want := range []string{"TELEGRAM_WORKERS", "AI_TIMEOUT", "OWNER_USER_IDS", "TELEGRAM_BOT_TOKEN"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("в ошибке нет %s:\n%v", want, err)
+		}
+	}
+}
+
+func TestLoadEnvKeepsValueOnError(t *testing.T) {
+	cfg := DefaultConfig()
+	workers := cfg.Telegram.Workers
+	t.Setenv("TELEGRAM_WORKERS", "много")
+	t.Setenv("TELEGRAM_QUEUE_SIZE", "500")
+
+	if err := cfg.loadEnv(); err == nil {
+		t.Fatal("loadEnv без ошибки")
+	}
+	if cfg.Telegram.Workers != workers {
+		t.Errorf("TELEGRAM_WORKERS с ошибкой изменил значение на %d", cfg.Telegram.Workers)
+	}
+	if cfg.Telegram.QueueSize != 500 {
+		t.Errorf("TELEGRAM_QUEUE_SIZE = %d после ошибки в другой переменной, ожидалось 500", cfg.Telegram.QueueSize)
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Ограничения ответов в режиме вопросов
+const (
+	chatAnswerTokens  = 1000 // максимум токенов ответа на вопрос
+	chatSummaryTokens = 500  // максимум токенов краткого содержания диалога
+)
+
+// Ошибки режима вопросов
+var (
+	ErrChatDisabled      = errors.New("режим вопросов отключен")
+	ErrQuestionTooLong   = errors.New("слишком длинный вопрос")
+	ErrUsageLimitReached = errors.New("лимит расходов на AI исчерпан")
+)
+
+// RateLimitError пользователь задал слишком много вопросов за окно лимита
+type RateLimitError struct {
+	RetryAfter time.Duration // через сколько можно задать следующий вопрос
+}
+
+// Error возвращает текст ошибки со временем ожидания
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("превышен лимит вопросов, следующий через %s", e.RetryAfter.Round(time.Second))
+}
+
+// Conversation память диалога чата: краткое содержание старой части
+// и последние сообщения дословно
+type Conversation struct {
+	Summary   string      `json:"summary,omitempty"`
+	Messages  []AIMessage `json:"messages,omitempty"`
+	UpdatedAt time.Time   `json:"updated_at"`
+}
+
+// Empty сообщает, что в диалоге ничего нет
+func (c Conversation) Empty() bool {
+	return c.Summary == "" && len(c.Messages) == 0
+}
+
+// RateLimiter ограничивает количество запросов каждого пользователя
+// за скользящее окно времени
+type RateLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu   sync.Mutex
+	hits map[int64][]time.Time // время запросов внутри окна, старые в начале
+}
+
+// NewRateLimiter создает ограничитель: не больше limit запросов за window
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		limit:  limit,
+		window: window,
+		hits:   make(map[int64][]time.Time),
+	}
+}
+
+// Allow учитывает запрос пользователя userID в момент now. Если лимит
+// исчерпан, запрос не учитывается и возвращается время до освобождения места.
+func (l *RateLimiter) Allow(userID int64, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cutoff := now.Add(-l.window)
+	hits := l.hits[userID]
+	expired := 0
+	for expired < len(hits) && !hits[expired].After(cutoff) {
+		expired++
+	}
+	hits = hits[expired:]
+
+	if len(hits) >= l.limit {
+		l.hits[userID] = hits
+		return hits[0].Sub(cutoff), false
+	}
+	l.hits[userID] = append(hits, now)
+	return 0, true
+}
+
+// ChatAssistant отвечает на вопросы пользователей о рынке с учетом данных
+// биржи и памяти диалога чата. Длинный диалог пересказывается моделью, чтобы
+// запрос не рос бесконечно.
+type ChatAssistant struct {
+	ai      *AIService
+	storage Storage
+	config  ChatConfig
+	limiter *RateLimiter
+}
+
+// NewChatAssistant создает помощника для режима вопросов
+func NewChatAssistant(ai *AIService, storage Storage, config ChatConfig) *ChatAssistant {
+	return &ChatAssistant{
+		ai:      ai,
+		storage: storage,
+		config:  config,
+		limiter: NewRateLimiter(config.RateLimit, config.RateWindow),
+	}
+}
+
+// Ask отвечает на вопрос пользователя userID в чате chatID в стиле и с бюджетом
+// из настроек чата и запоминает вопрос и ответ. В onText передается текст
+// ответа по мере генерации. Сообщения одного чата обрабатываются по очереди,
+// поэтому диалог не меняется параллельно.
+func (a *ChatAssistant) Ask(ctx context.Context, chatID, userID int64, question string, settings ChatSettings, onText StreamFunc) (string, error) {
+	if !a.config.Enabled {
+		return "", ErrChatDisabled
+	}
+	question = strings.TrimSpace(question)
+	if utf8.RuneCountInString(question) > a.config.MaxQuestion {
+		return "", ErrQuestionTooLong
+	}
+	if retryAfter, ok := a.limiter.Allow(userID, time.Now()); !ok {
+		return "", &RateLimitError{RetryAfter: retryAfter}
+	}
+
+	conversation, err := a.storage.Conversation(chatID)
+	if err != nil {
+		return "", fmt.Errorf("ошибка чтения диалога: %w", err)
+	}
+	if a.config.IdleTimeout > 0 && time.Since(conversation.UpdatedAt) > a.config.IdleTimeout {
+		// После долгой паузы начинаем разговор заново
+		conversation = Conversation{}
+	}
+
+	answer, err := a.ai.Answer(ctx, conversation, question, settings.Persona, settings.Budget, onText)
+	if err != nil {
+		return "", err
+	}
+
+	conversation.Messages = append(conversation.Messages,
+		AIMessage{Role: "user", Content: question},
+		AIMessage{Role: "assistant", Content: answer},
+	)
+	conversation.UpdatedAt = time.Now()
+	a.compact(ctx, &conversation)
+	if err := a.storage.SaveConversation(chatID, conversation); err != nil {
+		log.Printf("Ошибка сохранения диалога чата %d: %v", chatID, err)
+	}
+	return answer, nil
+}
+
+// compact пересказывает старую часть диалога, если сообщений больше
+// MaxMessages, оставляя дословно последние KeepMessages. Дословная часть
+// всегда начинается с вопроса пользователя. Если пересказ не удался,
+// старые сообщения все равно удаляются, а прежнее краткое содержание остается.
+func (a *ChatAssistant) compact(ctx context.Context, conversation *Conversation) {
+	if len(conversation.Messages) <= a.config.MaxMessages {
+		return
+	}
+
+	cut := len(conversation.Messages) - a.config.KeepMessages
+	for cut < len(conversation.Messages) && conversation.Messages[cut].Role != "user" {
+		cut++
+	}
+	old := conversation.Messages[:cut]
+
+	summary, err := a.ai.Summarize(ctx, conversation.Summary, old)
+	if err != nil {
+		log.Printf("Ошибка пересказа диалога: %v", err)
+	} else {
+		conversation.Summary = summary
+	}
+	conversation.Messages = append([]AIMessage(nil), conversation.Messages[cut:]...)
+}
+
+// Forget удаляет память диалога чата
+func (a *ChatAssistant) Forget(chatID int64) error {
+	return a.storage.SaveConversation(chatID, Conversation{})
+}
+
+// Answer отвечает на вопрос с учетом диалога conversation и текущих данных
+// биржи, в стиле persona для инвестора с бюджетом budget рублей (0 - defaultBudget).
+// Ответ - обычный текст; в стилях без эмодзи они удаляются. Ответ проходит
+// проверку соответствия требованиям.
+func (s *AIService) Answer(ctx context.Context, conversation Conversation, question string, persona Persona, budget float64, onText StreamFunc) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	provider, err := s.chatProvider()
+	if err != nil {
+		return "", err
+	}
+
+	marketData, err := s.marketDataService.GetMarketData()
+	if err != nil {
+		log.Printf("Ошибка при получении данных о рынке: %v", err)
+	}
+
+	data := PromptData{
+		Date:    analyticsDate(marketData),
+		Persona: persona.OrDefault(),
+		Budget:  budgetOrDefault(budget),
+		Market:  marketData,
+		Summary: conversation.Summary,
+	}
+	if marketData != nil {
+		// В вопросах речь может идти о любой бумаге, поэтому модель видит все
+		// полученные акции, а не только подходящие по бюджету
+		all := *marketData
+		if len(all.Stocks) > 0 {
+			all.TopStocks = all.Stocks
+		}
+		data.Session = marketData.Session
+		data.MarketText = s.marketDataService.FormatMarketDataForAI(&all)
+	}
+
+	systemPrompt, err := s.prompts.Render(PromptChat, data)
+	if err != nil {
+		return "", err
+	}
+
+	messages := append(append([]AIMessage(nil), conversation.Messages...), AIMessage{Role: "user", Content: question})
+	completion, err := s.complete(ctx, provider, CompletionRequest{
+		System:      systemPrompt,
+		Messages:    messages,
+		Temperature: 0.5,
+		MaxTokens:   chatAnswerTokens,
+	}, s.compliance.Preview(onText))
+	if err != nil {
+		return "", err
+	}
+
+	answer := strings.TrimSpace(completion.Text)
+	if !persona.Style().Emoji {
+		answer = stripEmoji(answer)
+	}
+	if answer == "" {
+		return "", fmt.Errorf("%s прислал пустой ответ", completion.Provider)
+	}
+	return s.compliance.Answer(ctx, answer)
+}
+
+// Summarize пересказывает сообщения messages вместе с прежним кратким
+// содержанием summary
+func (s *AIService) Summarize(ctx context.Context, summary string, messages []AIMessage) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	provider, err := s.chatProvider()
+	if err != nil {
+		return "", err
+	}
+
+	prompt, err := s.prompts.Render(PromptSummary, PromptData{Summary: summary, Messages: messages})
+	if err != nil {
+		return "", err
+	}
+	completion, err := provider.Complete(ctx, CompletionRequest{
+		Messages:    []AIMessage{{Role: "user", Content: prompt}},
+		Temperature: 0.2,
+		MaxTokens:   chatSummaryTokens,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	text := strings.TrimSpace(completion.Text)
+	if text == "" {
+		return "", fmt.Errorf("%s прислал пустой пересказ", completion.Provider)
+	}
+	return text, nil
+}
+
+// chatProvider возвращает провайдера для режима вопросов: после превышения
+// месячного лимита - дешевую модель, а если ее нет - ErrUsageLimitReached
+func (s *AIService) chatProvider() (LLMProvider, error) {
+	if !s.usage.OverLimit() {
+		return s.provider, nil
+	}
+	if s.overLimitProvider == nil {
+		return nil, ErrUsageLimitReached
+	}
+	return s.overLimitProvider, nil
+}
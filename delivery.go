@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// dailyJob имя ежедневной рассылки в истории доставок чата
+const dailyJob = "daily"
+
+// ErrDeliveryInterrupted рассылка прервана остановкой бота
+var ErrDeliveryInterrupted = errors.New("рассылка прервана остановкой бота")
+
+// Location возвращает часовой пояс чата, при ошибке - московский
+func (c ChatSettings) Location() *time.Location {
+	if c.Timezone == "" {
+		return moscowLocation()
+	}
+	_, loc, err := ParseTimezone(c.Timezone)
+	if err != nil {
+		log.Printf("Некорректный часовой пояс %q в настройках, используем Москву: %v", c.Timezone, err)
+		return moscowLocation()
+	}
+	return loc
+}
+
+// DeliveryClock возвращает время доставки аналитики чату или значение по умолчанию
+func (c ChatSettings) DeliveryClock(defaultHour, defaultMinute int) (int, int) {
+	if c.DeliveryTime == "" {
+		return defaultHour, defaultMinute
+	}
+	hour, minute, err := ParseClock(c.DeliveryTime)
+	if err != nil {
+		return defaultHour, defaultMinute
+	}
+	return hour, minute
+}
+
+// DeliveryScheduler рассылает ежедневную аналитику каждому подписчику в его локальное время
+type DeliveryScheduler struct {
+	outbox        *Outbox
+	storage       Storage
+	aiService     *AIService
+	calendar      *TradingCalendar
+	defaultHour   int
+	defaultMinute int
+	// catchUpWindow сколько времени после назначенного момента подписчик еще может
+	// получить пропущенный выпуск (например, если бот был перезапущен)
+	catchUpWindow time.Duration
+
+	mu      sync.Mutex
+	reports []DeliveryReport // последние отчеты о рассылках, новые в конце
+
+	ctx  context.Context // отменяется при остановке бота
+	stop context.CancelFunc
+}
+
+// NewDeliveryScheduler создает планировщик доставки с временем по умолчанию hour:minute
+// и окном догоняющей доставки catchUpWindow
+func NewDeliveryScheduler(outbox *Outbox, storage Storage, aiService *AIService, calendar *TradingCalendar, hour, minute int, catchUpWindow time.Duration) *DeliveryScheduler {
+	ctx, stop := context.WithCancel(context.Background())
+	return &DeliveryScheduler{
+		outbox:        outbox,
+		storage:       storage,
+		aiService:     aiService,
+		calendar:      calendar,
+		defaultHour:   hour,
+		defaultMinute: minute,
+		catchUpWindow: catchUpWindow,
+		ctx:           ctx,
+		stop:          stop,
+	}
+}
+
+// Stop прерывает текущую рассылку перед отправкой следующему чату. Доставка
+// отмечается в хранилище после каждого чата, поэтому оставшиеся чаты получат
+// выпуск догоняющей рассылкой после перезапуска.
+func (d *DeliveryScheduler) Stop() {
+	d.stop()
+}
+
+// Reports возвращает отчеты о последних рассылках, новые в начале
+func (d *DeliveryScheduler) Reports() []DeliveryReport {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	reports := make([]DeliveryReport, len(d.reports))
+	for i, report := range d.reports {
+		reports[len(reports)-1-i] = report
+	}
+	return reports
+}
+
+// DescribeSchedule возвращает человекочитаемое время доставки для настроек чата
+func (d *DeliveryScheduler) DescribeSchedule(settings ChatSettings) string {
+	hour, minute := settings.DeliveryClock(d.defaultHour, d.defaultMinute)
+	timezone := settings.Timezone
+	if timezone == "" {
+		timezone = DefaultTimezone
+	}
+	return fmt.Sprintf("%02d:%02d (%s)", hour, minute, timezone)
+}
+
+// Deliver отправляет аналитику всем подписчикам, у которых наступило время доставки.
+// Повторный вызов не отправляет выпуск тем, кто его уже получил.
+func (d *DeliveryScheduler) Deliver(now time.Time) error {
+	subscribers, err := d.storage.Subscribers()
+	if err != nil {
+		return fmt.Errorf("ошибка получения списка подписчиков: %w", err)
+	}
+
+	var due []ChatRecord
+	for _, chat := range subscribers {
+		if d.isDue(chat, now) {
+			due = append(due, chat)
+		}
+	}
+	if len(due) == 0 {
+		return nil
+	}
+
+	log.Printf("Отправка ежедневной аналитики %d подписчикам", len(due))
+
+	// В нерабочие дни биржи вместо ежедневной аналитики отправляется обзор выходного дня
+	kind := AnalyticsDaily
+	if !d.calendar.IsTradingDay(now) {
+		kind = AnalyticsRecap
+	}
+
+	ctx := WithUsageSource(d.ctx, UsageSource{Job: dailyJob})
+	texts, ready, err := d.generate(ctx, kind, due)
+	if len(ready) > 0 {
+		err = errors.Join(err, d.send(ready, dailyJob, texts, now))
+	}
+	return err
+}
+
+// BroadcastOnTradingDays возвращает задачу планировщика, которая рассылает выпуск
+// только в торговые дни Московской биржи
+func (d *DeliveryScheduler) BroadcastOnTradingDays(kind AnalyticsKind) JobFunc {
+	return func(scheduled time.Time) error {
+		if !d.calendar.IsTradingDay(scheduled) {
+			log.Printf("Выпуск %s пропущен: %s не торговый день", kind, scheduled.Format(calendarDateLayout))
+			return nil
+		}
+		return d.Broadcast(kind, scheduled)
+	}
+}
+
+// Broadcast генерирует выпуск указанного вида и отправляет его всем подписчикам,
+// которые еще не получили выпуск, запланированный на scheduled
+func (d *DeliveryScheduler) Broadcast(kind AnalyticsKind, scheduled time.Time) error {
+	subscribers, err := d.storage.Subscribers()
+	if err != nil {
+		return fmt.Errorf("ошибка получения списка подписчиков: %w", err)
+	}
+
+	var pending []ChatRecord
+	for _, chat := range subscribers {
+		if chat.LastDelivery(string(kind)).Before(scheduled) {
+			pending = append(pending, chat)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	log.Printf("Отправка выпуска %s %d подписчикам", kind, len(pending))
+
+	ctx := WithUsageSource(d.ctx, UsageSource{Job: string(kind)})
+	texts, ready, err := d.generate(ctx, kind, pending)
+	if len(ready) > 0 {
+		err = errors.Join(err, d.send(ready, string(kind), texts, scheduled))
+	}
+	return err
+}
+
+// issueVariant вариант выпуска: стиль, выбранный чатом, и ступень его бюджета
+type issueVariant struct {
+	persona Persona
+	budget  float64
+}
+
+// variantOf возвращает вариант выпуска для настроек чата. Бюджет округляется
+// до ступени из budgetTiers, чтобы чаты с близкими суммами получали один выпуск;
+// в заголовке рекомендации каждый чат видит свой бюджет.
+func variantOf(settings ChatSettings) issueVariant {
+	return issueVariant{persona: settings.Persona.OrDefault(), budget: budgetTier(settings.Budget)}
+}
+
+// generate генерирует выпуск kind в каждом варианте (стиль и ступень бюджета),
+// выбранном чатами chats, и оформляет его для каждого чата с его бюджетом.
+// Возвращает тексты по чатам и чаты, для которых выпуск готов: ошибка генерации
+// в одном варианте не мешает отправить выпуск в остальных.
+func (d *DeliveryScheduler) generate(ctx context.Context, kind AnalyticsKind, chats []ChatRecord) (map[int64]string, []ChatRecord, error) {
+	reports := make(map[issueVariant]*AnalyticsReport)
+	failed := make(map[issueVariant]bool)
+	texts := make(map[int64]string, len(chats))
+	var ready []ChatRecord
+	var errs []error
+	for _, chat := range chats {
+		variant := variantOf(chat.Settings)
+		if failed[variant] {
+			continue
+		}
+		report, ok := reports[variant]
+		if !ok {
+			var err error
+			report, err = d.aiService.GenerateIssue(ctx, kind, variant.persona, variant.budget, nil)
+			if err != nil {
+				failed[variant] = true
+				errs = append(errs, fmt.Errorf("ошибка генерации выпуска %s (%s, %s ₽): %w",
+					kind, variant.persona, formatMoney(variant.budget), err))
+				continue
+			}
+			reports[variant] = report
+		}
+
+		text, err := RenderIssue(report, variant.persona, chat.Settings.Budget)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("чат %d: %w", chat.ChatID, err))
+			continue
+		}
+		texts[chat.ChatID] = text
+		ready = append(ready, chat)
+	}
+	return texts, ready, errors.Join(errs...)
+}
+
+// send отправляет чатам их тексты выпуска через очередь исходящих сообщений
+// и отмечает доставку рассылки job со временем at сразу после отправки в каждый чат
+func (d *DeliveryScheduler) send(chats []ChatRecord, job string, texts map[int64]string, at time.Time) error {
+	started := time.Now()
+
+	msgs := make([]OutboundMessage, len(chats))
+	for i, chat := range chats {
+		msgs[i] = OutboundMessage{ChatID: chat.ChatID, Text: texts[chat.ChatID], ParseMode: "Markdown"}
+	}
+
+	results := d.outbox.SendAll(d.ctx, msgs, func(result SendResult) {
+		if result.Blocked {
+			// Чат больше недоступен - не пытаемся доставлять в него каждый день
+			log.Printf("Чат %d недоступен (%v), отписываем от рассылок", result.ChatID, result.Err)
+			if err := d.storage.Unsubscribe(result.ChatID); err != nil {
+				log.Printf("Ошибка отписки недоступного чата %d: %v", result.ChatID, err)
+			}
+			return
+		}
+		if result.Err != nil {
+			return
+		}
+		if err := d.storage.MarkDelivered(result.ChatID, job, at); err != nil {
+			log.Printf("Ошибка сохранения доставки %s для чата %d: %v", job, result.ChatID, err)
+		}
+	})
+
+	report := NewDeliveryReport(job, at, started, results)
+	d.mu.Lock()
+	d.reports = append(d.reports, report)
+	if len(d.reports) > maxDeliveryReport {
+		d.reports = d.reports[len(d.reports)-maxDeliveryReport:]
+	}
+	d.mu.Unlock()
+
+	log.Printf("Рассылка %s", report.Summary())
+	for _, e := range report.Errors {
+		log.Printf("Ошибка рассылки %s: %s", job, e)
+	}
+
+	if report.Interrupted > 0 {
+		return fmt.Errorf("%w: выпуск %s не отправлен %d из %d чатов", ErrDeliveryInterrupted, job, report.Interrupted, report.Total)
+	}
+	if report.Failed > 0 {
+		return fmt.Errorf("выпуск %s не доставлен %d из %d чатов", job, report.Failed, report.Total)
+	}
+	return nil
+}
+
+// isDue проверяет, что у чата наступило время доставки и сегодняшний выпуск еще не отправлен
+func (d *DeliveryScheduler) isDue(chat ChatRecord, now time.Time) bool {
+	loc := chat.Settings.Location()
+	hour, minute := chat.Settings.DeliveryClock(d.defaultHour, d.defaultMinute)
+
+	local := now.In(loc)
+	scheduled := time.Date(local.Year(), local.Month(), local.Day(), hour, minute, 0, 0, loc)
+	if local.Before(scheduled) || local.Sub(scheduled) >= d.catchUpWindow {
+		return false
+	}
+
+	return chat.LastDelivery(dailyJob).Before(scheduled)
+}
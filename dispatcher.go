@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// UpdateHandler обрабатывает одно обновление Telegram
+type UpdateHandler func(update tgbotapi.Update)
+
+// DispatcherStats метрики очереди обновлений
+type DispatcherStats struct {
+	Workers       int           // размер пула обработчиков
+	QueueCapacity int           // максимум обновлений в очереди
+	QueueDepth    int           // обновлений в очереди и в обработке сейчас
+	MaxQueueDepth int           // максимальная глубина очереди с момента запуска
+	ActiveChats   int           // чатов с необработанными обновлениями
+	Busy          int           // занятых обработчиков
+	Processed     uint64        // обработано обновлений
+	Throttled     uint64        // сколько раз прием обновлений ждал места в очереди
+	ThrottledFor  time.Duration // суммарное время ожидания места в очереди
+}
+
+// Dispatcher обрабатывает обновления параллельно ограниченным пулом обработчиков,
+// сохраняя порядок сообщений внутри одного чата. Когда очередь заполнена, Dispatch
+// блокируется, и бот перестает забирать новые обновления у Telegram.
+type Dispatcher struct {
+	handler UpdateHandler
+	workers chan struct{} // токены обработчиков
+	slots   chan struct{} // места в очереди
+
+	mu     sync.Mutex
+	chats  map[int64][]tgbotapi.Update
+	stats  DispatcherStats
+	active sync.WaitGroup // горутины, обрабатывающие очереди чатов
+}
+
+// NewDispatcher создает диспетчер с workers обработчиками и очередью на queueSize обновлений
+func NewDispatcher(handler UpdateHandler, workers, queueSize int) *Dispatcher {
+	return &Dispatcher{
+		handler: handler,
+		workers: make(chan struct{}, workers),
+		slots:   make(chan struct{}, queueSize),
+		chats:   make(map[int64][]tgbotapi.Update),
+		stats: DispatcherStats{
+			Workers:       workers,
+			QueueCapacity: queueSize,
+		},
+	}
+}
+
+// Dispatch ставит обновление в очередь его чата. Если очередь заполнена,
+// ждет освобождения места, но не дольше, чем позволяет ctx: после отмены ctx
+// обновление не принимается, и возвращается ошибка контекста.
+func (d *Dispatcher) Dispatch(ctx context.Context, update tgbotapi.Update) error {
+	select {
+	case d.slots <- struct{}{}:
+	default:
+		started := time.Now()
+		log.Printf("Очередь обновлений заполнена (%d), ожидаем освобождения места", cap(d.slots))
+		select {
+		case d.slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		d.mu.Lock()
+		d.stats.Throttled++
+		d.stats.ThrottledFor += time.Since(started)
+		d.mu.Unlock()
+	}
+
+	var chatID int64
+	if chat := update.FromChat(); chat != nil {
+		chatID = chat.ID
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	queue, running := d.chats[chatID]
+	d.chats[chatID] = append(queue, update)
+
+	d.stats.QueueDepth++
+	if d.stats.QueueDepth > d.stats.MaxQueueDepth {
+		d.stats.MaxQueueDepth = d.stats.QueueDepth
+	}
+	if d.stats.QueueDepth == cap(d.slots)/2 {
+		log.Printf("Очередь обновлений заполнена наполовину: %d из %d, занято обработчиков %d",
+			d.stats.QueueDepth, cap(d.slots), len(d.workers))
+	}
+
+	// Для каждого чата работает не больше одной горутины - так сохраняется порядок
+	if !running {
+		d.active.Add(1)
+		go d.drain(chatID)
+	}
+	return nil
+}
+
+// drain последовательно обрабатывает очередь чата, пока она не опустеет
+func (d *Dispatcher) drain(chatID int64) {
+	defer d.active.Done()
+
+	for {
+		d.mu.Lock()
+		queue := d.chats[chatID]
+		if len(queue) == 0 {
+			delete(d.chats, chatID)
+			d.mu.Unlock()
+			return
+		}
+		update := queue[0]
+		d.mu.Unlock()
+
+		d.workers <- struct{}{}
+		d.process(update)
+		<-d.workers
+
+		d.mu.Lock()
+		d.chats[chatID] = d.chats[chatID][1:]
+		d.stats.QueueDepth--
+		d.stats.Processed++
+		d.mu.Unlock()
+
+		<-d.slots
+	}
+}
+
+// process вызывает обработчик, не давая панике в одном обновлении уронить бота
+func (d *Dispatcher) process(update tgbotapi.Update) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Паника при обработке обновления %d: %v", update.UpdateID, r)
+		}
+	}()
+
+	d.handler(update)
+}
+
+// Shutdown ждет, пока будут обработаны все принятые обновления, но не дольше,
+// чем позволяет ctx. После вызова Shutdown новые обновления передавать нельзя.
+func (d *Dispatcher) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		d.active.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		stats := d.Stats()
+		return fmt.Errorf("не обработано %d обновлений в %d чатах: %w", stats.QueueDepth, stats.ActiveChats, ctx.Err())
+	}
+}
+
+// Stats возвращает текущие метрики очереди
+func (d *Dispatcher) Stats() DispatcherStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	stats := d.stats
+	stats.ActiveChats = len(d.chats)
+	stats.Busy = len(d.workers)
+
+	return stats
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// chatUpdate создает обновление с сообщением в чат chatID
+func chatUpdate(updateID int, chatID int64) tgbotapi.Update {
+	return tgbotapi.Update{
+		UpdateID: updateID,
+		Message:  &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID}},
+	}
+}
+
+func TestDispatcherKeepsChatOrder(t *testing.T) {
+	var mu sync.Mutex
+	handled := make(map[int64][]int)
+	dispatcher := NewDispatcher(func(update tgbotapi.Update) {
+		if update.UpdateID == 3 {
+			panic("сбой обработчика")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		chatID := update.Message.Chat.ID
+		handled[chatID] = append(handled[chatID], update.UpdateID)
+	}, 4, 100)
+
+	for i := 0; i < 40; i++ {
+		if err := dispatcher.Dispatch(context.Background(), chatUpdate(i, int64(i%2))); err != nil {
+			t.Fatalf("Dispatch: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := dispatcher.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	// Паника в обновлении 3 не останавливает очередь чата
+	for chatID, ids := range handled {
+		for i := 1; i < len(ids); i++ {
+			if ids[i] <= ids[i-1] {
+				t.Fatalf("чат %d: обновления обработаны не по порядку: %v", chatID, ids)
+			}
+		}
+	}
+	if len(handled[0]) != 20 || len(handled[1]) != 19 {
+		t.Errorf("обработано %d и %d обновлений, ожидалось 20 и 19", len(handled[0]), len(handled[1]))
+	}
+
+	stats := dispatcher.Stats()
+	if stats.Processed != 40 || stats.QueueDepth != 0 || stats.ActiveChats != 0 {
+		t.Errorf("Stats = %+v, ожидалось 40 обработанных и пустая очередь", stats)
+	}
+}
+
+func TestDispatcherFullQueue(t *testing.T) {
+	release := make(chan struct{})
+	dispatcher := NewDispatcher(func(tgbotapi.Update) { <-release }, 1, 1)
+
+	if err := dispatcher.Dispatch(context.Background(), chatUpdate(1, 1)); err != nil {
+		t.Fatalf("Dispatch: %v", err)
+	}
+
+	// Очередь занята: обновление не принимается после отмены контекста
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := dispatcher.Dispatch(ctx, chatUpdate(2, 2)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Dispatch в заполненную очередь: ошибка %v, ожидалась DeadlineExceeded", err)
+	}
+
+	// Shutdown не ждет дольше своего контекста
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer shutdownCancel()
+	if err := dispatcher.Shutdown(shutdownCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown с занятым обработчиком: ошибка %v, ожидалась DeadlineExceeded", err)
+	}
+
+	close(release)
+	if err := dispatcher.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if stats := dispatcher.Stats(); stats.Processed != 1 || stats.Throttled != 0 {
+		t.Errorf("Stats = %+v, ожидалось одно обработанное обновление", stats)
+	}
+}
@@ -0,0 +1,644 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Команда eval прогоняет сохраненные снимки рынка через GenerateAnalytics,
+// проверяет выпуски автоматическими проверками и сравнивает прогоны разных
+// версий промптов или моделей
+
+// Параметры команды eval по умолчанию
+const (
+	evalDefaultCorpus  = "eval/corpus"  // каталог снимков рынка
+	evalDefaultResults = "eval/results" // каталог результатов прогонов
+	evalStubProvider   = "stub"         // провайдер без модели: выпуск по шаблону из снимка
+)
+
+// evalUsage справка по команде eval
+const evalUsage = `Оценка качества выпусков на сохраненных снимках рынка.
+
+Использование:
+  ai-stocks-bot eval snapshot [-corpus каталог]
+      сохранить текущие данные биржи снимком в корпус
+  ai-stocks-bot eval run [-corpus каталог] [-out файл] [-label название]
+                         [-provider stub] [-model модель] [-prompt название=версия]
+                         [-kinds daily,premarket] [-personas cute,brief] [-budget 5000]
+      сгенерировать выпуски по всем снимкам корпуса и проверить их
+  ai-stocks-bot eval compare базовый.json новый.json
+      сравнить два прогона
+`
+
+// EvalRun результаты прогона корпуса снимков
+type EvalRun struct {
+	Label     string     `json:"label"`
+	Provider  string     `json:"provider"`
+	Prompts   string     `json:"prompts"` // версии шаблонов промптов
+	StartedAt time.Time  `json:"started_at"`
+	Cases     []EvalCase `json:"cases"`
+}
+
+// EvalCase выпуск, сгенерированный по одному снимку, и результаты его проверок
+type EvalCase struct {
+	Snapshot         string        `json:"snapshot"` // файл снимка в корпусе
+	Kind             AnalyticsKind `json:"kind"`
+	Persona          Persona       `json:"persona"`
+	Budget           float64       `json:"budget"`
+	Text             string        `json:"text,omitempty"`
+	Error            string        `json:"error,omitempty"`
+	Checks           []EvalCheck   `json:"checks"`
+	Score            float64       `json:"score"` // доля пройденных проверок
+	Calls            int           `json:"calls"` // обращений к модели, включая повторы после проверки
+	PromptTokens     int           `json:"prompt_tokens"`
+	CompletionTokens int           `json:"completion_tokens"`
+	Latency          time.Duration `json:"latency"`
+}
+
+// key идентифицирует выпуск при сравнении прогонов
+func (c EvalCase) key() string {
+	return fmt.Sprintf("%s/%s/%s/%s", c.Snapshot, c.Kind, c.Persona, formatMoney(c.Budget))
+}
+
+// runEval выполняет команду eval и возвращает код завершения процесса
+func runEval(ctx context.Context, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, evalUsage)
+		return 2
+	}
+
+	var err error
+	switch args[0] {
+	case "snapshot":
+		err = evalSnapshot(args[1:])
+	case "run":
+		err = evalRun(ctx, args[1:])
+	case "compare":
+		err = evalCompare(args[1:])
+	default:
+		fmt.Fprint(os.Stderr, evalUsage)
+		return 2
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		return 2
+	}
+	if err != nil {
+		log.Printf("Ошибка: %v", err)
+		return 1
+	}
+	return 0
+}
+
+// evalSnapshot сохраняет текущие данные биржи в корпус
+func evalSnapshot(args []string) error {
+	flags := flag.NewFlagSet("eval snapshot", flag.ContinueOnError)
+	corpus := flags.String("corpus", evalDefaultCorpus, "каталог снимков рынка")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, envErr, err := readConfig()
+	if err := errors.Join(err, envErr); err != nil {
+		return err
+	}
+	calendar := NewTradingCalendar(cfg.MarketData.ISSBaseURL, cfg.MarketData.TradingCalendarFile)
+	calendar.Refresh()
+
+	data, err := NewMarketDataService(cfg.MarketData, calendar).GetMarketData()
+	if err != nil {
+		return err
+	}
+	if data.StubIndices || data.StubStocks {
+		return fmt.Errorf("биржа не ответила, снимок с заглушками не сохранен")
+	}
+
+	content, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*corpus, 0o755); err != nil {
+		return err
+	}
+	path := filepath.Join(*corpus, time.Now().In(moscowLocation()).Format("2006-01-02-1504")+".json")
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		return err
+	}
+	log.Printf("Снимок сохранен в %s", path)
+	return nil
+}
+
+// evalRun генерирует выпуски по всем снимкам корпуса, проверяет их,
+// выводит сводку и сохраняет результаты для сравнения
+func evalRun(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("eval run", flag.ContinueOnError)
+	corpus := flags.String("corpus", evalDefaultCorpus, "каталог снимков рынка")
+	out := flags.String("out", "", "файл результатов (по умолчанию "+evalDefaultResults+"/<время>.json)")
+	label := flags.String("label", "", "название прогона в отчетах")
+	providerName := flags.String("provider", "", "stub - выпуск по шаблону без модели; по умолчанию провайдер из настроек")
+	model := flags.String("model", "", "модель вместо AI_MODEL_NAME")
+	kindsFlag := flags.String("kinds", string(AnalyticsDaily), "виды выпусков через запятую")
+	personasFlag := flags.String("personas", joinPersonas(personas), "стили через запятую")
+	budgetFlag := flags.String("budget", formatMoney(defaultBudget), "бюджет рекомендации в рублях")
+	versions := map[string]int{}
+	flags.Func("prompt", "версия шаблона: название=версия, можно указать несколько раз", func(value string) error {
+		name, version, ok := strings.Cut(value, "=")
+		v, err := strconv.Atoi(version)
+		if !ok || err != nil || v < 1 {
+			return fmt.Errorf("ожидается название=версия, получено %q", value)
+		}
+		versions[name] = v
+		return nil
+	})
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	kinds, err := parseEvalKinds(*kindsFlag)
+	if err != nil {
+		return err
+	}
+	selected, err := parseEvalPersonas(*personasFlag)
+	if err != nil {
+		return err
+	}
+	budget, err := ParseBudget(*budgetFlag)
+	if err != nil {
+		return fmt.Errorf("некорректный бюджет: %w", err)
+	}
+
+	cfg, envErr, err := readConfig()
+	if err := errors.Join(err, envErr); err != nil {
+		return err
+	}
+	// Резервные провайдеры отключены, чтобы все выпуски прогона написала одна модель
+	cfg.AI.Fallbacks = nil
+	if *model != "" {
+		cfg.AI.Model = *model
+	}
+	if len(versions) > 0 {
+		if cfg.Prompts.Versions == nil {
+			cfg.Prompts.Versions = map[string]int{}
+		}
+		for name, version := range versions {
+			cfg.Prompts.Versions[name] = version
+		}
+	}
+	if !factCheckModes[cfg.FactCheck.Mode] {
+		return fmt.Errorf("FACT_CHECK_MODE должен быть одним из regenerate, correct, flag, off, получено %q", cfg.FactCheck.Mode)
+	}
+
+	prompts, err := NewPromptRegistry(cfg.Prompts)
+	if err != nil {
+		return err
+	}
+	if err := prompts.Check(); err != nil {
+		return err
+	}
+
+	stub := *providerName == evalStubProvider
+	var provider LLMProvider
+	switch {
+	case stub:
+	case *providerName != "":
+		return fmt.Errorf("неизвестный провайдер %q: укажите %s или настройте провайдера через AI_PROVIDER", *providerName, evalStubProvider)
+	default:
+		if err := cfg.AI.ProviderConfig.Validate(); err != nil {
+			return fmt.Errorf("провайдер AI (AI_PROVIDER или ai.provider): %w", err)
+		}
+		if provider, err = NewLLMProviderChain(cfg.AI, nil); err != nil {
+			return err
+		}
+	}
+
+	snapshots, err := loadEvalCorpus(*corpus)
+	if err != nil {
+		return err
+	}
+
+	run := &EvalRun{
+		Label:     *label,
+		Provider:  evalStubProvider,
+		Prompts:   prompts.Versions(),
+		StartedAt: time.Now(),
+	}
+	if provider != nil {
+		run.Provider = provider.Name()
+	}
+	if run.Label == "" {
+		run.Label = run.Provider
+	}
+	log.Printf("Прогон %q: %d снимков × %d видов × %d стилей", run.Label, len(snapshots), len(kinds), len(selected))
+
+	for _, snapshot := range snapshots {
+		for _, kind := range kinds {
+			for _, persona := range selected {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+
+				counter := &evalProvider{provider: provider}
+				if stub {
+					counter.provider = &evalStub{data: snapshot.data, budget: budget}
+				}
+				market := NewMarketDataService(cfg.MarketData, nil)
+				market.Pin(snapshot.data)
+				// Без кэша, истории рекомендаций и инструментов: выпуск зависит только от снимка.
+				// Проверка соответствия требованиям тоже отключена, чтобы оценивался ответ модели.
+				aiService := NewAIService(counter, cfg.AI.Timeout, cfg.AI.OutputRetries, NewFactChecker(cfg.FactCheck), nil, nil,
+					market, NewAnalyticsCache(AnalyticsCacheConfig{}), nil, prompts, nil, nil)
+
+				started := time.Now()
+				text, genErr := aiService.GenerateAnalytics(ctx, kind, persona, budget)
+				evalCase := EvalCase{
+					Snapshot:         snapshot.name,
+					Kind:             kind,
+					Persona:          persona,
+					Budget:           budget,
+					Text:             text,
+					Calls:            counter.calls,
+					PromptTokens:     counter.promptTokens,
+					CompletionTokens: counter.completionTokens,
+					Latency:          time.Since(started),
+				}
+				if genErr != nil {
+					evalCase.Error = genErr.Error()
+				}
+				evalCase.Checks = runEvalChecks(evalCase, snapshot.data, cfg.FactCheck.PriceTolerance)
+				evalCase.Score = evalScore(evalCase.Checks)
+				run.Cases = append(run.Cases, evalCase)
+				log.Printf("%s: оценка %.2f", evalCase.key(), evalCase.Score)
+			}
+		}
+	}
+
+	path := *out
+	if path == "" {
+		path = filepath.Join(evalDefaultResults, run.StartedAt.Format("2006-01-02-150405")+".json")
+	}
+	if err := saveEvalRun(path, run); err != nil {
+		return err
+	}
+
+	writeEvalSummary(os.Stdout, run)
+	fmt.Printf("\nРезультаты сохранены в %s\n", path)
+	return nil
+}
+
+// evalCompare сравнивает два прогона и выводит изменения оценок
+func evalCompare(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("ожидается два файла результатов: eval compare базовый.json новый.json")
+	}
+	base, err := loadEvalRun(args[0])
+	if err != nil {
+		return err
+	}
+	candidate, err := loadEvalRun(args[1])
+	if err != nil {
+		return err
+	}
+
+	writeEvalComparison(os.Stdout, base, candidate)
+	return nil
+}
+
+// evalSnapshotFile снимок рынка из корпуса
+type evalSnapshotFile struct {
+	name string
+	data *MarketData
+}
+
+// loadEvalCorpus читает все снимки *.json из каталога в порядке имен файлов
+func loadEvalCorpus(dir string) ([]evalSnapshotFile, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("в каталоге %s нет снимков *.json, сохраните их командой eval snapshot", dir)
+	}
+	sort.Strings(paths)
+
+	snapshots := make([]evalSnapshotFile, 0, len(paths))
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var data MarketData
+		if err := json.Unmarshal(content, &data); err != nil {
+			return nil, fmt.Errorf("ошибка чтения снимка %s: %w", path, err)
+		}
+		snapshots = append(snapshots, evalSnapshotFile{name: filepath.Base(path), data: &data})
+	}
+	return snapshots, nil
+}
+
+// saveEvalRun сохраняет результаты прогона
+func saveEvalRun(path string, run *EvalRun) error {
+	content, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0o644)
+}
+
+// loadEvalRun читает результаты прогона
+func loadEvalRun(path string) (*EvalRun, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var run EvalRun
+	if err := json.Unmarshal(content, &run); err != nil {
+		return nil, fmt.Errorf("ошибка чтения результатов %s: %w", path, err)
+	}
+	return &run, nil
+}
+
+// parseEvalKinds разбирает список видов выпусков
+func parseEvalKinds(s string) ([]AnalyticsKind, error) {
+	known := map[AnalyticsKind]bool{
+		AnalyticsDaily: true, AnalyticsPreMarket: true, AnalyticsPostClose: true,
+		AnalyticsWeekly: true, AnalyticsRecap: true,
+	}
+	var kinds []AnalyticsKind
+	for _, name := range strings.Split(s, ",") {
+		kind := AnalyticsKind(strings.TrimSpace(name))
+		if !known[kind] {
+			return nil, fmt.Errorf("неизвестный вид выпуска %q", kind)
+		}
+		kinds = append(kinds, kind)
+	}
+	return kinds, nil
+}
+
+// parseEvalPersonas разбирает список стилей
+func parseEvalPersonas(s string) ([]Persona, error) {
+	var selected []Persona
+	for _, name := range strings.Split(s, ",") {
+		persona, ok := ParsePersona(name)
+		if !ok {
+			return nil, fmt.Errorf("неизвестный стиль %q", strings.TrimSpace(name))
+		}
+		selected = append(selected, persona)
+	}
+	return selected, nil
+}
+
+// joinPersonas перечисляет стили через запятую
+func joinPersonas(list []Persona) string {
+	names := make([]string, 0, len(list))
+	for _, p := range list {
+		names = append(names, string(p))
+	}
+	return strings.Join(names, ",")
+}
+
+// evalProvider считает обращения к модели и токены одного выпуска
+type evalProvider struct {
+	provider         LLMProvider
+	calls            int
+	promptTokens     int
+	completionTokens int
+}
+
+// Name возвращает название провайдера
+func (p *evalProvider) Name() string {
+	return p.provider.Name()
+}
+
+// Complete передает запрос провайдеру и учитывает расход токенов
+func (p *evalProvider) Complete(ctx context.Context, req CompletionRequest) (*Completion, error) {
+	p.calls++
+	completion, err := p.provider.Complete(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	p.promptTokens += completion.PromptTokens
+	p.completionTokens += completion.CompletionTokens
+	return completion, nil
+}
+
+// evalStub отвечает выпуском, собранным по шаблону из снимка: проверяет
+// прогон и сами проверки без обращения к модели
+type evalStub struct {
+	data   *MarketData
+	budget float64
+}
+
+// Name возвращает название провайдера
+func (p *evalStub) Name() string {
+	return evalStubProvider
+}
+
+// Complete возвращает выпуск по шаблону в формате ответа модели
+func (p *evalStub) Complete(ctx context.Context, req CompletionRequest) (*Completion, error) {
+	report := TemplateReport(p.data.ForBudget(p.budget), analyticsDate(p.data), p.budget)
+	content, err := json.Marshal(report)
+	if err != nil {
+		return nil, err
+	}
+	return &Completion{Text: string(content), Provider: evalStubProvider, Model: "template"}, nil
+}
+
+// evalSummary сводные показатели прогона
+type evalSummary struct {
+	cases            int
+	generated        int
+	score            float64                    // средняя оценка
+	checks           map[string][2]int          // проверка: пройдено, всего
+	personas         map[Persona][]float64      // оценки выпусков по стилям
+	failures         map[string]map[string]bool // непройденные проверки по выпускам
+	latency          time.Duration              // среднее время генерации
+	calls            float64                    // обращений к модели на выпуск
+	promptTokens     float64                    // токенов запроса на выпуск
+	completionTokens float64                    // токенов ответа на выпуск
+}
+
+// summarize подсчитывает сводные показатели прогона
+func (r *EvalRun) summarize() evalSummary {
+	s := evalSummary{
+		cases:    len(r.Cases),
+		checks:   map[string][2]int{},
+		personas: map[Persona][]float64{},
+		failures: map[string]map[string]bool{},
+	}
+	if s.cases == 0 {
+		return s
+	}
+
+	var latency time.Duration
+	for _, c := range r.Cases {
+		if c.Error == "" {
+			s.generated++
+		}
+		s.score += c.Score
+		s.personas[c.Persona] = append(s.personas[c.Persona], c.Score)
+		latency += c.Latency
+		s.calls += float64(c.Calls)
+		s.promptTokens += float64(c.PromptTokens)
+		s.completionTokens += float64(c.CompletionTokens)
+		for _, check := range c.Checks {
+			counts := s.checks[check.Name]
+			counts[1]++
+			if check.Passed {
+				counts[0]++
+			} else {
+				if s.failures[c.key()] == nil {
+					s.failures[c.key()] = map[string]bool{}
+				}
+				s.failures[c.key()][check.Name] = true
+			}
+			s.checks[check.Name] = counts
+		}
+	}
+
+	n := float64(s.cases)
+	s.score /= n
+	s.latency = latency / time.Duration(s.cases)
+	s.calls /= n
+	s.promptTokens /= n
+	s.completionTokens /= n
+	return s
+}
+
+// passRate возвращает долю пройденных проверок name
+func (s evalSummary) passRate(name string) float64 {
+	counts := s.checks[name]
+	if counts[1] == 0 {
+		return 0
+	}
+	return float64(counts[0]) / float64(counts[1])
+}
+
+// personaScore возвращает среднюю оценку выпусков в стиле persona
+func (s evalSummary) personaScore(persona Persona) (float64, bool) {
+	scores := s.personas[persona]
+	if len(scores) == 0 {
+		return 0, false
+	}
+	var sum float64
+	for _, score := range scores {
+		sum += score
+	}
+	return sum / float64(len(scores)), true
+}
+
+// writeEvalSummary выводит сводку прогона и непройденные проверки
+func writeEvalSummary(w io.Writer, run *EvalRun) {
+	s := run.summarize()
+	fmt.Fprintf(w, "Прогон: %s\nПровайдер: %s\nПромпты: %s\n\n", run.Label, run.Provider, run.Prompts)
+	fmt.Fprintf(w, "Выпусков: %d, сгенерировано: %d, средняя оценка: %.2f\n\n", s.cases, s.generated, s.score)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Проверка\tПройдено\t")
+	for _, name := range evalCheckNames {
+		counts := s.checks[name]
+		fmt.Fprintf(tw, "%s\t%d/%d (%.0f%%)\t\n", name, counts[0], counts[1], s.passRate(name)*100)
+	}
+	fmt.Fprintln(tw, "\t\t")
+	fmt.Fprintln(tw, "Стиль\tОценка\t")
+	for _, persona := range personas {
+		if score, ok := s.personaScore(persona); ok {
+			fmt.Fprintf(tw, "%s\t%.2f\t\n", persona, score)
+		}
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "\nНа выпуск: %s, обращений к модели %.1f, токенов %.0f + %.0f\n",
+		s.latency.Round(time.Millisecond), s.calls, s.promptTokens, s.completionTokens)
+
+	var failed []string
+	for _, c := range run.Cases {
+		for _, check := range c.Checks {
+			if !check.Passed {
+				failed = append(failed, fmt.Sprintf("  %s: %s - %s", c.key(), check.Name, check.Detail))
+			}
+		}
+	}
+	if len(failed) > 0 {
+		fmt.Fprintf(w, "\nНепройденные проверки:\n%s\n", strings.Join(failed, "\n"))
+	}
+}
+
+// writeEvalComparison выводит показатели двух прогонов рядом, а также
+// проверки, которые выпуски перестали или начали проходить
+func writeEvalComparison(w io.Writer, base, candidate *EvalRun) {
+	a, b := base.summarize(), candidate.summarize()
+	fmt.Fprintf(w, "Базовый: %s (%s, %s)\nНовый:   %s (%s, %s)\n\n",
+		base.Label, base.Provider, base.Prompts, candidate.Label, candidate.Provider, candidate.Prompts)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "Показатель\tБазовый\tНовый\tРазница\t")
+	fmt.Fprintf(tw, "оценка\t%.2f\t%.2f\t%+.2f\t\n", a.score, b.score, b.score-a.score)
+	fmt.Fprintf(tw, "сгенерировано\t%d/%d\t%d/%d\t\t\n", a.generated, a.cases, b.generated, b.cases)
+	for _, name := range evalCheckNames {
+		fmt.Fprintf(tw, "%s\t%.0f%%\t%.0f%%\t%+.0f\t\n", name, a.passRate(name)*100, b.passRate(name)*100, (b.passRate(name)-a.passRate(name))*100)
+	}
+	for _, persona := range personas {
+		scoreA, okA := a.personaScore(persona)
+		scoreB, okB := b.personaScore(persona)
+		switch {
+		case okA && okB:
+			fmt.Fprintf(tw, "стиль %s\t%.2f\t%.2f\t%+.2f\t\n", persona, scoreA, scoreB, scoreB-scoreA)
+		case okA:
+			fmt.Fprintf(tw, "стиль %s\t%.2f\t-\t\t\n", persona, scoreA)
+		case okB:
+			fmt.Fprintf(tw, "стиль %s\t-\t%.2f\t\t\n", persona, scoreB)
+		}
+	}
+	fmt.Fprintf(tw, "время на выпуск\t%s\t%s\t%+.1fs\t\n",
+		a.latency.Round(100*time.Millisecond), b.latency.Round(100*time.Millisecond), (b.latency - a.latency).Seconds())
+	fmt.Fprintf(tw, "обращений к модели\t%.1f\t%.1f\t%+.1f\t\n", a.calls, b.calls, b.calls-a.calls)
+	fmt.Fprintf(tw, "токенов запроса\t%.0f\t%.0f\t%+.0f\t\n", a.promptTokens, b.promptTokens, b.promptTokens-a.promptTokens)
+	fmt.Fprintf(tw, "токенов ответа\t%.0f\t%.0f\t%+.0f\t\n", a.completionTokens, b.completionTokens, b.completionTokens-a.completionTokens)
+	tw.Flush()
+
+	// Изменения сравниваются только для выпусков, которые есть в обоих прогонах
+	inBase := map[string]bool{}
+	for _, c := range base.Cases {
+		inBase[c.key()] = true
+	}
+	var regressions, fixes []string
+	common := 0
+	for _, c := range candidate.Cases {
+		key := c.key()
+		if !inBase[key] {
+			continue
+		}
+		common++
+		for _, name := range evalCheckNames {
+			failedBefore, failedNow := a.failures[key][name], b.failures[key][name]
+			switch {
+			case failedNow && !failedBefore:
+				regressions = append(regressions, fmt.Sprintf("  %s: %s", key, name))
+			case failedBefore && !failedNow:
+				fixes = append(fixes, fmt.Sprintf("  %s: %s", key, name))
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "\nОбщих выпусков: %d из %d и %d\n", common, a.cases, b.cases)
+	if len(regressions) > 0 {
+		fmt.Fprintf(w, "\nПерестали проходить:\n%s\n", strings.Join(regressions, "\n"))
+	}
+	if len(fixes) > 0 {
+		fmt.Fprintf(w, "\nНачали проходить:\n%s\n", strings.Join(fixes, "\n"))
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Автоматические проверки выпусков для команды eval
+
+// Названия проверок в порядке вывода
+const (
+	EvalCheckSections   = "sections"   // все разделы выпуска на месте
+	EvalCheckDisclaimer = "disclaimer" // есть оговорка, что это не инвестиционная рекомендация
+	EvalCheckLength     = "length"     // длина в пределах для стиля
+	EvalCheckPrices     = "prices"     // цены акций в тексте совпадают со снимком
+	EvalCheckEmoji      = "emoji"      // плотность эмодзи соответствует стилю
+)
+
+// evalCheckNames проверки в порядке вывода
+var evalCheckNames = []string{EvalCheckSections, EvalCheckDisclaimer, EvalCheckLength, EvalCheckPrices, EvalCheckEmoji}
+
+// EvalCheck результат одной проверки выпуска
+type EvalCheck struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// evalRange допустимые границы показателя
+type evalRange struct {
+	min, max float64
+}
+
+// evalLengthLimits допустимая длина выпуска в символах по стилям
+var evalLengthLimits = map[Persona]evalRange{
+	PersonaCute:         {min: 400, max: telegramMessageLimit},
+	PersonaNeutral:      {min: 400, max: telegramMessageLimit},
+	PersonaProfessional: {min: 400, max: telegramMessageLimit},
+	PersonaBrief:        {min: 200, max: 1500},
+}
+
+// evalEmojiLimits допустимое число эмодзи на 1000 символов по стилям.
+// Заголовки разделов дают около пяти эмодзи на выпуск.
+var evalEmojiLimits = map[Persona]evalRange{
+	PersonaCute:         {min: 6, max: 60},
+	PersonaNeutral:      {min: 2, max: 25},
+	PersonaProfessional: {min: 0, max: 0},
+	PersonaBrief:        {min: 2, max: 25},
+}
+
+// evalDisclaimerPattern оговорка, что выпуск не инвестиционная рекомендация
+var evalDisclaimerPattern = regexp.MustCompile("(?i)" + defaultDisclaimerPattern)
+
+// evalPricePattern сумма в рублях: "305,10 ₽", "3 051 ₽" или "305 руб"
+var evalPricePattern = regexp.MustCompile(`(\d[\d \x{00a0}\x{202f}]*(?:[.,]\d+)?)\s*(?:₽|руб)`)
+
+// evalPriceWindow сколько байт текста после тикера просматривается в поисках цены
+const evalPriceWindow = 200
+
+// runEvalChecks проверяет выпуск, сгенерированный по снимку data. Если выпуск
+// не сгенерирован, все проверки считаются непройденными.
+func runEvalChecks(c EvalCase, data *MarketData, priceTolerance float64) []EvalCheck {
+	if c.Error != "" || c.Text == "" {
+		checks := make([]EvalCheck, 0, len(evalCheckNames))
+		for _, name := range evalCheckNames {
+			checks = append(checks, EvalCheck{Name: name, Detail: "выпуск не сгенерирован"})
+		}
+		return checks
+	}
+
+	persona := c.Persona.OrDefault()
+	return []EvalCheck{
+		checkEvalSections(c.Text, persona),
+		checkEvalDisclaimer(c.Text),
+		checkEvalLength(c.Text, persona),
+		checkEvalPrices(c.Text, data, priceTolerance),
+		checkEvalEmoji(c.Text, persona),
+	}
+}
+
+// evalScore возвращает долю пройденных проверок
+func evalScore(checks []EvalCheck) float64 {
+	if len(checks) == 0 {
+		return 0
+	}
+	passed := 0
+	for _, check := range checks {
+		if check.Passed {
+			passed++
+		}
+	}
+	return float64(passed) / float64(len(checks))
+}
+
+// checkEvalSections проверяет, что в выпуске есть все разделы стиля
+func checkEvalSections(text string, persona Persona) EvalCheck {
+	sections := []string{"Аналитика на", "Ситуация на рынке", "Куда вложить", "Почему это выгодно"}
+	if persona.Style().SideHustle {
+		sections = append(sections, "Идея для подработки")
+	}
+
+	var missing []string
+	for _, section := range sections {
+		if !strings.Contains(text, section) {
+			missing = append(missing, section)
+		}
+	}
+	if len(missing) > 0 {
+		return EvalCheck{Name: EvalCheckSections, Detail: "нет разделов: " + strings.Join(missing, ", ")}
+	}
+	return EvalCheck{Name: EvalCheckSections, Passed: true}
+}
+
+// checkEvalDisclaimer проверяет оговорку про инвестиционную рекомендацию
+func checkEvalDisclaimer(text string) EvalCheck {
+	if !evalDisclaimerPattern.MatchString(text) {
+		return EvalCheck{Name: EvalCheckDisclaimer, Detail: "нет оговорки, что это не инвестиционная рекомендация"}
+	}
+	return EvalCheck{Name: EvalCheckDisclaimer, Passed: true}
+}
+
+// checkEvalLength проверяет длину выпуска
+func checkEvalLength(text string, persona Persona) EvalCheck {
+	limits := evalLengthLimits[persona]
+	length := utf8.RuneCountInString(text)
+	detail := fmt.Sprintf("%d символов, ожидается от %.0f до %.0f", length, limits.min, limits.max)
+	return EvalCheck{
+		Name:   EvalCheckLength,
+		Passed: float64(length) >= limits.min && float64(length) <= limits.max,
+		Detail: detail,
+	}
+}
+
+// checkEvalPrices находит после каждого упоминания тикера из снимка ближайшую
+// сумму в рублях и сравнивает ее с ценой акции. Выпуск без цен акций проверку проходит.
+func checkEvalPrices(text string, data *MarketData, tolerance float64) EvalCheck {
+	stocks := data.Stocks
+	if len(stocks) == 0 {
+		stocks = data.TopStocks
+	}
+
+	checked := 0
+	var wrong []string
+	for _, stock := range stocks {
+		if stock.Ticker == "" || stock.Price <= 0 {
+			continue
+		}
+		mention := regexp.MustCompile(`\b` + regexp.QuoteMeta(stock.Ticker) + `\b`)
+		for _, loc := range mention.FindAllStringIndex(text, -1) {
+			end := loc[1] + evalPriceWindow
+			if end > len(text) {
+				end = len(text)
+			}
+			for end < len(text) && !utf8.RuneStart(text[end]) {
+				end++
+			}
+
+			match := evalPricePattern.FindStringSubmatch(text[loc[1]:end])
+			if match == nil {
+				continue
+			}
+			price, ok := parseEvalAmount(match[1])
+			if !ok {
+				continue
+			}
+			checked++
+			if math.Abs(price-stock.Price) > stock.Price*tolerance {
+				wrong = append(wrong, fmt.Sprintf("%s: %s ₽ вместо %s ₽", stock.Ticker, formatMoney(price), formatMoney(stock.Price)))
+			}
+		}
+	}
+
+	switch {
+	case len(wrong) > 0:
+		return EvalCheck{Name: EvalCheckPrices, Detail: fmt.Sprintf("неверно %d из %d: %s", len(wrong), checked, strings.Join(wrong, "; "))}
+	case checked == 0:
+		return EvalCheck{Name: EvalCheckPrices, Passed: true, Detail: "цен акций в тексте нет"}
+	}
+	return EvalCheck{Name: EvalCheckPrices, Passed: true, Detail: fmt.Sprintf("верно %d из %d", checked, checked)}
+}
+
+// parseEvalAmount разбирает сумму с пробелами между разрядами и десятичной запятой
+func parseEvalAmount(s string) (float64, bool) {
+	s = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\u00a0', '\u202f':
+			return -1
+		case ',':
+			return '.'
+		}
+		return r
+	}, s)
+	amount, err := strconv.ParseFloat(s, 64)
+	return amount, err == nil
+}
+
+// checkEvalEmoji проверяет количество эмодзи на 1000 символов
+func checkEvalEmoji(text string, persona Persona) EvalCheck {
+	limits := evalEmojiLimits[persona]
+	emoji, length := 0, 0
+	for _, r := range text {
+		length++
+		// Соединители и селекторы вариаций - части эмодзи, а не отдельные эмодзи
+		if isEmoji(r) && r != 0x200D && (r < 0xFE00 || r > 0xFE0F) {
+			emoji++
+		}
+	}
+	density := 0.0
+	if length > 0 {
+		density = float64(emoji) * 1000 / float64(length)
+	}
+	return EvalCheck{
+		Name:   EvalCheckEmoji,
+		Passed: density >= limits.min && density <= limits.max,
+		Detail: fmt.Sprintf("%.1f на 1000 символов, ожидается от %g до %g", density, limits.min, limits.max),
+	}
+}
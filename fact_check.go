@@ -0,0 +1,377 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Режимы обработки расхождений выпуска с данными биржи
+const (
+	FactCheckRegenerate = "regenerate" // переспросить модель, затем исправить оставшееся
+	FactCheckCorrect    = "correct"    // сразу заменить числа фактическими значениями
+	FactCheckFlag       = "flag"       // оставить текст, но добавить предупреждение
+	FactCheckOff        = "off"        // не сверять
+)
+
+// factCheckModes допустимые режимы сверки
+var factCheckModes = map[string]bool{
+	FactCheckRegenerate: true,
+	FactCheckCorrect:    true,
+	FactCheckFlag:       true,
+	FactCheckOff:        true,
+}
+
+// factCheckWindow сколько байт текста после упоминания просматривается в поисках числа
+const factCheckWindow = 160
+
+// Шаблоны упоминаний индексов и валют в тексте модели
+var (
+	imoexMention = regexp.MustCompile(`(?i)IMOEX|индекс[а-яё]*\s+(?:Мосбиржи|Московской биржи|MOEX|ММВБ)`)
+	rtsMention   = regexp.MustCompile(`(?i)RTSI|индекс[а-яё]*\s+(?:РТС|RTS)`)
+	usdMention   = regexp.MustCompile(`(?i)доллар[а-яё]*|USD|\$`)
+	eurMention   = regexp.MustCompile(`(?i)евро|EUR|€`)
+
+	// factNumber число с необязательными разделителями тысяч и дробной частью и единица после него
+	factNumber = regexp.MustCompile(`(\d{1,3}(?:[ \x{00a0}]\d{3})+|\d+)(?:[.,](\d+))?\s*(%|₽|руб|р\.|пункт|п\.|год|г\.)?`)
+	// factSentenceEnd граница предложения, дальше которой число не относится к упоминанию
+	factSentenceEnd = regexp.MustCompile(`[.!?;]\s|\n`)
+)
+
+// factAbbreviations сокращения, точка после которых не заканчивает предложение
+var factAbbreviations = []string{"р", "руб", "тыс", "млн", "млрд"}
+
+// Discrepancy расхождение числа в выпуске с данными биржи
+type Discrepancy struct {
+	Field   string  // поле отчета
+	Subject string  // что сверялось: тикер, индекс или валюта
+	Unit    string  // единица для вывода
+	Stated  float64 // значение в выпуске
+	Actual  float64 // значение по данным биржи
+
+	// Положение числа в тексте поля; для recommendation.price не используется
+	start, end int
+}
+
+// String описывает расхождение для модели, лога и предупреждения в выпуске
+func (d Discrepancy) String() string {
+	return fmt.Sprintf("%s: в выпуске %s%s, по данным биржи %s%s",
+		d.Subject, formatMoney(d.Stated), d.Unit, formatMoney(d.Actual), d.Unit)
+}
+
+// discrepancyError описывает расхождения для повторного запроса к модели
+func discrepancyError(discrepancies []Discrepancy) error {
+	lines := make([]string, 0, len(discrepancies)+1)
+	lines = append(lines, "числа расходятся с переданными данными о рынке, используй значения из них:")
+	for _, d := range discrepancies {
+		lines = append(lines, fmt.Sprintf("- %s (поле %s)", d, d.Field))
+	}
+	return errors.New(strings.Join(lines, "\n"))
+}
+
+// factCheckTarget значение, которое ищется в тексте выпуска
+type factCheckTarget struct {
+	subject    string
+	unit       string
+	mention    *regexp.Regexp
+	actual     float64
+	tolerance  float64
+	needsRuble bool // число засчитывается, только если после него указаны рубли
+}
+
+// FactChecker сверяет тикеры, цены, индексы и курсы валют в выпуске с данными,
+// которые вернул MarketDataService
+type FactChecker struct {
+	config FactCheckConfig
+}
+
+// NewFactChecker создает сверку с указанными допусками
+func NewFactChecker(config FactCheckConfig) *FactChecker {
+	return &FactChecker{config: config}
+}
+
+// Mode возвращает режим обработки расхождений
+func (c *FactChecker) Mode() string {
+	return c.config.Mode
+}
+
+// Check возвращает все найденные расхождения. Значения, вместо которых подставлены
+// заглушки, не сверяются.
+func (c *FactChecker) Check(report *AnalyticsReport, data *MarketData) []Discrepancy {
+	if c.config.Mode == FactCheckOff || data == nil {
+		return nil
+	}
+
+	var discrepancies []Discrepancy
+
+	// Рекомендация: цена бумаги из структурированного поля
+	rec := report.Recommendation
+	if exchangeInstruments[rec.Type] && !data.StubStocks {
+		if stock, ok := findStock(data, rec.Ticker); ok && outOfTolerance(rec.Price, stock.Price, c.config.PriceTolerance) {
+			discrepancies = append(discrepancies, Discrepancy{
+				Field:   "recommendation.price",
+				Subject: stock.Ticker,
+				Unit:    " ₽",
+				Stated:  rec.Price,
+				Actual:  stock.Price,
+			})
+		}
+	}
+
+	// Текстовые поля: числа рядом с упоминаниями тикеров, индексов и валют
+	targets := c.targets(data)
+	for _, field := range reportTextFields(report) {
+		discrepancies = append(discrepancies, checkText(field.name, *field.text, targets)...)
+	}
+	return discrepancies
+}
+
+// Resolve исправляет расхождения (кроме режима flag), а те, что исправить не
+// удалось, выносит в предупреждение выпуска
+func (c *FactChecker) Resolve(report *AnalyticsReport, discrepancies []Discrepancy, budget float64) {
+	if len(discrepancies) == 0 {
+		return
+	}
+
+	remaining := discrepancies
+	if c.config.Mode != FactCheckFlag {
+		remaining = correctReport(report, discrepancies, budget)
+		if fixed := len(discrepancies) - len(remaining); fixed > 0 {
+			log.Printf("Сверка с данными биржи: исправлено расхождений: %d", fixed)
+		}
+	}
+
+	for _, d := range remaining {
+		log.Printf("Сверка с данными биржи: %s (поле %s)", d, d.Field)
+		report.Warnings = append(report.Warnings, d.String())
+	}
+}
+
+// targets собирает значения для сверки из снимка рынка
+func (c *FactChecker) targets(data *MarketData) []factCheckTarget {
+	var targets []factCheckTarget
+	if !data.StubIndices {
+		add := func(subject, unit string, mention *regexp.Regexp, actual, tolerance float64, needsRuble bool) {
+			if actual > 0 {
+				targets = append(targets, factCheckTarget{subject, unit, mention, actual, tolerance, needsRuble})
+			}
+		}
+		add("индекс Мосбиржи", " п.", imoexMention, data.IndexMOEX, c.config.IndexTolerance, false)
+		add("индекс РТС", " п.", rtsMention, data.IndexRTS, c.config.IndexTolerance, false)
+		add("курс доллара", " ₽", usdMention, data.USDRate, c.config.FXTolerance, true)
+		add("курс евро", " ₽", eurMention, data.EURRate, c.config.FXTolerance, true)
+	}
+	if !data.StubStocks {
+		for _, stock := range data.TopStocks {
+			if stock.Ticker == "" || stock.Price <= 0 {
+				continue
+			}
+			mention := regexp.MustCompile(`\b` + regexp.QuoteMeta(stock.Ticker) + `\b`)
+			targets = append(targets, factCheckTarget{stock.Ticker, " ₽", mention, stock.Price, c.config.PriceTolerance, true})
+		}
+	}
+	return targets
+}
+
+// factMention упоминание значения для сверки в тексте
+type factMention struct {
+	target     *factCheckTarget
+	start, end int
+}
+
+// checkText находит в тексте упоминания всех targets и сверяет первое подходящее
+// число после каждого. Число ищется до конца предложения или до следующего упоминания.
+func checkText(field, text string, targets []factCheckTarget) []Discrepancy {
+	var mentions []factMention
+	for i := range targets {
+		for _, loc := range targets[i].mention.FindAllStringIndex(text, -1) {
+			mentions = append(mentions, factMention{&targets[i], loc[0], loc[1]})
+		}
+	}
+	sort.Slice(mentions, func(i, j int) bool { return mentions[i].start < mentions[j].start })
+
+	var discrepancies []Discrepancy
+	for i, mention := range mentions {
+		limit := len(text)
+		if i+1 < len(mentions) {
+			limit = mentions[i+1].start
+		}
+		if limit < mention.end {
+			continue // упоминания пересекаются: "индекс Мосбиржи IMOEX"
+		}
+		window := text[mention.end:limit]
+		if len(window) > factCheckWindow {
+			window = window[:factCheckWindow]
+		}
+		if end := sentenceEnd(window); end >= 0 {
+			window = window[:end]
+		}
+
+		target := mention.target
+		for _, m := range factNumber.FindAllStringSubmatchIndex(window, -1) {
+			unit := ""
+			if m[6] != -1 {
+				unit = window[m[6]:m[7]]
+			}
+			if unit == "%" || unit == "год" || unit == "г." {
+				continue // изменение в процентах или год, а не уровень
+			}
+			if target.needsRuble && unit != "₽" && unit != "руб" && unit != "р." {
+				continue
+			}
+
+			stated := parseFactNumber(window[m[2]:m[3]], window, m[4], m[5])
+			if outOfTolerance(stated, target.actual, target.tolerance) {
+				discrepancies = append(discrepancies, Discrepancy{
+					Field:   field,
+					Subject: target.subject,
+					Unit:    target.unit,
+					Stated:  stated,
+					Actual:  target.actual,
+					start:   mention.end + m[2],
+					end:     mention.end + numberEnd(m),
+				})
+			}
+			break
+		}
+	}
+	return discrepancies
+}
+
+// correctReport заменяет числа с расхождениями фактическими значениями и
+// возвращает расхождения, которые исправить не удалось
+func correctReport(report *AnalyticsReport, discrepancies []Discrepancy, budget float64) []Discrepancy {
+	var remaining []Discrepancy
+	byField := make(map[string][]Discrepancy)
+	for _, d := range discrepancies {
+		if d.Field == "recommendation.price" {
+			if !correctRecommendation(&report.Recommendation, d.Actual, budget) {
+				remaining = append(remaining, d)
+			}
+			continue
+		}
+		byField[d.Field] = append(byField[d.Field], d)
+	}
+
+	for _, field := range reportTextFields(report) {
+		list := byField[field.name]
+		// Заменяем с конца, чтобы не сдвигались позиции остальных чисел
+		sort.Slice(list, func(i, j int) bool { return list[i].start > list[j].start })
+		text := *field.text
+		for _, d := range list {
+			text = text[:d.start] + formatMoney(math.Round(d.Actual*100)/100) + text[d.end:]
+		}
+		*field.text = text
+	}
+	return remaining
+}
+
+// correctRecommendation пересчитывает рекомендацию по фактической цене.
+// Если на бюджет не хватает даже одного лота, исправить рекомендацию нельзя.
+func correctRecommendation(rec *Recommendation, price, budget float64) bool {
+	lotCost := price * float64(rec.LotSize)
+	lots := int(budget / lotCost)
+	if lots < 1 {
+		return false
+	}
+	if lots > rec.Lots {
+		lots = rec.Lots
+	}
+
+	rec.Price = price
+	rec.Lots = lots
+	rec.Total = math.Round(lotCost*float64(lots)*100) / 100
+	return true
+}
+
+// reportField текстовое поле отчета, в котором ищутся числа
+type reportField struct {
+	name string
+	text *string
+}
+
+// reportTextFields возвращает свободные текстовые поля отчета
+func reportTextFields(report *AnalyticsReport) []reportField {
+	return []reportField{
+		{"greeting", &report.Greeting},
+		{"market_summary", &report.MarketSummary},
+		{"rationale", &report.Rationale},
+		{"side_hustle.text", &report.SideHustle.Text},
+		{"farewell", &report.Farewell},
+	}
+}
+
+// findStock ищет бумагу в снимке рынка по тикеру
+func findStock(data *MarketData, ticker string) (StockInfo, bool) {
+	ticker = strings.ToUpper(strings.TrimSpace(ticker))
+	for _, stock := range append(data.TopStocks, data.Stocks...) {
+		if stock.Ticker == ticker {
+			return stock, true
+		}
+	}
+	if data.RecommendedStock.Ticker == ticker && ticker != "" {
+		return data.RecommendedStock, true
+	}
+	return StockInfo{}, false
+}
+
+// outOfTolerance сообщает, что значение отличается от фактического больше допуска
+func outOfTolerance(stated, actual, tolerance float64) bool {
+	if actual <= 0 {
+		return false
+	}
+	return math.Abs(stated-actual)/actual > tolerance
+}
+
+// parseFactNumber разбирает число из текста: пробелы внутри - разделители тысяч,
+// запятая или точка - десятичный разделитель
+func parseFactNumber(whole, text string, fracStart, fracEnd int) float64 {
+	digits := strings.NewReplacer(" ", "", "\u00a0", "").Replace(whole)
+	if fracStart != -1 {
+		digits += "." + text[fracStart:fracEnd]
+	}
+	n, _ := strconv.ParseFloat(digits, 64)
+	return n
+}
+
+// numberEnd возвращает конец числа без единицы измерения
+func numberEnd(m []int) int {
+	if m[5] != -1 {
+		return m[5]
+	}
+	return m[3]
+}
+
+// sentenceEnd возвращает начало первой границы предложения в text или -1.
+// Точка после сокращения, как в "1 200 р. за акцию", границей не считается.
+func sentenceEnd(text string) int {
+	for _, m := range factSentenceEnd.FindAllStringIndex(text, -1) {
+		if text[m[0]] == '.' && endsWithAbbreviation(text[:m[0]]) {
+			continue
+		}
+		return m[0]
+	}
+	return -1
+}
+
+// endsWithAbbreviation проверяет, что text заканчивается отдельным словом из factAbbreviations
+func endsWithAbbreviation(text string) bool {
+	text = strings.ToLower(text)
+	for _, abbr := range factAbbreviations {
+		if !strings.HasSuffix(text, abbr) {
+			continue
+		}
+		before, _ := utf8.DecodeLastRuneInString(strings.TrimSuffix(text, abbr))
+		if before == utf8.RuneError || !unicode.IsLetter(before) {
+			return true
+		}
+	}
+	return false
+}
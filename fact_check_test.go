@@ -0,0 +1,166 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testFactCheckConfig допуски сверки по умолчанию в режиме config.Mode
+func testFactCheckConfig(mode string) FactCheckConfig {
+	config := DefaultConfig().FactCheck
+	config.Mode = mode
+	return config
+}
+
+// testMarketData снимок рынка для сверки
+func testMarketData() *MarketData {
+	return &MarketData{
+		IndexMOEX: 3200,
+		IndexRTS:  1100,
+		USDRate:   90,
+		EURRate:   98,
+		TopStocks: []StockInfo{{Ticker: "SBER", Price: 300, LotSize: 10}},
+	}
+}
+
+func TestFactCheckNumbers(t *testing.T) {
+	type found struct {
+		Subject string
+		Stated  float64
+	}
+
+	tests := []struct {
+		name string
+		text string
+		want []found
+	}{
+		{
+			name: "уровень индекса после процентов",
+			text: "Индекс Мосбиржи IMOEX вырос на 1,5% до 3 500 пунктов.",
+			want: []found{{"индекс Мосбиржи", 3500}},
+		},
+		{
+			name: "значение в пределах допуска",
+			text: "IMOEX: 3210 п.",
+		},
+		{
+			name: "курс с дробной частью",
+			text: "Доллар стоит 95,50 ₽, евро - 98 ₽.",
+			want: []found{{"курс доллара", 95.5}},
+		},
+		{
+			name: "курс без рублей не сверяется",
+			text: "Доллар подешевел на 2 за неделю.",
+		},
+		{
+			name: "цена акции",
+			text: "Акции SBER торгуются по 330 руб.",
+			want: []found{{"SBER", 330}},
+		},
+		{
+			name: "точка после сокращения рублей не заканчивает предложение",
+			text: "SBER стоит 1 200 р. за акцию.",
+			want: []found{{"SBER", 1200}},
+		},
+		{
+			name: "сокращение тысяч перед ценой",
+			text: "SBER: оборот 5 млн. руб., цена 330 руб.",
+			want: []found{{"SBER", 330}},
+		},
+		{
+			name: "точка после слова на -р заканчивает предложение",
+			text: "Акции SBER торгуются у ставки ЦБР. Депозит 500 000 ₽.",
+		},
+		{
+			name: "год не принимается за цену",
+			text: "SBER в 2025 году стоит 301 ₽",
+		},
+		{
+			name: "число после конца предложения не относится к упоминанию",
+			text: "Индекс РТС снизился. Ставка ЦБ 16%, депозит 500 000 ₽.",
+		},
+		{
+			name: "разделитель тысяч - неразрывный пробел",
+			text: "RTSI закрылся на 1\u00a0300 пунктах",
+			want: []found{{"индекс РТС", 1300}},
+		},
+		{
+			name: "каждое упоминание сверяется отдельно",
+			text: "IMOEX - 3000 п., а доллар - 80 ₽",
+			want: []found{{"индекс Мосбиржи", 3000}, {"курс доллара", 80}},
+		},
+	}
+
+	checker := NewFactChecker(testFactCheckConfig(FactCheckCorrect))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := &AnalyticsReport{MarketSummary: tt.text}
+			var got []found
+			for _, d := range checker.Check(report, testMarketData()) {
+				got = append(got, found{d.Subject, d.Stated})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Check(%q) = %v, ожидалось %v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFactCheckResolve(t *testing.T) {
+	recommendation := Recommendation{Instrument: "Сбербанк", Type: InstrumentShare, Ticker: "SBER", Price: 330, LotSize: 10, Lots: 3, Total: 9900}
+
+	tests := []struct {
+		name         string
+		mode         string
+		budget       float64
+		summary      string
+		wantSummary  string
+		wantRec      Recommendation
+		wantWarnings int
+	}{
+		{
+			name:        "числа заменяются фактическими",
+			mode:        FactCheckCorrect,
+			budget:      10000,
+			summary:     "IMOEX поднялся до 3 500 пунктов, доллар - 95,50 ₽.",
+			wantSummary: "IMOEX поднялся до 3200 пунктов, доллар - 90 ₽.",
+			wantRec:     Recommendation{Instrument: "Сбербанк", Type: InstrumentShare, Ticker: "SBER", Price: 300, LotSize: 10, Lots: 3, Total: 9000},
+		},
+		{
+			name:         "на бюджет не хватает лота по фактической цене",
+			mode:         FactCheckCorrect,
+			budget:       2000,
+			summary:      "IMOEX - 3 500 п.",
+			wantSummary:  "IMOEX - 3200 п.",
+			wantRec:      recommendation,
+			wantWarnings: 1,
+		},
+		{
+			name:         "в режиме flag текст не меняется",
+			mode:         FactCheckFlag,
+			budget:       10000,
+			summary:      "IMOEX - 3 500 п.",
+			wantSummary:  "IMOEX - 3 500 п.",
+			wantRec:      recommendation,
+			wantWarnings: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := NewFactChecker(testFactCheckConfig(tt.mode))
+			report := &AnalyticsReport{MarketSummary: tt.summary, Recommendation: recommendation}
+
+			checker.Resolve(report, checker.Check(report, testMarketData()), tt.budget)
+			if report.MarketSummary != tt.wantSummary {
+				t.Errorf("обзор = %q, ожидалось %q", report.MarketSummary, tt.wantSummary)
+			}
+			if report.Recommendation != tt.wantRec {
+				t.Errorf("рекомендация = %+v, ожидалось %+v", report.Recommendation, tt.wantRec)
+			}
+			if len(report.Warnings) != tt.wantWarnings {
+				t.Errorf("предупреждений %d, ожидалось %d: %v", len(report.Warnings), tt.wantWarnings, report.Warnings)
+			}
+		})
+	}
+}
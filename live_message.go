@@ -0,0 +1,173 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Ограничения редактирования сообщений
+const (
+	telegramMessageLimit = 4096                    // максимальная длина сообщения в символах
+	privateEditInterval  = 1500 * time.Millisecond // пауза между правками в личном чате
+	groupEditInterval    = 3 * time.Second         // в группах Telegram допускает 20 сообщений в минуту
+	liveCursor           = " ▌"                    // признак того, что текст еще генерируется
+)
+
+// LiveMessage постепенно обновляет сообщение-заглушку по мере генерации текста.
+// Правки идут не чаще, чем позволяет Telegram, промежуточный текст выводится без
+// разметки (незакрытые `*` и `_` ломают Markdown), а итоговый - с разметкой.
+type LiveMessage struct {
+	bot       *tgbotapi.BotAPI
+	chatID    int64
+	messageID int
+	interval  time.Duration
+
+	mu      sync.Mutex
+	text    string
+	changed chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// NewLiveMessage начинает обновление сообщения messageID в чате chatID
+func NewLiveMessage(bot *tgbotapi.BotAPI, chatID int64, messageID int) *LiveMessage {
+	interval := privateEditInterval
+	if chatID < 0 {
+		interval = groupEditInterval
+	}
+
+	m := &LiveMessage{
+		bot:       bot,
+		chatID:    chatID,
+		messageID: messageID,
+		interval:  interval,
+		changed:   make(chan struct{}, 1),
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	go m.loop()
+	return m
+}
+
+// Update запоминает текущий текст; сообщение обновится при следующей разрешенной правке
+func (m *LiveMessage) Update(text string) {
+	m.mu.Lock()
+	m.text = text
+	m.mu.Unlock()
+
+	select {
+	case m.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Finish останавливает промежуточные правки и выводит итоговый текст с разметкой.
+// Текст длиннее лимита Telegram продолжается следующими сообщениями.
+func (m *LiveMessage) Finish(text string) {
+	m.stop()
+
+	parts := splitMessage(text, telegramMessageLimit)
+	for i, part := range parts {
+		if i == 0 {
+			edit := tgbotapi.NewEditMessageText(m.chatID, m.messageID, part)
+			edit.ParseMode = "Markdown"
+			_, err := m.bot.Send(edit)
+			if err != nil && isMarkdownError(err) {
+				// Модель прислала некорректную разметку - показываем текст как есть
+				_, err = m.bot.Send(tgbotapi.NewEditMessageText(m.chatID, m.messageID, part))
+			}
+			logEditError(err)
+			continue
+		}
+
+		msg := tgbotapi.NewMessage(m.chatID, part)
+		msg.ParseMode = "Markdown"
+		if _, err := m.bot.Send(msg); err != nil && isMarkdownError(err) {
+			m.bot.Send(tgbotapi.NewMessage(m.chatID, part))
+		}
+	}
+}
+
+// Fail останавливает промежуточные правки и заменяет сообщение текстом ошибки
+func (m *LiveMessage) Fail(text string) {
+	m.stop()
+	_, err := m.bot.Send(tgbotapi.NewEditMessageText(m.chatID, m.messageID, text))
+	logEditError(err)
+}
+
+// stop завершает цикл правок и ждет его окончания
+func (m *LiveMessage) stop() {
+	select {
+	case <-m.done:
+	default:
+		close(m.done)
+	}
+	<-m.stopped
+}
+
+// loop правит сообщение после каждого изменения текста, выдерживая паузу между правками
+func (m *LiveMessage) loop() {
+	defer close(m.stopped)
+
+	var shown string
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-m.changed:
+		}
+
+		m.mu.Lock()
+		text := m.text
+		m.mu.Unlock()
+
+		if text != "" && text != shown {
+			preview := truncate(text, telegramMessageLimit-len([]rune(liveCursor))-1) + liveCursor
+			_, err := m.bot.Send(tgbotapi.NewEditMessageText(m.chatID, m.messageID, preview))
+			logEditError(err)
+			shown = text
+		}
+
+		select {
+		case <-m.done:
+			return
+		case <-time.After(m.interval):
+		}
+	}
+}
+
+// splitMessage делит текст на части не длиннее limit символов, по возможности
+// по границам абзацев и строк
+func splitMessage(text string, limit int) []string {
+	var parts []string
+	runes := []rune(text)
+	for len(runes) > limit {
+		chunk := string(runes[:limit])
+		cut := strings.LastIndex(chunk, "\n\n")
+		if cut <= 0 {
+			cut = strings.LastIndex(chunk, "\n")
+		}
+		if cut <= 0 {
+			cut = len(chunk)
+		}
+		parts = append(parts, strings.TrimRight(chunk[:cut], "\n"))
+		runes = []rune(strings.TrimLeft(string(runes[len([]rune(chunk[:cut])):]), "\n"))
+	}
+	return append(parts, string(runes))
+}
+
+// isMarkdownError сообщает, что Telegram не смог разобрать разметку сообщения
+func isMarkdownError(err error) bool {
+	return strings.Contains(err.Error(), "can't parse entities")
+}
+
+// logEditError логирует ошибку правки, кроме ответа "сообщение не изменилось"
+func logEditError(err error) {
+	if err != nil && !strings.Contains(err.Error(), "message is not modified") {
+		log.Printf("Ошибка обновления сообщения: %v", err)
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Параметры Anthropic Messages API
+const (
+	anthropicVersion          = "2023-06-01"
+	anthropicDefaultMaxTokens = 2048 // max_tokens в Messages API обязателен
+)
+
+// anthropicRequest запрос к Anthropic Messages API
+type anthropicRequest struct {
+	Model       string             `json:"model"`
+	System      string             `json:"system,omitempty"`
+	Messages    []anthropicMessage `json:"messages"`
+	MaxTokens   int                `json:"max_tokens"`
+	Temperature float64            `json:"temperature"`
+	Stream      bool               `json:"stream,omitempty"`
+	Tools       []anthropicTool    `json:"tools,omitempty"`
+}
+
+// anthropicMessage сообщение Messages API
+type anthropicMessage struct {
+	Role    string                  `json:"role"`
+	Content []anthropicContentBlock `json:"content"`
+}
+
+// anthropicContentBlock блок содержимого сообщения: текст, вызов инструмента
+// или его результат
+type anthropicContentBlock struct {
+	Type      string          `json:"type"` // text, tool_use, tool_result
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+}
+
+// anthropicTool описание инструмента, который может вызвать модель
+type anthropicTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	InputSchema map[string]interface{} `json:"input_schema"`
+}
+
+// anthropicResponse ответ Anthropic Messages API
+type anthropicResponse struct {
+	Model      string                  `json:"model"`
+	Content    []anthropicContentBlock `json:"content"`
+	StopReason string                  `json:"stop_reason"`
+	Usage      struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+}
+
+// anthropicStreamEvent событие потоковой генерации Messages API
+type anthropicStreamEvent struct {
+	Type  string `json:"type"`
+	Index int    `json:"index"` // номер блока содержимого
+	// ContentBlock начало блока: текст или вызов инструмента
+	ContentBlock anthropicContentBlock `json:"content_block"`
+	Message      struct {
+		Model string `json:"model"`
+		Usage struct {
+			InputTokens int `json:"input_tokens"`
+		} `json:"usage"`
+	} `json:"message"`
+	Delta struct {
+		Type        string `json:"type"` // text_delta, input_json_delta
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"` // часть аргументов вызова инструмента
+	} `json:"delta"`
+	Usage struct {
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// anthropicProvider работает с Anthropic Messages API
+type anthropicProvider struct {
+	apiKey string
+	model  string
+	url    string
+	client *http.Client
+}
+
+// newAnthropicProvider создает провайдера Anthropic Messages API
+func newAnthropicProvider(cfg ProviderConfig, client *http.Client) *anthropicProvider {
+	return &anthropicProvider{
+		apiKey: cfg.APIKey,
+		model:  cfg.Model,
+		url:    cfg.BaseURL,
+		client: client,
+	}
+}
+
+// Name возвращает название провайдера и модели
+func (p *anthropicProvider) Name() string {
+	return ProviderAnthropic + "/" + p.model
+}
+
+// Complete генерирует ответ через Messages API. Системный промпт передается
+// отдельным полем, а не сообщением.
+func (p *anthropicProvider) Complete(ctx context.Context, req CompletionRequest) (*Completion, error) {
+	var resp anthropicResponse
+	err := postJSON(ctx, p.client, ProviderAnthropic, p.url, p.headers(), p.request(req), &resp, anthropicErrorMessage)
+	if err != nil {
+		return nil, err
+	}
+
+	completion := &Completion{
+		Provider:         ProviderAnthropic,
+		Model:            resp.Model,
+		PromptTokens:     resp.Usage.InputTokens,
+		CompletionTokens: resp.Usage.OutputTokens,
+	}
+	var text strings.Builder
+	for _, block := range resp.Content {
+		switch block.Type {
+		case "text":
+			text.WriteString(block.Text)
+		case "tool_use":
+			completion.ToolCalls = append(completion.ToolCalls, ToolCall{
+				ID:        block.ID,
+				Name:      block.Name,
+				Arguments: string(block.Input),
+			})
+		}
+	}
+	if text.Len() == 0 && len(completion.ToolCalls) == 0 {
+		return nil, fmt.Errorf("пустой ответ от API %s", ProviderAnthropic)
+	}
+
+	completion.Text = text.String()
+	return completion, nil
+}
+
+// Stream генерирует ответ потоком событий SSE. Вызов инструмента приходит
+// блоком tool_use, аргументы которого присылаются частями.
+func (p *anthropicProvider) Stream(ctx context.Context, req CompletionRequest, onText StreamFunc) (*Completion, error) {
+	streamReq := p.request(req)
+	streamReq.Stream = true
+
+	completion := &Completion{Provider: ProviderAnthropic, Model: p.model}
+	var text strings.Builder
+	toolBlocks := make(map[int]int) // номер блока -> номер вызова в completion.ToolCalls
+	err := postStream(ctx, p.client, ProviderAnthropic, p.url, p.headers(), streamReq, anthropicErrorMessage, func(line string) error {
+		data, ok := sseData(line)
+		if !ok {
+			return nil
+		}
+
+		var event anthropicStreamEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return fmt.Errorf("ошибка парсинга события потока: %w", err)
+		}
+
+		switch event.Type {
+		case "message_start":
+			completion.Model = event.Message.Model
+			completion.PromptTokens = event.Message.Usage.InputTokens
+		case "content_block_start":
+			if event.ContentBlock.Type == "tool_use" {
+				toolBlocks[event.Index] = len(completion.ToolCalls)
+				completion.ToolCalls = append(completion.ToolCalls, ToolCall{
+					ID:   event.ContentBlock.ID,
+					Name: event.ContentBlock.Name,
+				})
+			}
+		case "content_block_delta":
+			switch event.Delta.Type {
+			case "text_delta":
+				text.WriteString(event.Delta.Text)
+				onText(text.String())
+			case "input_json_delta":
+				if i, ok := toolBlocks[event.Index]; ok {
+					completion.ToolCalls[i].Arguments += event.Delta.PartialJSON
+				}
+			}
+		case "message_delta":
+			completion.CompletionTokens = event.Usage.OutputTokens
+		case "message_stop":
+			return errStreamDone
+		case "error":
+			// Перегрузка посреди потока приходит событием, а не кодом ответа
+			statusCode := 0
+			if event.Error.Type == "overloaded_error" {
+				statusCode = 529
+			}
+			return &ProviderError{Provider: ProviderAnthropic, StatusCode: statusCode, Message: event.Error.Type + ": " + event.Error.Message}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStreamDone) {
+		return nil, err
+	}
+	if text.Len() == 0 && len(completion.ToolCalls) == 0 {
+		return nil, fmt.Errorf("пустой ответ от API %s", ProviderAnthropic)
+	}
+	for i := range completion.ToolCalls {
+		// У инструмента без аргументов частей аргументов нет
+		if completion.ToolCalls[i].Arguments == "" {
+			completion.ToolCalls[i].Arguments = "{}"
+		}
+	}
+
+	completion.Text = text.String()
+	return completion, nil
+}
+
+// request переводит запрос в формат Messages API
+func (p *anthropicProvider) request(req CompletionRequest) anthropicRequest {
+	maxTokens := req.MaxTokens
+	if maxTokens == 0 {
+		maxTokens = anthropicDefaultMaxTokens
+	}
+
+	anthropicReq := anthropicRequest{
+		Model:       p.model,
+		System:      req.System,
+		Messages:    anthropicMessages(req.Messages),
+		MaxTokens:   maxTokens,
+		Temperature: req.Temperature,
+	}
+	for _, tool := range req.Tools {
+		anthropicReq.Tools = append(anthropicReq.Tools, anthropicTool{
+			Name:        tool.Name,
+			Description: tool.Description,
+			InputSchema: tool.Parameters,
+		})
+	}
+	return anthropicReq
+}
+
+// anthropicMessages переводит сообщения в блоки Messages API. Результаты
+// инструментов передаются блоками tool_result в сообщении пользователя, причем
+// результаты одного ответа модели должны быть в одном сообщении.
+func anthropicMessages(messages []AIMessage) []anthropicMessage {
+	result := make([]anthropicMessage, 0, len(messages))
+	for _, msg := range messages {
+		role := msg.Role
+		var blocks []anthropicContentBlock
+		switch {
+		case msg.Role == "tool":
+			role = "user"
+			blocks = append(blocks, anthropicContentBlock{Type: "tool_result", ToolUseID: msg.ToolCallID, Content: msg.Content})
+		default:
+			if msg.Content != "" {
+				blocks = append(blocks, anthropicContentBlock{Type: "text", Text: msg.Content})
+			}
+			for _, call := range msg.ToolCalls {
+				input := json.RawMessage(call.Arguments)
+				if !json.Valid(input) {
+					input = json.RawMessage("{}")
+				}
+				blocks = append(blocks, anthropicContentBlock{Type: "tool_use", ID: call.ID, Name: call.Name, Input: input})
+			}
+		}
+
+		if n := len(result); n > 0 && result[n-1].Role == role {
+			result[n-1].Content = append(result[n-1].Content, blocks...)
+			continue
+		}
+		result = append(result, anthropicMessage{Role: role, Content: blocks})
+	}
+	return result
+}
+
+// headers возвращает заголовки авторизации и версии API
+func (p *anthropicProvider) headers() map[string]string {
+	return map[string]string{
+		"x-api-key":         p.apiKey,
+		"anthropic-version": anthropicVersion,
+	}
+}
+
+// anthropicErrorMessage извлекает текст ошибки из ответа Messages API
+func anthropicErrorMessage(body []byte) string {
+	var resp struct {
+		Error struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &resp) != nil || resp.Error.Message == "" {
+		return ""
+	}
+	return resp.Error.Type + ": " + resp.Error.Message
+}
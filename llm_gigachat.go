@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Параметры авторизации GigaChat API
+const (
+	gigaChatOAuthURL     = "https://ngw.devices.sberbank.ru:9443/api/v2/oauth"
+	gigaChatDefaultScope = "GIGACHAT_API_PERS" // физические лица
+	gigaChatTokenMargin  = time.Minute         // токен обновляется заранее
+)
+
+// gigaChatTokenSource получает и кэширует токен доступа GigaChat (живет 30 минут)
+type gigaChatTokenSource struct {
+	authKey string // ключ авторизации из личного кабинета (base64 от client_id:client_secret)
+	scope   string
+	client  *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// newGigaChatProvider создает провайдера GigaChat. Формат запросов совпадает
+// с OpenAI, отличается только авторизация.
+func newGigaChatProvider(cfg ProviderConfig, client *http.Client) *openAIProvider {
+	tokens := &gigaChatTokenSource{
+		authKey: cfg.APIKey,
+		scope:   cfg.Scope,
+		client:  client,
+	}
+
+	return &openAIProvider{
+		name:   ProviderGigaChat,
+		model:  cfg.Model,
+		url:    cfg.BaseURL,
+		client: client,
+		authorization: func(ctx context.Context) (string, error) {
+			token, err := tokens.Token(ctx)
+			if err != nil {
+				return "", err
+			}
+			return "Bearer " + token, nil
+		},
+	}
+}
+
+// Token возвращает действующий токен доступа, при необходимости запрашивая новый
+func (s *gigaChatTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && time.Until(s.expires) > gigaChatTokenMargin {
+		return s.token, nil
+	}
+
+	form := url.Values{"scope": {s.scope}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, gigaChatOAuthURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("ошибка создания запроса токена GigaChat: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Basic "+s.authKey)
+	req.Header.Set("RqUID", newRequestID())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("ошибка запроса токена GigaChat: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("ошибка чтения токена GigaChat: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", &ProviderError{
+			Provider:   ProviderGigaChat,
+			StatusCode: resp.StatusCode,
+			Message:    "ошибка получения токена: " + truncate(strings.TrimSpace(string(body)), 200),
+		}
+	}
+
+	var tokenResp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresAt   int64  `json:"expires_at"` // миллисекунды Unix
+	}
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return "", fmt.Errorf("ошибка парсинга токена GigaChat: %w", err)
+	}
+	if tokenResp.AccessToken == "" {
+		return "", fmt.Errorf("GigaChat не вернул токен доступа")
+	}
+
+	s.token = tokenResp.AccessToken
+	s.expires = time.UnixMilli(tokenResp.ExpiresAt)
+	return s.token, nil
+}
+
+// newRequestID генерирует UUID v4 для заголовка RqUID
+func newRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return fmt.Sprintf("00000000-0000-4000-8000-%012x", time.Now().UnixNano()&0xffffffffffff)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
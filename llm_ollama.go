@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ollamaRequest запрос к методу /api/chat сервера Ollama
+type ollamaRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Format   string          `json:"format,omitempty"`
+	Tools    []openAITool    `json:"tools,omitempty"`
+	Options  struct {
+		Temperature float64 `json:"temperature"`
+		NumPredict  int     `json:"num_predict,omitempty"`
+	} `json:"options"`
+}
+
+// ollamaMessage сообщение /api/chat
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+}
+
+// ollamaToolCall вызов функции: в отличие от OpenAI аргументы передаются
+// объектом JSON, а у вызова нет идентификатора
+type ollamaToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+// ollamaResponse ответ метода /api/chat без потоковой передачи
+type ollamaResponse struct {
+	Model           string        `json:"model"`
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+	Error           string        `json:"error"`
+}
+
+// ollamaProvider работает с локальным сервером Ollama. Для llama.cpp server
+// используется провайдер openai с base_url вида http://localhost:8080/v1/chat/completions.
+type ollamaProvider struct {
+	apiKey string
+	model  string
+	url    string
+	client *http.Client
+}
+
+// newOllamaProvider создает провайдера Ollama
+func newOllamaProvider(cfg ProviderConfig, client *http.Client) *ollamaProvider {
+	return &ollamaProvider{
+		apiKey: cfg.APIKey,
+		model:  cfg.Model,
+		url:    cfg.BaseURL,
+		client: client,
+	}
+}
+
+// Name возвращает название провайдера и модели
+func (p *ollamaProvider) Name() string {
+	return ProviderOllama + "/" + p.model
+}
+
+// Complete генерирует ответ через /api/chat
+func (p *ollamaProvider) Complete(ctx context.Context, req CompletionRequest) (*Completion, error) {
+	var resp ollamaResponse
+	err := postJSON(ctx, p.client, ProviderOllama, p.url, p.headers(), p.request(req), &resp, ollamaErrorMessage)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Error != "" {
+		return nil, &ProviderError{Provider: ProviderOllama, Message: resp.Error}
+	}
+	if resp.Message.Content == "" && len(resp.Message.ToolCalls) == 0 {
+		return nil, fmt.Errorf("пустой ответ от API %s", ProviderOllama)
+	}
+
+	completion := &Completion{
+		Text:             resp.Message.Content,
+		Provider:         ProviderOllama,
+		Model:            resp.Model,
+		PromptTokens:     resp.PromptEvalCount,
+		CompletionTokens: resp.EvalCount,
+	}
+	for i, call := range resp.Message.ToolCalls {
+		completion.ToolCalls = append(completion.ToolCalls, ToolCall{
+			ID:        fmt.Sprintf("call_%d", i+1),
+			Name:      call.Function.Name,
+			Arguments: string(call.Function.Arguments),
+		})
+	}
+	return completion, nil
+}
+
+// Stream генерирует ответ потоком: Ollama присылает по объекту JSON на строку.
+// Вызовы функций приходят целиком в одном из объектов.
+func (p *ollamaProvider) Stream(ctx context.Context, req CompletionRequest, onText StreamFunc) (*Completion, error) {
+	streamReq := p.request(req)
+	streamReq.Stream = true
+
+	completion := &Completion{Provider: ProviderOllama, Model: p.model}
+	var text strings.Builder
+	err := postStream(ctx, p.client, ProviderOllama, p.url, p.headers(), streamReq, ollamaErrorMessage, func(line string) error {
+		var chunk ollamaResponse
+		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+			return fmt.Errorf("ошибка парсинга события потока: %w", err)
+		}
+		if chunk.Error != "" {
+			return &ProviderError{Provider: ProviderOllama, Message: chunk.Error}
+		}
+		for _, call := range chunk.Message.ToolCalls {
+			completion.ToolCalls = append(completion.ToolCalls, ToolCall{
+				ID:        fmt.Sprintf("call_%d", len(completion.ToolCalls)+1),
+				Name:      call.Function.Name,
+				Arguments: string(call.Function.Arguments),
+			})
+		}
+		if chunk.Message.Content != "" {
+			text.WriteString(chunk.Message.Content)
+			onText(text.String())
+		}
+		if chunk.Done {
+			completion.Model = chunk.Model
+			completion.PromptTokens = chunk.PromptEvalCount
+			completion.CompletionTokens = chunk.EvalCount
+			return errStreamDone
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStreamDone) {
+		return nil, err
+	}
+	if text.Len() == 0 && len(completion.ToolCalls) == 0 {
+		return nil, fmt.Errorf("пустой ответ от API %s", ProviderOllama)
+	}
+
+	completion.Text = text.String()
+	return completion, nil
+}
+
+// request переводит запрос в формат /api/chat
+func (p *ollamaProvider) request(req CompletionRequest) ollamaRequest {
+	ollamaReq := ollamaRequest{Model: p.model, Tools: openAITools(req.Tools)}
+	if req.System != "" {
+		ollamaReq.Messages = append(ollamaReq.Messages, ollamaMessage{Role: "system", Content: req.System})
+	}
+	for _, msg := range req.Messages {
+		ollamaMsg := ollamaMessage{Role: msg.Role, Content: msg.Content}
+		for _, call := range msg.ToolCalls {
+			var toolCall ollamaToolCall
+			toolCall.Function.Name = call.Name
+			toolCall.Function.Arguments = json.RawMessage(call.Arguments)
+			if !json.Valid(toolCall.Function.Arguments) {
+				toolCall.Function.Arguments = json.RawMessage("{}")
+			}
+			ollamaMsg.ToolCalls = append(ollamaMsg.ToolCalls, toolCall)
+		}
+		ollamaReq.Messages = append(ollamaReq.Messages, ollamaMsg)
+	}
+	ollamaReq.Options.Temperature = req.Temperature
+	ollamaReq.Options.NumPredict = req.MaxTokens
+	if req.JSON {
+		ollamaReq.Format = "json"
+	}
+	return ollamaReq
+}
+
+// headers возвращает заголовок авторизации: Ollama за обратным прокси может требовать токен
+func (p *ollamaProvider) headers() map[string]string {
+	headers := map[string]string{}
+	if p.apiKey != "" {
+		headers["Authorization"] = "Bearer " + p.apiKey
+	}
+	return headers
+}
+
+// ollamaErrorMessage извлекает текст ошибки из ответа Ollama
+func ollamaErrorMessage(body []byte) string {
+	var resp struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &resp) != nil {
+		return ""
+	}
+	return resp.Error
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// openAIRequest запрос к OpenAI Chat Completions API
+type openAIRequest struct {
+	Model          string                `json:"model"`
+	Messages       []openAIMessage       `json:"messages"`
+	Temperature    float64               `json:"temperature"`
+	MaxTokens      int                   `json:"max_tokens,omitempty"`
+	Stream         bool                  `json:"stream,omitempty"`
+	StreamOptions  *openAIStreamOptions  `json:"stream_options,omitempty"`
+	ResponseFormat *openAIResponseFormat `json:"response_format,omitempty"`
+	Tools          []openAITool          `json:"tools,omitempty"`
+}
+
+// openAIMessage сообщение Chat Completions API
+type openAIMessage struct {
+	Role       string           `json:"role"`
+	Content    string           `json:"content"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+// openAIToolCall вызов функции, который запросила модель
+type openAIToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"` // function
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"` // объект JSON строкой
+	} `json:"function"`
+}
+
+// openAITool описание функции, которую может вызвать модель
+type openAITool struct {
+	Type     string `json:"type"` // function
+	Function struct {
+		Name        string                 `json:"name"`
+		Description string                 `json:"description"`
+		Parameters  map[string]interface{} `json:"parameters"`
+	} `json:"function"`
+}
+
+// openAIStreamOptions просит прислать расход токенов последним событием потока
+type openAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// openAIResponseFormat требуемый формат ответа
+type openAIResponseFormat struct {
+	Type string `json:"type"`
+}
+
+// openAIResponse ответ OpenAI Chat Completions API
+type openAIResponse struct {
+	ID      string `json:"id"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Index        int           `json:"index"`
+		Message      openAIMessage `json:"message"`
+		FinishReason string        `json:"finish_reason"`
+	} `json:"choices"`
+	Usage struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+		TotalTokens      int `json:"total_tokens"`
+	} `json:"usage"`
+	Error struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+	} `json:"error"`
+}
+
+// openAIStreamChunk событие потоковой генерации Chat Completions API
+type openAIStreamChunk struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Delta struct {
+			Content   string                `json:"content"`
+			ToolCalls []openAIToolCallDelta `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// openAIToolCallDelta часть вызова функции в потоке: первое событие вызова
+// содержит идентификатор и название, следующие - продолжение аргументов
+type openAIToolCallDelta struct {
+	Index    int    `json:"index"`
+	ID       string `json:"id"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// openAIProvider работает с OpenAI и любыми совместимыми API: llama.cpp server,
+// vLLM, OpenRouter и другими. GigaChat использует тот же формат с другой авторизацией.
+type openAIProvider struct {
+	name   string
+	model  string
+	url    string
+	client *http.Client
+	// authorization возвращает значение заголовка Authorization (пустое - без заголовка)
+	authorization func(ctx context.Context) (string, error)
+}
+
+// newOpenAIProvider создает провайдера OpenAI-совместимого API
+func newOpenAIProvider(cfg ProviderConfig, client *http.Client) *openAIProvider {
+	return &openAIProvider{
+		name:   ProviderOpenAI,
+		model:  cfg.Model,
+		url:    cfg.BaseURL,
+		client: client,
+		authorization: func(context.Context) (string, error) {
+			if cfg.APIKey == "" {
+				return "", nil
+			}
+			return "Bearer " + cfg.APIKey, nil
+		},
+	}
+}
+
+// Name возвращает название провайдера и модели
+func (p *openAIProvider) Name() string {
+	return p.name + "/" + p.model
+}
+
+// Complete генерирует ответ через Chat Completions API
+func (p *openAIProvider) Complete(ctx context.Context, req CompletionRequest) (*Completion, error) {
+	headers, err := p.headers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp openAIResponse
+	err = postJSON(ctx, p.client, p.name, p.url, headers, p.request(req), &resp, openAIErrorMessage)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Error.Message != "" {
+		return nil, &ProviderError{Provider: p.name, Message: resp.Error.Message}
+	}
+	if len(resp.Choices) == 0 {
+		return nil, fmt.Errorf("пустой ответ от API %s", p.name)
+	}
+
+	model := resp.Model
+	if model == "" {
+		model = p.model
+	}
+	completion := &Completion{
+		Text:             resp.Choices[0].Message.Content,
+		Provider:         p.name,
+		Model:            model,
+		PromptTokens:     resp.Usage.PromptTokens,
+		CompletionTokens: resp.Usage.CompletionTokens,
+	}
+	for _, call := range resp.Choices[0].Message.ToolCalls {
+		completion.ToolCalls = append(completion.ToolCalls, ToolCall{
+			ID:        call.ID,
+			Name:      call.Function.Name,
+			Arguments: call.Function.Arguments,
+		})
+	}
+	return completion, nil
+}
+
+// Stream генерирует ответ потоком событий SSE. Вызовы функций собираются
+// из частей по индексу вызова.
+func (p *openAIProvider) Stream(ctx context.Context, req CompletionRequest, onText StreamFunc) (*Completion, error) {
+	headers, err := p.headers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	streamReq := p.request(req)
+	streamReq.Stream = true
+	if p.name == ProviderOpenAI {
+		streamReq.StreamOptions = &openAIStreamOptions{IncludeUsage: true}
+	}
+
+	completion := &Completion{Provider: p.name, Model: p.model}
+	var text strings.Builder
+	err = postStream(ctx, p.client, p.name, p.url, headers, streamReq, openAIErrorMessage, func(line string) error {
+		data, ok := sseData(line)
+		if !ok {
+			return nil
+		}
+		if data == "[DONE]" {
+			return errStreamDone
+		}
+
+		var chunk openAIStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("ошибка парсинга события потока: %w", err)
+		}
+		if chunk.Error.Message != "" {
+			return &ProviderError{Provider: p.name, Message: chunk.Error.Message}
+		}
+		if chunk.Model != "" {
+			completion.Model = chunk.Model
+		}
+		if chunk.Usage != nil {
+			completion.PromptTokens = chunk.Usage.PromptTokens
+			completion.CompletionTokens = chunk.Usage.CompletionTokens
+		}
+		if len(chunk.Choices) == 0 {
+			return nil
+		}
+		delta := chunk.Choices[0].Delta
+		for _, part := range delta.ToolCalls {
+			if part.Index < 0 {
+				continue
+			}
+			for len(completion.ToolCalls) <= part.Index {
+				completion.ToolCalls = append(completion.ToolCalls, ToolCall{})
+			}
+			call := &completion.ToolCalls[part.Index]
+			if part.ID != "" {
+				call.ID = part.ID
+			}
+			if part.Function.Name != "" {
+				call.Name = part.Function.Name
+			}
+			call.Arguments += part.Function.Arguments
+		}
+		if delta.Content != "" {
+			text.WriteString(delta.Content)
+			onText(text.String())
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStreamDone) {
+		return nil, err
+	}
+	if text.Len() == 0 && len(completion.ToolCalls) == 0 {
+		return nil, fmt.Errorf("пустой ответ от API %s", p.name)
+	}
+
+	completion.Text = text.String()
+	return completion, nil
+}
+
+// request переводит запрос в формат Chat Completions API
+func (p *openAIProvider) request(req CompletionRequest) openAIRequest {
+	// GigaChat описывает функции в собственном формате, поэтому вызовы
+	// инструментов ему передаются текстом, а сами инструменты - не передаются
+	native := p.name == ProviderOpenAI
+	history := req.Messages
+	if !native {
+		history = plainMessages(history)
+	}
+
+	messages := make([]openAIMessage, 0, len(history)+1)
+	if req.System != "" {
+		messages = append(messages, openAIMessage{Role: "system", Content: req.System})
+	}
+	for _, msg := range history {
+		openAIMsg := openAIMessage{Role: msg.Role, Content: msg.Content, ToolCallID: msg.ToolCallID}
+		for _, call := range msg.ToolCalls {
+			toolCall := openAIToolCall{ID: call.ID, Type: "function"}
+			toolCall.Function.Name = call.Name
+			toolCall.Function.Arguments = call.Arguments
+			openAIMsg.ToolCalls = append(openAIMsg.ToolCalls, toolCall)
+		}
+		messages = append(messages, openAIMsg)
+	}
+
+	openAIReq := openAIRequest{
+		Model:       p.model,
+		Messages:    messages,
+		Temperature: req.Temperature,
+		MaxTokens:   req.MaxTokens,
+	}
+	// GigaChat не поддерживает response_format, там формат задается только промптом
+	if req.JSON && native {
+		openAIReq.ResponseFormat = &openAIResponseFormat{Type: "json_object"}
+	}
+	if native {
+		openAIReq.Tools = openAITools(req.Tools)
+	}
+	return openAIReq
+}
+
+// openAITools переводит инструменты в формат функций Chat Completions API
+// (его же понимает Ollama)
+func openAITools(tools []ToolDefinition) []openAITool {
+	var result []openAITool
+	for _, tool := range tools {
+		openAITool := openAITool{Type: "function"}
+		openAITool.Function.Name = tool.Name
+		openAITool.Function.Description = tool.Description
+		openAITool.Function.Parameters = tool.Parameters
+		result = append(result, openAITool)
+	}
+	return result
+}
+
+// headers возвращает заголовки авторизации запроса
+func (p *openAIProvider) headers(ctx context.Context) (map[string]string, error) {
+	headers := map[string]string{}
+	authorization, err := p.authorization(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if authorization != "" {
+		headers["Authorization"] = authorization
+	}
+	return headers, nil
+}
+
+// openAIErrorMessage извлекает текст ошибки из ответа OpenAI-совместимого API
+func openAIErrorMessage(body []byte) string {
+	var resp struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+		Message string `json:"message"` // GigaChat
+	}
+	if json.Unmarshal(body, &resp) != nil {
+		return ""
+	}
+	if resp.Error.Message != "" {
+		return resp.Error.Message
+	}
+	return resp.Message
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Поддерживаемые провайдеры AI
+const (
+	ProviderOpenAI    = "openai"    // OpenAI и совместимые API (llama.cpp, vLLM, OpenRouter)
+	ProviderAnthropic = "anthropic" // Anthropic Messages API
+	ProviderOllama    = "ollama"    // локальный сервер Ollama
+	ProviderGigaChat  = "gigachat"  // GigaChat от Сбера
+	ProviderYandexGPT = "yandexgpt" // YandexGPT в Yandex Cloud
+)
+
+// providerDefaultURLs адреса методов генерации по умолчанию
+var providerDefaultURLs = map[string]string{
+	ProviderOpenAI:    "https://api.openai.com/v1/chat/completions",
+	ProviderAnthropic: "https://api.anthropic.com/v1/messages",
+	ProviderOllama:    "http://localhost:11434/api/chat",
+	ProviderGigaChat:  "https://gigachat.devices.sberbank.ru/api/v1/chat/completions",
+	ProviderYandexGPT: "https://llm.api.cloud.yandex.net/foundationModels/v1/completion",
+}
+
+// providerDefaultModels модели по умолчанию
+var providerDefaultModels = map[string]string{
+	ProviderOpenAI:    "gpt-4o",
+	ProviderAnthropic: "claude-3-5-sonnet-latest",
+	ProviderOllama:    "llama3.1",
+	ProviderGigaChat:  "GigaChat",
+	ProviderYandexGPT: "yandexgpt/latest",
+}
+
+// ProviderNames возвращает названия поддерживаемых провайдеров
+func ProviderNames() []string {
+	names := make([]string, 0, len(providerDefaultURLs))
+	for name := range providerDefaultURLs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AIMessage сообщение диалога с моделью
+type AIMessage struct {
+	Role    string `json:"role"` // user, assistant или tool
+	Content string `json:"content"`
+	// ToolCalls инструменты, которые запросила модель (в сообщении assistant).
+	// Провайдеры переводят вызовы и их результаты в формат своего API.
+	ToolCalls []ToolCall `json:"-"`
+	// ToolCallID вызов, результат которого передает сообщение tool
+	ToolCallID string `json:"-"`
+}
+
+// ToolDefinition инструмент, который модель может вызвать во время генерации
+type ToolDefinition struct {
+	Name        string
+	Description string
+	Parameters  map[string]interface{} // JSON Schema аргументов
+}
+
+// ToolCall вызов инструмента, который запросила модель
+type ToolCall struct {
+	ID        string
+	Name      string
+	Arguments string // аргументы - объект JSON
+}
+
+// CompletionRequest запрос на генерацию ответа модели
+type CompletionRequest struct {
+	System      string // системный промпт
+	Messages    []AIMessage
+	Temperature float64
+	MaxTokens   int
+	JSON        bool // ответ должен быть объектом JSON (если провайдер умеет это гарантировать)
+	// Tools инструменты, которые модель может вызвать вместо ответа. Провайдеры
+	// без поддержки инструментов их не передают, и модель сразу отвечает текстом.
+	Tools []ToolDefinition
+}
+
+// Completion ответ модели
+type Completion struct {
+	Text             string
+	Provider         string
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+	ToolCalls        []ToolCall // модель просит вызвать инструменты и прислать результаты
+}
+
+// LLMProvider генерирует ответ языковой модели. Реализации переводят запрос
+// в формат API конкретного провайдера.
+type LLMProvider interface {
+	// Name возвращает название провайдера и модели для логов
+	Name() string
+	// Complete генерирует ответ на диалог
+	Complete(ctx context.Context, req CompletionRequest) (*Completion, error)
+}
+
+// StreamFunc получает текст ответа целиком на текущий момент по мере генерации.
+// При повторе запроса или переключении на резервного провайдера текст начинается заново.
+type StreamFunc func(text string)
+
+// StreamingProvider провайдер, умеющий отдавать ответ по частям
+type StreamingProvider interface {
+	LLMProvider
+	// Stream генерирует ответ, вызывая onText по мере получения текста
+	Stream(ctx context.Context, req CompletionRequest, onText StreamFunc) (*Completion, error)
+}
+
+// streamCompletion генерирует ответ потоком, если провайдер это поддерживает,
+// иначе получает ответ целиком и передает его в onText одним вызовом. Вызовы
+// инструментов провайдеры собирают из потока и возвращают вместе с ответом.
+func streamCompletion(ctx context.Context, provider LLMProvider, req CompletionRequest, onText StreamFunc) (*Completion, error) {
+	if streaming, ok := provider.(StreamingProvider); ok && onText != nil {
+		return streaming.Stream(ctx, req, onText)
+	}
+
+	completion, err := provider.Complete(ctx, req)
+	if err == nil && onText != nil && completion.Text != "" {
+		onText(completion.Text)
+	}
+	return completion, err
+}
+
+// plainMessages переводит вызовы инструментов и их результаты в обычный текст
+// для провайдеров без поддержки инструментов и объединяет соседние сообщения
+// одной роли
+func plainMessages(messages []AIMessage) []AIMessage {
+	plain := make([]AIMessage, 0, len(messages))
+	for _, msg := range messages {
+		text := msg.Content
+		role := msg.Role
+		switch {
+		case msg.Role == "tool":
+			role = "user"
+			text = "Результат инструмента:\n" + msg.Content
+		case len(msg.ToolCalls) > 0:
+			var sb strings.Builder
+			sb.WriteString(msg.Content)
+			for _, call := range msg.ToolCalls {
+				if sb.Len() > 0 {
+					sb.WriteString("\n")
+				}
+				sb.WriteString(fmt.Sprintf("Вызов инструмента %s(%s)", call.Name, call.Arguments))
+			}
+			text = sb.String()
+		}
+
+		if n := len(plain); n > 0 && plain[n-1].Role == role {
+			plain[n-1].Content += "\n\n" + text
+			continue
+		}
+		plain = append(plain, AIMessage{Role: role, Content: text})
+	}
+	return plain
+}
+
+// ProviderError ошибка, которую вернул API провайдера
+type ProviderError struct {
+	Provider   string
+	StatusCode int
+	Message    string
+	RetryAfter time.Duration // из заголовка Retry-After, если он есть
+}
+
+// Error возвращает текст ошибки с кодом HTTP ответа
+func (e *ProviderError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("ошибка API %s: %s", e.Provider, e.Message)
+	}
+	return fmt.Sprintf("ошибка API %s (HTTP %d): %s", e.Provider, e.StatusCode, e.Message)
+}
+
+// NewLLMProvider создает провайдера по настройкам
+func NewLLMProvider(cfg ProviderConfig) (LLMProvider, error) {
+	// Время запроса ограничивает контекст попытки (AttemptTimeout), а не клиент
+	client := &http.Client{}
+
+	switch cfg.Provider {
+	case ProviderOpenAI:
+		return newOpenAIProvider(cfg, client), nil
+	case ProviderAnthropic:
+		return newAnthropicProvider(cfg, client), nil
+	case ProviderOllama:
+		return newOllamaProvider(cfg, client), nil
+	case ProviderGigaChat:
+		return newGigaChatProvider(cfg, client), nil
+	case ProviderYandexGPT:
+		return newYandexGPTProvider(cfg, client), nil
+	default:
+		return nil, fmt.Errorf("неизвестный провайдер AI %q", cfg.Provider)
+	}
+}
+
+// NewLLMProviderChain создает основного провайдера и цепочку резервных из настроек.
+// Каждый провайдер получает собственные повторы и автомат отключения.
+func NewLLMProviderChain(cfg AIConfig, usage *UsageTracker) (LLMProvider, error) {
+	resilience := ResilienceConfig{
+		AttemptTimeout:   cfg.AttemptTimeout,
+		MaxRetries:       cfg.MaxRetries,
+		BreakerThreshold: cfg.BreakerThreshold,
+		BreakerCooldown:  cfg.BreakerCooldown,
+	}
+
+	var providers []LLMProvider
+	for _, providerCfg := range append([]ProviderConfig{cfg.ProviderConfig}, cfg.Fallbacks...) {
+		provider, err := NewLLMProvider(providerCfg)
+		if err != nil {
+			return nil, err
+		}
+		// Учет расходов внутри повторов: записывается каждая попытка
+		providers = append(providers, NewResilientProvider(NewMeteredProvider(provider, usage), resilience))
+	}
+
+	if len(providers) == 1 {
+		return providers[0], nil
+	}
+	return NewFailoverProvider(providers...), nil
+}
+
+// FailoverProvider обращается к провайдерам по очереди, пока один из них не ответит
+type FailoverProvider struct {
+	providers []LLMProvider
+}
+
+// NewFailoverProvider создает цепочку провайдеров в порядке приоритета
+func NewFailoverProvider(providers ...LLMProvider) *FailoverProvider {
+	return &FailoverProvider{providers: providers}
+}
+
+// Name возвращает названия провайдеров цепочки
+func (f *FailoverProvider) Name() string {
+	names := make([]string, len(f.providers))
+	for i, p := range f.providers {
+		names[i] = p.Name()
+	}
+	return strings.Join(names, " → ")
+}
+
+// Complete возвращает ответ первого провайдера, который ответил без ошибки
+func (f *FailoverProvider) Complete(ctx context.Context, req CompletionRequest) (*Completion, error) {
+	return f.Stream(ctx, req, nil)
+}
+
+// Stream генерирует ответ потоком у первого провайдера, который ответил без ошибки
+func (f *FailoverProvider) Stream(ctx context.Context, req CompletionRequest, onText StreamFunc) (*Completion, error) {
+	var errs []error
+	for i, provider := range f.providers {
+		completion, err := streamCompletion(ctx, provider, req, onText)
+		if err == nil {
+			if i > 0 {
+				log.Printf("Ответ получен от резервного провайдера %s", provider.Name())
+			}
+			return completion, nil
+		}
+
+		errs = append(errs, fmt.Errorf("%s: %w", provider.Name(), err))
+		if ctx.Err() != nil {
+			break
+		}
+		if i < len(f.providers)-1 {
+			log.Printf("Провайдер %s вернул ошибку, переключаемся на %s: %v", provider.Name(), f.providers[i+1].Name(), err)
+		}
+	}
+
+	return nil, fmt.Errorf("ни один провайдер AI не ответил: %w", errors.Join(errs...))
+}
+
+// postJSON отправляет JSON запрос и разбирает JSON ответ в out. Ответ с кодом
+// ошибки возвращается как *ProviderError с текстом, который извлекает errMessage.
+func postJSON(ctx context.Context, client *http.Client, provider, url string, headers map[string]string, in, out interface{}, errMessage func(body []byte) string) error {
+	reqBytes, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("ошибка маршалинга JSON: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(reqBytes))
+	if err != nil {
+		return fmt.Errorf("ошибка создания HTTP запроса: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		httpReq.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("ошибка отправки запроса: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения ответа: %w", err)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return newProviderError(provider, resp, body, errMessage)
+	}
+
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("ошибка парсинга ответа: %w", err)
+	}
+	return nil
+}
+
+// newProviderError создает ошибку по ответу с кодом ошибки. Текст берется
+// из тела ответа с помощью errMessage, иначе - тело или описание кода целиком.
+func newProviderError(provider string, resp *http.Response, body []byte, errMessage func(body []byte) string) *ProviderError {
+	message := errMessage(body)
+	if message == "" {
+		message = truncate(strings.TrimSpace(string(body)), 200)
+	}
+	if message == "" {
+		message = http.StatusText(resp.StatusCode)
+	}
+
+	return &ProviderError{
+		Provider:   provider,
+		StatusCode: resp.StatusCode,
+		Message:    message,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+// postStream отправляет JSON запрос на потоковую генерацию и передает в onLine
+// каждую непустую строку ответа (события SSE или строки NDJSON). Ответ с кодом
+// ошибки возвращается как *ProviderError, как в postJSON.
+func postStream(ctx context.Context, client *http.Client, provider, url string, headers map[string]string, in interface{}, errMessage func(body []byte) string, onLine func(line string) error) error {
+	reqBytes, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("ошибка маршалинга JSON: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(reqBytes))
+	if err != nil {
+		return fmt.Errorf("ошибка создания HTTP запроса: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "text/event-stream")
+	for key, value := range headers {
+		httpReq.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("ошибка отправки запроса: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		body, _ := io.ReadAll(resp.Body)
+		return newProviderError(provider, resp, body, errMessage)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if err := onLine(line); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("ошибка чтения потока: %w", err)
+	}
+	return nil
+}
+
+// errStreamDone останавливает чтение потока после завершающего события
+var errStreamDone = errors.New("поток завершен")
+
+// sseData возвращает данные строки события SSE "data: ..."
+func sseData(line string) (string, bool) {
+	if !strings.HasPrefix(line, "data:") {
+		return "", false
+	}
+	return strings.TrimSpace(strings.TrimPrefix(line, "data:")), true
+}
+
+// truncate обрезает строку до n символов
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n]) + "…"
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Паузы между повторными запросами к модели
+const (
+	llmBaseBackoff = 2 * time.Second
+	llmMaxBackoff  = 30 * time.Second
+)
+
+// ErrCircuitOpen провайдер временно отключен после серии ошибок
+var ErrCircuitOpen = errors.New("провайдер AI временно отключен после серии ошибок")
+
+// retryableStatusCodes коды ответа, при которых запрос имеет смысл повторить
+var retryableStatusCodes = map[int]bool{
+	http.StatusRequestTimeout:      true,
+	http.StatusTooEarly:            true,
+	http.StatusTooManyRequests:     true,
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+	529:                            true, // Anthropic: перегрузка
+}
+
+// ResilienceConfig настройки устойчивости запросов к провайдеру
+type ResilienceConfig struct {
+	AttemptTimeout   time.Duration // максимальное время одной попытки
+	MaxRetries       int           // повторов после неудачной попытки
+	BreakerThreshold int           // ошибок подряд, после которых провайдер отключается
+	BreakerCooldown  time.Duration // на сколько отключается провайдер
+}
+
+// circuitState состояние автомата отключения провайдера
+type circuitState int
+
+const (
+	circuitClosed   circuitState = iota // провайдер работает
+	circuitOpen                         // провайдер отключен, запросы сразу завершаются ошибкой
+	circuitHalfOpen                     // пробный запрос после паузы
+)
+
+// ResilientProvider добавляет к провайдеру таймаут каждой попытки, повторы
+// с паузой при временных ошибках (с учетом Retry-After) и автомат отключения:
+// после BreakerThreshold неудачных запросов подряд провайдер на BreakerCooldown
+// перестает вызываться, и цепочка сразу переходит к резервному провайдеру.
+// Неудачным считается запрос, завершенный временной ошибкой (5xx, 429, таймаут,
+// сетевая ошибка); ошибки самого запроса, например 400, провайдера не отключают.
+type ResilientProvider struct {
+	provider LLMProvider
+	config   ResilienceConfig
+
+	mu       sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+}
+
+// NewResilientProvider оборачивает провайдера
+func NewResilientProvider(provider LLMProvider, config ResilienceConfig) *ResilientProvider {
+	return &ResilientProvider{
+		provider: provider,
+		config:   config,
+	}
+}
+
+// Name возвращает название провайдера
+func (p *ResilientProvider) Name() string {
+	return p.provider.Name()
+}
+
+// Complete вызывает провайдера с повторами. Ошибки отмены ctx не считаются
+// отказом провайдера.
+func (p *ResilientProvider) Complete(ctx context.Context, req CompletionRequest) (*Completion, error) {
+	return p.Stream(ctx, req, nil)
+}
+
+// Stream генерирует ответ потоком с повторами. При повторе текст в onText
+// начинается заново.
+func (p *ResilientProvider) Stream(ctx context.Context, req CompletionRequest, onText StreamFunc) (*Completion, error) {
+	if err := p.allow(); err != nil {
+		return nil, err
+	}
+
+	backoff := llmBaseBackoff
+	for attempt := 0; ; attempt++ {
+		completion, err := p.attempt(ctx, req, onText)
+		if err == nil {
+			p.record(nil)
+			return completion, nil
+		}
+		if ctx.Err() != nil {
+			p.release()
+			return nil, err
+		}
+
+		delay, retryable := retryDelay(err, backoff)
+		if !retryable {
+			// Провайдер ответил, ошибка в самом запросе - это не отказ провайдера
+			p.record(nil)
+			return nil, err
+		}
+		if attempt >= p.config.MaxRetries {
+			p.record(err)
+			return nil, err
+		}
+
+		// Если провайдер просит ждать дольше, чем осталось до дедлайна, ждать бессмысленно
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			p.record(err)
+			return nil, fmt.Errorf("%w (повтор через %s не укладывается в срок)", err, delay.Round(time.Second))
+		}
+
+		log.Printf("Провайдер %s: временная ошибка (попытка %d из %d), повтор через %s: %v",
+			p.Name(), attempt+1, p.config.MaxRetries+1, delay.Round(time.Millisecond), err)
+		if err := sleepContext(ctx, delay); err != nil {
+			p.release()
+			return nil, err
+		}
+
+		backoff *= 2
+		if backoff > llmMaxBackoff {
+			backoff = llmMaxBackoff
+		}
+	}
+}
+
+// attempt выполняет одну попытку с собственным таймаутом
+func (p *ResilientProvider) attempt(ctx context.Context, req CompletionRequest, onText StreamFunc) (*Completion, error) {
+	attemptCtx, cancel := context.WithTimeout(ctx, p.config.AttemptTimeout)
+	defer cancel()
+
+	completion, err := streamCompletion(attemptCtx, p.provider, req, onText)
+	if err != nil && ctx.Err() == nil && attemptCtx.Err() != nil {
+		return nil, fmt.Errorf("провайдер не ответил за %s: %w", p.config.AttemptTimeout, err)
+	}
+	return completion, err
+}
+
+// allow проверяет, можно ли сейчас обращаться к провайдеру
+func (p *ResilientProvider) allow() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch p.state {
+	case circuitOpen:
+		if time.Since(p.openedAt) < p.config.BreakerCooldown {
+			return fmt.Errorf("%s: %w", p.Name(), ErrCircuitOpen)
+		}
+		// Пауза прошла - пропускаем один пробный запрос
+		p.state = circuitHalfOpen
+		return nil
+	case circuitHalfOpen:
+		return fmt.Errorf("%s: %w", p.Name(), ErrCircuitOpen)
+	default:
+		return nil
+	}
+}
+
+// record учитывает результат запроса в автомате отключения
+func (p *ResilientProvider) record(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err == nil {
+		if p.state != circuitClosed {
+			log.Printf("Провайдер %s снова доступен", p.Name())
+		}
+		p.state = circuitClosed
+		p.failures = 0
+		return
+	}
+
+	p.failures++
+	if p.state == circuitHalfOpen || p.failures >= p.config.BreakerThreshold {
+		if p.state != circuitOpen {
+			log.Printf("Провайдер %s отключен на %s после %d ошибок подряд: %v",
+				p.Name(), p.config.BreakerCooldown, p.failures, err)
+		}
+		p.state = circuitOpen
+		p.openedAt = time.Now()
+	}
+}
+
+// release освобождает пробный запрос, завершенный отменой ctx. Отмена не говорит
+// о состоянии провайдера, поэтому ошибка не учитывается, но провайдер остается
+// отключенным на новую паузу - иначе автомат навсегда застрянет в circuitHalfOpen.
+func (p *ResilientProvider) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.state == circuitHalfOpen {
+		p.state = circuitOpen
+		p.openedAt = time.Now()
+	}
+}
+
+// retryDelay определяет, стоит ли повторять запрос после ошибки, и через сколько.
+// Пауза берется из Retry-After, если провайдер его прислал, иначе - backoff со случайным разбросом.
+func retryDelay(err error, backoff time.Duration) (time.Duration, bool) {
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) && providerErr.StatusCode != 0 {
+		if !retryableStatusCodes[providerErr.StatusCode] {
+			return 0, false
+		}
+		if providerErr.RetryAfter > 0 {
+			return providerErr.RetryAfter, true
+		}
+		return jitter(backoff), true
+	}
+	if errors.As(err, &providerErr) {
+		// Ошибка в теле успешного ответа - повтор не поможет
+		return 0, false
+	}
+
+	// Сетевые ошибки, таймаут попытки, обрыв соединения
+	return jitter(backoff), true
+}
+
+// parseRetryAfter разбирает заголовок Retry-After: число секунд или дату HTTP
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
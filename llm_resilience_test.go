@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// scriptedProvider возвращает заранее заданные ошибки по очереди, затем отвечает успешно
+type scriptedProvider struct {
+	errs  []error
+	calls int
+}
+
+func (p *scriptedProvider) Name() string {
+	return "scripted"
+}
+
+func (p *scriptedProvider) Complete(ctx context.Context, req CompletionRequest) (*Completion, error) {
+	p.calls++
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(p.errs) > 0 {
+		err := p.errs[0]
+		p.errs = p.errs[1:]
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Completion{Text: "ok", Provider: p.Name()}, nil
+}
+
+func TestResilientProviderBreaker(t *testing.T) {
+	badRequest := &ProviderError{Provider: "scripted", StatusCode: http.StatusBadRequest, Message: "bad request"}
+	overloaded := &ProviderError{Provider: "scripted", StatusCode: http.StatusServiceUnavailable, RetryAfter: time.Millisecond}
+
+	// step один вызов Stream
+	type step struct {
+		err       error // ответ провайдера, nil - успех
+		cooldown  bool  // пауза отключения истекла перед вызовом
+		cancel    bool  // ctx отменен до вызова
+		wantErr   error // ожидаемая ошибка (errors.Is), nil - успех
+		wantCalls int   // обращений к провайдеру за вызов
+		wantState circuitState
+	}
+
+	tests := []struct {
+		name       string
+		maxRetries int
+		steps      []step
+	}{
+		{
+			name: "отключение после серии ошибок",
+			steps: []step{
+				{err: overloaded, wantErr: overloaded, wantCalls: 1, wantState: circuitClosed},
+				{err: overloaded, wantErr: overloaded, wantCalls: 1, wantState: circuitOpen},
+				{wantErr: ErrCircuitOpen, wantCalls: 0, wantState: circuitOpen},
+			},
+		},
+		{
+			name: "успех сбрасывает счетчик ошибок",
+			steps: []step{
+				{err: overloaded, wantErr: overloaded, wantCalls: 1, wantState: circuitClosed},
+				{wantCalls: 1, wantState: circuitClosed},
+				{err: overloaded, wantErr: overloaded, wantCalls: 1, wantState: circuitClosed},
+			},
+		},
+		{
+			name: "удачный пробный запрос включает провайдера",
+			steps: []step{
+				{err: overloaded, wantErr: overloaded, wantCalls: 1, wantState: circuitClosed},
+				{err: overloaded, wantErr: overloaded, wantCalls: 1, wantState: circuitOpen},
+				{cooldown: true, wantCalls: 1, wantState: circuitClosed},
+			},
+		},
+		{
+			name: "неудачный пробный запрос снова отключает провайдера",
+			steps: []step{
+				{err: overloaded, wantErr: overloaded, wantCalls: 1, wantState: circuitClosed},
+				{err: overloaded, wantErr: overloaded, wantCalls: 1, wantState: circuitOpen},
+				{cooldown: true, err: overloaded, wantErr: overloaded, wantCalls: 1, wantState: circuitOpen},
+				{wantErr: ErrCircuitOpen, wantCalls: 0, wantState: circuitOpen},
+			},
+		},
+		{
+			name: "отмененный пробный запрос не оставляет автомат в circuitHalfOpen",
+			steps: []step{
+				{err: overloaded, wantErr: overloaded, wantCalls: 1, wantState: circuitClosed},
+				{err: overloaded, wantErr: overloaded, wantCalls: 1, wantState: circuitOpen},
+				{cooldown: true, cancel: true, wantErr: context.Canceled, wantCalls: 1, wantState: circuitOpen},
+				{cooldown: true, wantCalls: 1, wantState: circuitClosed},
+			},
+		},
+		{
+			name: "отмена не считается ошибкой провайдера",
+			steps: []step{
+				{err: overloaded, wantErr: overloaded, wantCalls: 1, wantState: circuitClosed},
+				{cancel: true, wantErr: context.Canceled, wantCalls: 1, wantState: circuitClosed},
+				{wantCalls: 1, wantState: circuitClosed},
+			},
+		},
+		{
+			name: "ошибка запроса не считается отказом провайдера",
+			steps: []step{
+				{err: overloaded, wantErr: overloaded, wantCalls: 1, wantState: circuitClosed},
+				{err: badRequest, wantErr: badRequest, wantCalls: 1, wantState: circuitClosed},
+				{err: overloaded, wantErr: overloaded, wantCalls: 1, wantState: circuitClosed},
+				{err: badRequest, wantErr: badRequest, wantCalls: 1, wantState: circuitClosed},
+				{err: badRequest, wantErr: badRequest, wantCalls: 1, wantState: circuitClosed},
+			},
+		},
+		{
+			name: "пробный запрос с ошибкой запроса включает провайдера",
+			steps: []step{
+				{err: overloaded, wantErr: overloaded, wantCalls: 1, wantState: circuitClosed},
+				{err: overloaded, wantErr: overloaded, wantCalls: 1, wantState: circuitOpen},
+				{cooldown: true, err: badRequest, wantErr: badRequest, wantCalls: 1, wantState: circuitClosed},
+			},
+		},
+		{
+			name:       "временная ошибка повторяется",
+			maxRetries: 2,
+			steps: []step{
+				{err: overloaded, wantCalls: 2, wantState: circuitClosed},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &scriptedProvider{}
+			p := NewResilientProvider(provider, ResilienceConfig{
+				AttemptTimeout:   time.Second,
+				MaxRetries:       tt.maxRetries,
+				BreakerThreshold: 2,
+				BreakerCooldown:  time.Hour,
+			})
+
+			for i, s := range tt.steps {
+				if s.cooldown {
+					p.mu.Lock()
+					p.openedAt = time.Now().Add(-2 * time.Hour)
+					p.mu.Unlock()
+				}
+				ctx, cancel := context.WithCancel(context.Background())
+				if s.cancel {
+					cancel()
+				}
+				provider.errs = []error{s.err}
+				provider.calls = 0
+
+				_, err := p.Stream(ctx, CompletionRequest{}, nil)
+				cancel()
+
+				if !errors.Is(err, s.wantErr) || (err == nil) != (s.wantErr == nil) {
+					t.Errorf("шаг %d: ошибка = %v, ожидалось %v", i+1, err, s.wantErr)
+				}
+				if provider.calls != s.wantCalls {
+					t.Errorf("шаг %d: обращений к провайдеру %d, ожидалось %d", i+1, provider.calls, s.wantCalls)
+				}
+				p.mu.Lock()
+				state := p.state
+				p.mu.Unlock()
+				if state != s.wantState {
+					t.Errorf("шаг %d: состояние %d, ожидалось %d", i+1, state, s.wantState)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           error
+		wantRetryable bool
+		wantDelay     time.Duration // 0 - пауза со случайным разбросом
+	}{
+		{"ошибка запроса", &ProviderError{StatusCode: http.StatusBadRequest}, false, 0},
+		{"ошибка в теле ответа", &ProviderError{Message: "content filter"}, false, 0},
+		{"перегрузка с Retry-After", &ProviderError{StatusCode: http.StatusTooManyRequests, RetryAfter: 7 * time.Second}, true, 7 * time.Second},
+		{"сбой сервера", &ProviderError{StatusCode: http.StatusBadGateway}, true, 0},
+		{"сетевая ошибка", errors.New("connection reset by peer"), true, 0},
+	}
+
+	const backoff = 4 * time.Second
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay, retryable := retryDelay(tt.err, backoff)
+			if retryable != tt.wantRetryable {
+				t.Fatalf("retryDelay() повтор = %v, ожидалось %v", retryable, tt.wantRetryable)
+			}
+			if !retryable {
+				return
+			}
+			if tt.wantDelay > 0 && delay != tt.wantDelay {
+				t.Errorf("retryDelay() = %s, ожидалось %s", delay, tt.wantDelay)
+			}
+			if tt.wantDelay == 0 && (delay < backoff/2 || delay > backoff) {
+				t.Errorf("retryDelay() = %s, ожидалось от %s до %s", delay, backoff/2, backoff)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// streamServer отдает заранее записанный поток ответа
+func streamServer(t *testing.T, body string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestStreamToolCalls(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider func(cfg ProviderConfig, client *http.Client) StreamingProvider
+		body     string
+		wantText string
+		want     []ToolCall
+	}{
+		{
+			name: "openai",
+			provider: func(cfg ProviderConfig, client *http.Client) StreamingProvider {
+				return newOpenAIProvider(cfg, client)
+			},
+			body: `data: {"choices":[{"delta":{"content":"Смотрю котировки"}}]}
+data: {"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_a","function":{"name":"get_quote","arguments":""}}]}}]}
+data: {"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"ticker\":"}}]}}]}
+data: {"choices":[{"delta":{"tool_calls":[{"index":1,"id":"call_b","function":{"name":"search_news","arguments":"{}"}}]}}]}
+data: {"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"SBER\"}"}}]}}]}
+data: {"choices":[{"delta":{},"finish_reason":"tool_calls"}]}
+data: [DONE]
+`,
+			wantText: "Смотрю котировки",
+			want: []ToolCall{
+				{ID: "call_a", Name: "get_quote", Arguments: `{"ticker":"SBER"}`},
+				{ID: "call_b", Name: "search_news", Arguments: "{}"},
+			},
+		},
+		{
+			name: "anthropic",
+			provider: func(cfg ProviderConfig, client *http.Client) StreamingProvider {
+				return newAnthropicProvider(cfg, client)
+			},
+			body: `data: {"type":"message_start","message":{"model":"m","usage":{"input_tokens":10}}}
+data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Смотрю котировки"}}
+data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_a","name":"get_quote","input":{}}}
+data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"ticker\":"}}
+data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"SBER\"}"}}
+data: {"type":"content_block_start","index":2,"content_block":{"type":"tool_use","id":"toolu_b","name":"search_news","input":{}}}
+data: {"type":"message_delta","usage":{"output_tokens":5}}
+data: {"type":"message_stop"}
+`,
+			wantText: "Смотрю котировки",
+			want: []ToolCall{
+				{ID: "toolu_a", Name: "get_quote", Arguments: `{"ticker":"SBER"}`},
+				{ID: "toolu_b", Name: "search_news", Arguments: "{}"},
+			},
+		},
+		{
+			name: "ollama",
+			provider: func(cfg ProviderConfig, client *http.Client) StreamingProvider {
+				return newOllamaProvider(cfg, client)
+			},
+			body: `{"message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"get_quote","arguments":{"ticker":"SBER"}}}]},"done":false}
+{"message":{"role":"assistant","content":""},"done":true,"prompt_eval_count":10,"eval_count":5}
+`,
+			want: []ToolCall{
+				{ID: "call_1", Name: "get_quote", Arguments: `{"ticker":"SBER"}`},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := streamServer(t, tt.body)
+			provider := tt.provider(ProviderConfig{Model: "m", BaseURL: server.URL}, server.Client())
+
+			var streamed string
+			completion, err := streamCompletion(context.Background(), provider, CompletionRequest{
+				Messages: []AIMessage{{Role: "user", Content: "Сколько стоит Сбер?"}},
+				Tools:    []ToolDefinition{{Name: "get_quote"}},
+			}, func(text string) { streamed = text })
+			if err != nil {
+				t.Fatalf("streamCompletion: %v", err)
+			}
+
+			if completion.Text != tt.wantText || streamed != tt.wantText {
+				t.Errorf("текст = %q, в потоке %q, ожидалось %q", completion.Text, streamed, tt.wantText)
+			}
+			if len(completion.ToolCalls) != len(tt.want) {
+				t.Fatalf("вызовы = %+v, ожидалось %+v", completion.ToolCalls, tt.want)
+			}
+			for i, call := range completion.ToolCalls {
+				if call != tt.want[i] {
+					t.Errorf("вызов %d = %+v, ожидалось %+v", i, call, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestStreamEmptyResponse(t *testing.T) {
+	server := streamServer(t, "data: {\"choices\":[{\"delta\":{}}]}\ndata: [DONE]\n")
+	provider := newOpenAIProvider(ProviderConfig{Model: "m", BaseURL: server.URL}, server.Client())
+
+	_, err := provider.Stream(context.Background(), CompletionRequest{}, func(string) {})
+	if err == nil || !strings.Contains(err.Error(), "пустой ответ") {
+		t.Errorf("Stream без текста и вызовов: ошибка %v, ожидался пустой ответ", err)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// yandexMessage сообщение в формате Foundation Models API
+type yandexMessage struct {
+	Role string `json:"role"`
+	Text string `json:"text"`
+}
+
+// yandexRequest запрос к методу completion YandexGPT
+type yandexRequest struct {
+	ModelURI          string `json:"modelUri"`
+	CompletionOptions struct {
+		Stream      bool    `json:"stream"`
+		Temperature float64 `json:"temperature"`
+		MaxTokens   string  `json:"maxTokens,omitempty"`
+	} `json:"completionOptions"`
+	Messages []yandexMessage `json:"messages"`
+}
+
+// yandexResponse ответ метода completion. Счетчики токенов приходят строками.
+type yandexResponse struct {
+	Result struct {
+		Alternatives []struct {
+			Message yandexMessage `json:"message"`
+			Status  string        `json:"status"`
+		} `json:"alternatives"`
+		Usage struct {
+			InputTextTokens  string `json:"inputTextTokens"`
+			CompletionTokens string `json:"completionTokens"`
+		} `json:"usage"`
+		ModelVersion string `json:"modelVersion"`
+	} `json:"result"`
+}
+
+// yandexGPTProvider работает с YandexGPT через Foundation Models API
+type yandexGPTProvider struct {
+	apiKey   string
+	model    string
+	folderID string
+	url      string
+	client   *http.Client
+}
+
+// newYandexGPTProvider создает провайдера YandexGPT
+func newYandexGPTProvider(cfg ProviderConfig, client *http.Client) *yandexGPTProvider {
+	return &yandexGPTProvider{
+		apiKey:   cfg.APIKey,
+		model:    cfg.Model,
+		folderID: cfg.FolderID,
+		url:      cfg.BaseURL,
+		client:   client,
+	}
+}
+
+// Name возвращает название провайдера и модели
+func (p *yandexGPTProvider) Name() string {
+	return ProviderYandexGPT + "/" + p.model
+}
+
+// modelURI возвращает адрес модели: модель можно задать полным URI (gpt://...)
+// или именем вида yandexgpt/latest в каталоге folderID
+func (p *yandexGPTProvider) modelURI() string {
+	if strings.Contains(p.model, "://") {
+		return p.model
+	}
+	return "gpt://" + p.folderID + "/" + p.model
+}
+
+// Complete генерирует ответ через метод completion
+func (p *yandexGPTProvider) Complete(ctx context.Context, req CompletionRequest) (*Completion, error) {
+	yandexReq := yandexRequest{ModelURI: p.modelURI()}
+	yandexReq.CompletionOptions.Temperature = req.Temperature
+	if req.MaxTokens > 0 {
+		yandexReq.CompletionOptions.MaxTokens = strconv.Itoa(req.MaxTokens)
+	}
+	if req.System != "" {
+		yandexReq.Messages = append(yandexReq.Messages, yandexMessage{Role: "system", Text: req.System})
+	}
+	// YandexGPT не поддерживает инструменты, их вызовы передаются текстом
+	for _, msg := range plainMessages(req.Messages) {
+		yandexReq.Messages = append(yandexReq.Messages, yandexMessage{Role: msg.Role, Text: msg.Content})
+	}
+
+	// IAM-токены начинаются с t1., остальное считаем API ключом сервисного аккаунта
+	authorization := "Api-Key " + p.apiKey
+	if strings.HasPrefix(p.apiKey, "t1.") {
+		authorization = "Bearer " + p.apiKey
+	}
+	headers := map[string]string{
+		"Authorization": authorization,
+		"x-folder-id":   p.folderID,
+	}
+
+	var resp yandexResponse
+	err := postJSON(ctx, p.client, ProviderYandexGPT, p.url, headers, yandexReq, &resp, yandexErrorMessage)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.Result.Alternatives) == 0 || resp.Result.Alternatives[0].Message.Text == "" {
+		return nil, fmt.Errorf("пустой ответ от API %s", ProviderYandexGPT)
+	}
+
+	promptTokens, _ := strconv.Atoi(resp.Result.Usage.InputTextTokens)
+	completionTokens, _ := strconv.Atoi(resp.Result.Usage.CompletionTokens)
+	return &Completion{
+		Text:             resp.Result.Alternatives[0].Message.Text,
+		Provider:         ProviderYandexGPT,
+		Model:            p.model,
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+	}, nil
+}
+
+// yandexErrorMessage извлекает текст ошибки из ответа Foundation Models API
+func yandexErrorMessage(body []byte) string {
+	var resp struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &resp) != nil {
+		return ""
+	}
+	if resp.Error.Message != "" {
+		return resp.Error.Message
+	}
+	return resp.Message
+}
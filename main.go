@@ -1,22 +1,28 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"math"
 	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/joho/godotenv"
 )
 
-// Время отправки ежедневной аналитики (Московское время, UTC+3)
-const (
-	DAILY_HOUR    = 10 // 10 утра по Москве
-	DAILY_MINUTE  = 0
-	ADMIN_USER_ID = 449066543 // ID администратора бота
-)
-
 func main() {
+	// Завершение по SIGINT/SIGTERM (в том числе docker-compose down)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Загрузка переменных окружения из .env файла
 	err := godotenv.Load()
 	if err != nil {
@@ -26,34 +32,90 @@ func main() {
 		log.Println("Переменные окружения успешно загружены из .env файла")
 	}
 
-	// Получение токена из переменной окружения
-	botToken := os.Getenv("TELEGRAM_BOT_TOKEN")
-	if botToken == "" {
-		log.Fatal("Необходимо установить переменную окружения TELEGRAM_BOT_TOKEN")
+	// Оценка качества выпусков на сохраненных снимках рынка вместо запуска бота
+	if len(os.Args) > 1 && os.Args[1] == "eval" {
+		os.Exit(runEval(ctx, os.Args[2:]))
 	}
 
-	// Получение API ключа и имени модели из переменных окружения
-	apiKey := os.Getenv("AI_API_KEY")
-	if apiKey == "" {
-		log.Fatal("Необходимо установить переменную окружения AI_API_KEY")
+	// Загрузка и проверка настроек
+	cfg, err := LoadConfig()
+	if err != nil {
+		log.Fatalf("Ошибка конфигурации: %v", err)
 	}
-
-	modelName := os.Getenv("AI_MODEL_NAME")
-	if modelName == "" {
-		modelName = "gpt-4o" // Значение по умолчанию, если переменная окружения не установлена
-		log.Println("Переменная AI_MODEL_NAME не установлена, используется модель по умолчанию:", modelName)
+	scheduleLocation, err := loadLocation(cfg.Schedule.Timezone)
+	if err != nil {
+		log.Fatalf("Некорректный часовой пояс расписания %q: %v", cfg.Schedule.Timezone, err)
 	}
 
 	// Инициализация бота
-	bot, err := tgbotapi.NewBotAPI(botToken)
+	bot, err := tgbotapi.NewBotAPI(cfg.Telegram.Token)
 	if err != nil {
 		log.Panic(err)
 	}
 
 	log.Printf("Бот авторизован как %s", bot.Self.UserName)
 
+	// Открываем хранилище подписчиков в каталоге данных
+	storage, err := NewFileStorage(cfg.Storage.DataDir)
+	if err != nil {
+		log.Fatalf("Не удалось открыть хранилище: %v", err)
+	}
+
+	// Роли пользователей и проверка прав на команды
+	access := NewAccessControl(cfg, storage)
+
+	// Шаблоны промптов проверяются при запуске, а не во время первой рассылки
+	prompts, err := NewPromptRegistry(cfg.Prompts)
+	if err != nil {
+		log.Fatalf("Ошибка загрузки шаблонов промптов: %v", err)
+	}
+	if err := prompts.Check(); err != nil {
+		log.Fatalf("Ошибка в шаблонах промптов: %v", err)
+	}
+	log.Printf("Шаблоны промптов: %s", prompts.Versions())
+
+	// Торговый календарь Московской биржи: локальный файл и календарь ISS
+	calendar := NewTradingCalendar(cfg.MarketData.ISSBaseURL, cfg.MarketData.TradingCalendarFile)
+	calendar.Refresh()
+
 	// Создаем AI сервис с передачей необходимых параметров
-	aiService := NewAIService(apiKey, modelName)
+	// Учет расходов на AI: каждый вызов модели записывается в хранилище
+	usage, err := NewUsageTracker(storage, cfg.Usage)
+	if err != nil {
+		log.Fatalf("Ошибка учета расходов на AI: %v", err)
+	}
+
+	provider, err := NewLLMProviderChain(cfg.AI, usage)
+	if err != nil {
+		log.Fatalf("Ошибка настройки провайдера AI: %v", err)
+	}
+	log.Printf("Провайдер AI: %s", provider.Name())
+
+	// После превышения месячного лимита - более дешевая модель основного провайдера
+	var overLimitProvider LLMProvider
+	if cfg.Usage.OverLimitModel != "" {
+		overLimitCfg := cfg.AI
+		overLimitCfg.Model = cfg.Usage.OverLimitModel
+		overLimitCfg.Fallbacks = nil
+		if overLimitProvider, err = NewLLMProviderChain(overLimitCfg, usage); err != nil {
+			log.Fatalf("Ошибка настройки модели после превышения лимита: %v", err)
+		}
+	}
+
+	// Проверка выпусков и ответов после генерации: оговорка и запрещенные утверждения
+	compliance, err := NewCompliance(cfg.Compliance, storage)
+	if err != nil {
+		log.Fatalf("Ошибка настройки проверки соответствия: %v", err)
+	}
+
+	// Модель может сама запросить у биржи данные, которых нет в промпте
+	marketDataService := NewMarketDataService(cfg.MarketData, calendar)
+	aiService := NewAIService(provider, cfg.AI.Timeout, cfg.AI.OutputRetries, NewFactChecker(cfg.FactCheck), usage, overLimitProvider,
+		marketDataService, NewAnalyticsCache(cfg.Cache), NewRecommendationHistory(storage, cfg.History.WindowDays), prompts,
+		NewMarketTools(marketDataService, cfg.AI.Tools), compliance)
+
+	// Ответы на вопросы в свободной форме с памятью диалога
+	assistant := NewChatAssistant(aiService, storage, cfg.Chat)
 
 	// Настройка получения обновлений
 	u := tgbotapi.NewUpdate(0)
@@ -61,159 +123,717 @@ func main() {
 
 	updates := bot.GetUpdatesChan(u)
 
-	// Канал для запуска ежедневной аналитики
-	dailyTicker := scheduleDaily(DAILY_HOUR, DAILY_MINUTE)
+	// Доставка аналитики в локальное время каждого подписчика
+	outbox := NewOutbox(bot, cfg.Telegram.GlobalRate, cfg.Telegram.GroupRate, cfg.Telegram.SendRetries)
+	delivery := NewDeliveryScheduler(outbox, storage, aiService, calendar,
+		cfg.Schedule.DailyHour, cfg.Schedule.DailyMinute, cfg.Schedule.CatchUpGrace)
 
-	// Список подписанных чатов (в реальном проекте лучше использовать базу данных)
-	subscribedChats := make(map[int64]bool)
+	// Планировщик задач по cron-расписанию
+	scheduler := NewScheduler(scheduleLocation, storage, cfg.Schedule.CatchUpGrace)
+	if err := registerJobs(scheduler, cfg.Schedule, delivery, calendar); err != nil {
+		log.Fatalf("Ошибка регистрации задач планировщика: %v", err)
+	}
+	scheduler.Start()
+
+	// Контекст обработчиков: отменяется при завершении, если обработка принятых
+	// сообщений не уложилась в отведенное ей время
+	handlerCtx, cancelHandlers := context.WithCancel(context.Background())
+	defer cancelHandlers()
+
+	// Обновления обрабатываются параллельно, с сохранением порядка внутри чата
+	var dispatcher *Dispatcher
+	dispatcher = NewDispatcher(func(update tgbotapi.Update) {
+		// Обработка сообщений от пользователей
+		if update.Message != nil {
+			log.Printf("[%s] %s", update.Message.From.UserName, update.Message.Text)
+			handleMessage(handlerCtx, bot, update.Message, access, storage, aiService, assistant, delivery, scheduler, dispatcher, usage, compliance)
+		}
+	}, cfg.Telegram.Workers, cfg.Telegram.QueueSize)
 
-	// Основной цикл обработки сообщений
+	// Основной цикл получения обновлений до сигнала завершения
+receive:
 	for {
 		select {
-		case update := <-updates:
-			// Обработка сообщений от пользователей
-			if update.Message != nil {
-				log.Printf("[%s] %s", update.Message.From.UserName, update.Message.Text)
-				handleMessage(bot, update.Message, subscribedChats, aiService)
+		case <-ctx.Done():
+			break receive
+		case update, ok := <-updates:
+			if !ok {
+				log.Println("Канал обновлений Telegram закрыт, останавливаем бота")
+				break receive
 			}
-		case <-dailyTicker:
-			// Отправка ежедневной аналитики всем подписчикам
-			sendDailyAnalytics(bot, subscribedChats, aiService)
+			if err := dispatcher.Dispatch(ctx, update); err != nil {
+				log.Printf("Обновление %d не принято: %v", update.UpdateID, err)
+				break receive
+			}
+		}
+	}
+	stop()
+
+	shutdown(bot, dispatcher, cancelHandlers, scheduler, delivery, storage, cfg.ShutdownTimeout)
+}
+
+// shutdown останавливает бота: прекращает прием обновлений, дожидается обработки
+// принятых сообщений и текущей рассылки и сохраняет хранилище. Каждый шаг
+// получает свою долю timeout, чтобы зависший шаг не отнял время у следующих:
+// обработка сообщений - до 2/5 (затем ее контекст отменяется через
+// cancelHandlers), рассылка - до 4/5, остаток - сохранение хранилища. Прерванная
+// рассылка продолжится догоняющей рассылкой после перезапуска.
+func shutdown(bot *tgbotapi.BotAPI, dispatcher *Dispatcher, cancelHandlers context.CancelFunc, scheduler *Scheduler, delivery *DeliveryScheduler, storage Storage, timeout time.Duration) {
+	log.Printf("Получен сигнал завершения, останавливаем бота (не дольше %s)", timeout)
+	started := time.Now()
+
+	bot.StopReceivingUpdates()
+
+	dispatcherCtx, cancel := context.WithDeadline(context.Background(), started.Add(timeout*2/5))
+	defer cancel()
+	if err := dispatcher.Shutdown(dispatcherCtx); err != nil {
+		log.Printf("Ошибка завершения обработки сообщений: %v", err)
+	}
+	// Незавершенные генерации и ответы прерываются, чтобы не занимать время рассылки
+	cancelHandlers()
+
+	// Рассылку прерываем заранее, чтобы ее задача успела завершиться до своего срока
+	interrupt := time.AfterFunc(time.Until(started.Add(timeout*3/5)), delivery.Stop)
+	defer interrupt.Stop()
+
+	schedulerCtx, cancel := context.WithDeadline(context.Background(), started.Add(timeout*4/5))
+	defer cancel()
+	if err := scheduler.Stop(schedulerCtx); err != nil {
+		log.Printf("Ошибка остановки планировщика: %v", err)
+	}
+	if err := storage.Close(); err != nil {
+		log.Printf("Ошибка сохранения хранилища: %v", err)
+	}
+
+	log.Println("Бот остановлен")
+}
+
+// registerJobs регистрирует задачи рассылки в планировщике
+func registerJobs(scheduler *Scheduler, cfg ScheduleConfig, delivery *DeliveryScheduler, calendar *TradingCalendar) error {
+	// Ежедневная аналитика: каждую минуту проверяем, у кого из подписчиков наступило время доставки.
+	// Пропущенные доставки Deliver догоняет сам по истории чатов, поэтому время
+	// запуска не сохраняется - иначе хранилище переписывалось бы каждую минуту.
+	if err := scheduler.RegisterTransient("daily", "* * * * *", delivery.Deliver); err != nil {
+		return err
+	}
+
+	// Ежедневное обновление торгового календаря
+	refreshCalendar := func(time.Time) error {
+		calendar.Refresh()
+		return nil
+	}
+	if err := scheduler.Register("calendar", "0 5 * * *", refreshCalendar); err != nil {
+		return err
+	}
+
+	broadcasts := []struct {
+		name string
+		spec string
+		job  JobFunc
+	}{
+		{"premarket", cfg.PreMarket, delivery.BroadcastOnTradingDays(AnalyticsPreMarket)},
+		{"postclose", cfg.PostClose, delivery.BroadcastOnTradingDays(AnalyticsPostClose)},
+		{"weekly", cfg.WeeklyDigest, func(scheduled time.Time) error { return delivery.Broadcast(AnalyticsWeekly, scheduled) }},
+	}
+	for _, b := range broadcasts {
+		if b.spec == scheduleDisabled {
+			log.Printf("Задача %s отключена", b.name)
+			continue
+		}
+		if err := scheduler.Register(b.name, b.spec, b.job); err != nil {
+			return err
 		}
 	}
+
+	return nil
+}
+
+// commandHelp описания команд в порядке вывода в приветствии
+var commandHelp = []struct {
+	command     string
+	description string
+}{
+	{"subscribe", "подписаться на ежедневную аналитику 📊"},
+	{"unsubscribe", "отписаться от ежедневной аналитики 🚫"},
+	{"schedule", "ЧЧ:ММ [часовой пояс] - выбрать время доставки ⏰"},
+	{"style", "[стиль] - выбрать стиль выпусков: милый, нейтральный, деловой, кратко 🎨"},
+	{"budget", "[сумма] - сколько рублей ты готов вложить 💰"},
+	{"analytics", "получить аналитику прямо сейчас ✨"},
+	{"ask", "вопрос - спросить про рынок (в личном чате можно просто написать) 💬"},
+	{"forget", "начать разговор заново 🧹"},
+	{"jobs", "расписание рассылок 🗓"},
+	{"deliveries", "отчеты о последних рассылках 📬"},
+	{"status", "состояние очереди обновлений 📈"},
+	{"usage", "[дней] - расход токенов и стоимость AI 💸"},
+	{"compliance", "[дней] - журнал проверки соответствия требованиям 🛡"},
+	{"refresh", "сбросить кэш аналитики и данных биржи 🔄"},
+	{"roles", "список ролей пользователей 👥"},
+	{"grant", "ID роль - назначить роль (или ответом на сообщение) 🔑"},
+	{"revoke", "ID - снять роль (или ответом на сообщение) 🔓"},
 }
 
 // Обработка сообщений от пользователей
-func handleMessage(bot *tgbotapi.BotAPI, message *tgbotapi.Message, subscribedChats map[int64]bool, aiService *AIService) {
+func handleMessage(ctx context.Context, bot *tgbotapi.BotAPI, message *tgbotapi.Message, access *AccessControl, storage Storage, aiService *AIService, assistant *ChatAssistant, delivery *DeliveryScheduler, scheduler *Scheduler, dispatcher *Dispatcher, usage *UsageTracker, compliance *Compliance) {
 	chatID := message.Chat.ID
 	userID := message.From.ID
 
-	// Проверка, является ли пользователь администратором
-	isAdmin := userID == ADMIN_USER_ID
+	command := message.Command()
+	if command == "" {
+		// Обычный текст - вопрос боту, если он написан в личном чате или
+		// ответом на сообщение бота в группе; остальные сообщения игнорируем
+		if !isQuestion(bot, message) {
+			return
+		}
+		command = "ask"
+	}
 
-	// Проверяем, что сообщение является командой (начинается с /)
-	if len(message.Text) == 0 || message.Text[0] != '/' {
-		// Если это не команда, игнорируем сообщение
+	// Все команды проходят через единую проверку прав
+	role, err := access.Authorize(userID, command)
+	switch {
+	case errors.Is(err, ErrUnknownCommand):
+		return
+	case errors.Is(err, ErrPermissionDenied):
+		if role != RoleBanned {
+			text := "Извините, но эта команда вам недоступна! 🔒 Попросите администратора выдать доступ."
+			if !message.IsCommand() {
+				text = "Извините, но отвечать на вопросы я могу только подписчикам! 🔒 Попросите администратора выдать доступ."
+			}
+			bot.Send(tgbotapi.NewMessage(chatID, text))
+		}
+		return
+	case err != nil:
+		log.Printf("Ошибка проверки прав: %v", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "Ой, что-то пошло не так 😢 Попробуй позже! 💕"))
 		return
 	}
 
-	// Для команд, доступных всем пользователям
-	switch message.Command() {
+	switch command {
 	case "start":
-		// Приветственное сообщение
-		welcomeText := `Привет! 👋 Я твой милый помощник по инвестициям! 💖
-
-Я буду каждый день в 10:00 по Москве отправлять тебе аналитику по российскому рынку с рекомендациями куда вложить 1000 рублей! 💰
+		// Приветственное сообщение со списком доступных команд
+		settings := ChatSettings{}
+		if chat, err := storage.GetChat(chatID); err == nil {
+			settings = chat.Settings
+		}
+		var sb strings.Builder
+		sb.WriteString(fmt.Sprintf(`Привет! 👋 Я твой милый помощник по инвестициям! 💖
 
-Используй команды:
-/subscribe - подписаться на ежедневную аналитику 📊
-/unsubscribe - отписаться от ежедневной аналитики 🚫
-/analytics - получить аналитику прямо сейчас ✨`
+Я буду каждый день в %s (или в удобное тебе время) отправлять тебе аналитику по российскому рынку с рекомендациями куда вложить %s рублей! 💰`,
+			delivery.DescribeSchedule(settings), formatMoney(budgetOrDefault(settings.Budget))))
 
-		if isAdmin {
-			welcomeText += "\n\n🔐 Вы администратор бота и имеете доступ ко всем функциям!"
+		available := 0
+		for _, help := range commandHelp {
+			if !role.AtLeast(commandRoles[help.command]) {
+				continue
+			}
+			if available == 0 {
+				sb.WriteString("\n\nИспользуй команды:")
+			}
+			sb.WriteString(fmt.Sprintf("\n/%s - %s", help.command, help.description))
+			available++
+		}
+		if available == 0 {
+			sb.WriteString("\n\nЧтобы получать аналитику, попроси администратора выдать тебе доступ 🔑 Твой ID: " + strconv.FormatInt(userID, 10))
 		}
 
-		msg := tgbotapi.NewMessage(chatID, welcomeText)
-		bot.Send(msg)
-		return
-	case "subscribe", "unsubscribe", "analytics":
-		// Проверяем, является ли пользователь админом для этих команд
-		if !isAdmin {
-			msg := tgbotapi.NewMessage(chatID, "Извините, но эта команда доступна только администратору бота! 🔒")
-			bot.Send(msg)
-			return
+		if role.AtLeast(RoleAdmin) {
+			sb.WriteString(fmt.Sprintf("\n\n🔐 Ваша роль: %s", role))
 		}
-	default:
+
+		bot.Send(tgbotapi.NewMessage(chatID, sb.String()))
 		return
 	}
 
-	// Обработка команд для администратора
-	switch message.Command() {
+	switch command {
 	case "subscribe":
 		// Подписка на ежедневную аналитику
-		subscribedChats[chatID] = true
-		msg := tgbotapi.NewMessage(chatID, "Вы успешно подписались на ежедневную аналитику! 🎉 Ожидайте первый выпуск в 10:00 по Москве! 💖")
+		if err := storage.Subscribe(chatID); err != nil {
+			log.Printf("Ошибка подписки чата %d: %v", chatID, err)
+			bot.Send(tgbotapi.NewMessage(chatID, "Ой, не получилось оформить подписку 😢 Попробуй позже! 💕"))
+			return
+		}
+		settings := ChatSettings{}
+		if chat, err := storage.GetChat(chatID); err == nil {
+			settings = chat.Settings
+		}
+		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("Вы успешно подписались на ежедневную аналитику! 🎉 Ожидайте первый выпуск в %s! 💖\n\nВремя можно поменять командой /schedule ⏰", delivery.DescribeSchedule(settings)))
 		bot.Send(msg)
 
 	case "unsubscribe":
 		// Отписка от ежедневной аналитики
-		delete(subscribedChats, chatID)
+		if err := storage.Unsubscribe(chatID); err != nil {
+			log.Printf("Ошибка отписки чата %d: %v", chatID, err)
+			bot.Send(tgbotapi.NewMessage(chatID, "Ой, не получилось отписаться 😢 Попробуй позже! 💕"))
+			return
+		}
 		msg := tgbotapi.NewMessage(chatID, "Вы отписались от ежедневной аналитики 😢 Будем скучать! 💔")
 		bot.Send(msg)
 
+	case "schedule":
+		// Настройка времени доставки ежедневной аналитики
+		handleSchedule(bot, message, storage, delivery)
+
+	case "style":
+		// Выбор стиля выпусков для чата
+		handleStyle(bot, message, storage)
+
+	case "budget":
+		// Выбор суммы, на которую рассчитываются рекомендации
+		handleBudget(bot, message, storage)
+
+	case "jobs":
+		// Расписание задач планировщика
+		bot.Send(tgbotapi.NewMessage(chatID, formatJobs(scheduler.Jobs())))
+
+	case "deliveries":
+		// Отчеты о последних рассылках
+		bot.Send(tgbotapi.NewMessage(chatID, formatDeliveryReports(delivery.Reports())))
+
+	case "status":
+		// Метрики очереди обновлений
+		bot.Send(tgbotapi.NewMessage(chatID, formatDispatcherStats(dispatcher.Stats())))
+
+	case "usage":
+		// Расход токенов и стоимость вызовов модели
+		days := defaultUsageDays
+		if arg := strings.TrimSpace(message.CommandArguments()); arg != "" {
+			n, err := strconv.Atoi(arg)
+			if err != nil || n < 1 || n > maxUsageDays {
+				bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Укажи число дней от 1 до %d, например: /usage 30", maxUsageDays)))
+				return
+			}
+			days = n
+		}
+		report, err := usage.Report(days)
+		if err != nil {
+			log.Printf("Ошибка отчета о расходах: %v", err)
+			bot.Send(tgbotapi.NewMessage(chatID, "Ой, не получилось собрать отчет 😢 Попробуй позже! 💕"))
+			return
+		}
+		bot.Send(tgbotapi.NewMessage(chatID, formatUsage(report, usage)))
+
+	case "compliance":
+		// Журнал вмешательств проверки соответствия требованиям
+		days := defaultComplianceDays
+		if arg := strings.TrimSpace(message.CommandArguments()); arg != "" {
+			n, err := strconv.Atoi(arg)
+			if err != nil || n < 1 || n > maxComplianceDays {
+				bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Укажи число дней от 1 до %d, например: /compliance 30", maxComplianceDays)))
+				return
+			}
+			days = n
+		}
+		report, err := compliance.Audit(days)
+		if err != nil {
+			log.Printf("Ошибка отчета проверки соответствия: %v", err)
+			bot.Send(tgbotapi.NewMessage(chatID, "Ой, не получилось собрать отчет 😢 Попробуй позже! 💕"))
+			return
+		}
+		bot.Send(tgbotapi.NewMessage(chatID, formatComplianceReport(report)))
+
+	case "refresh":
+		// Принудительное обновление: следующий выпуск генерируется заново по свежим данным
+		n := aiService.InvalidateCache()
+		log.Printf("Пользователь %d сбросил кэш аналитики (выпусков: %d)", userID, n)
+		bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Кэш сброшен 🔄 Выпусков удалено: %d. Следующая аналитика будет сгенерирована по свежим данным биржи.", n)))
+
+	case "roles", "grant", "revoke":
+		// Управление ролями пользователей
+		handleRoles(bot, message, access)
+
+	case "ask":
+		// Вопрос в свободной форме: командой /ask или обычным сообщением
+		question := message.Text
+		if message.IsCommand() {
+			question = message.CommandArguments()
+		}
+		handleQuestion(ctx, bot, message, storage, assistant, question)
+
+	case "forget":
+		// Очистка памяти диалога
+		if err := assistant.Forget(chatID); err != nil {
+			log.Printf("Ошибка очистки диалога чата %d: %v", chatID, err)
+			bot.Send(tgbotapi.NewMessage(chatID, "Ой, не получилось забыть разговор 😢 Попробуй позже! 💕"))
+			return
+		}
+		bot.Send(tgbotapi.NewMessage(chatID, "Готово! 🧹 Я забыла наш разговор, можно начинать заново 💖"))
+
 	case "analytics":
-		// Отправка аналитики по запросу
+		// Отправка аналитики по запросу: заглушка обновляется по мере генерации
 		msg := tgbotapi.NewMessage(chatID, "Генерирую аналитику, пожалуйста, подождите... ⏳")
-		sentMsg, _ := bot.Send(msg)
+		sentMsg, err := bot.Send(msg)
+		if err != nil {
+			log.Printf("Ошибка отправки сообщения: %v", err)
+			return
+		}
+		live := NewLiveMessage(bot, chatID, sentMsg.MessageID)
+
+		settings := ChatSettings{}
+		if chat, err := storage.GetChat(chatID); err == nil {
+			settings = chat.Settings
+		}
 
-		analytics, err := aiService.GenerateAnalytics()
+		ctx := WithUsageSource(ctx, UsageSource{UserID: userID, ChatID: chatID})
+		analytics, err := aiService.GenerateAnalyticsStream(ctx, AnalyticsDaily, settings.Persona, settings.Budget, live.Update)
 		if err != nil {
 			log.Printf("Ошибка генерации аналитики: %v", err)
-			errorMsg := tgbotapi.NewMessage(chatID, "Извини, произошла ошибка при генерации аналитики 😢 Попробуй позже! 💕")
-			bot.Send(errorMsg)
+			text := "Извини, произошла ошибка при генерации аналитики 😢 Попробуй позже! 💕"
+			switch {
+			case errors.Is(err, ErrCircuitOpen):
+				text = "Сервис аналитики сейчас недоступен 😢 Попробуй через пару минут! 💕"
+			case errors.Is(err, ErrComplianceBlocked):
+				text = "Извини, сегодняшний выпуск не прошел проверку и не может быть отправлен 😢 Попробуй позже! 💕"
+			}
+			live.Fail(text)
 			return
 		}
 
-		// Редактируем сообщение, заменяя его на аналитику
-		editMsg := tgbotapi.NewEditMessageText(chatID, sentMsg.MessageID, analytics)
-		editMsg.ParseMode = "Markdown"
-		bot.Send(editMsg)
+		live.Finish(analytics)
 	}
 }
 
-// Планировщик ежедневных задач
-func scheduleDaily(hour, minute int) <-chan time.Time {
-	ticker := make(chan time.Time)
+// handleRoles обрабатывает команды /roles, /grant и /revoke
+func handleRoles(bot *tgbotapi.BotAPI, message *tgbotapi.Message, access *AccessControl) {
+	chatID := message.Chat.ID
+	args := strings.Fields(message.CommandArguments())
 
-	go func() {
-		for {
-			// Получаем текущее время в Москве (UTC+3)
-			loc, _ := time.LoadLocation("Europe/Moscow")
-			if loc == nil {
-				// Если не удалось загрузить локацию, используем UTC+3 вручную
-				loc = time.FixedZone("MSK", 3*60*60)
-			}
-			now := time.Now().In(loc)
+	if message.Command() == "roles" {
+		assignments, err := access.Assignments()
+		if err != nil {
+			log.Printf("Ошибка получения ролей: %v", err)
+			bot.Send(tgbotapi.NewMessage(chatID, "Ой, не получилось получить список ролей 😢"))
+			return
+		}
+		bot.Send(tgbotapi.NewMessage(chatID, formatRoles(assignments)))
+		return
+	}
 
-			// Вычисляем следующее время запуска
-			nextRun := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, loc)
-			if now.After(nextRun) {
-				// Если текущее время после запланированного - переходим на следующий день
-				nextRun = nextRun.Add(24 * time.Hour)
-			}
+	// Пользователь задается ID в первом аргументе или ответом на его сообщение
+	var target int64
+	if message.ReplyToMessage != nil && message.ReplyToMessage.From != nil {
+		target = message.ReplyToMessage.From.ID
+	} else if len(args) > 0 {
+		id, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil || id <= 0 {
+			bot.Send(tgbotapi.NewMessage(chatID, "Укажи числовой ID пользователя или ответь командой на его сообщение 🙏"))
+			return
+		}
+		target = id
+		args = args[1:]
+	} else {
+		bot.Send(tgbotapi.NewMessage(chatID, "Использование: /grant ID роль или /revoke ID (можно ответом на сообщение пользователя)"))
+		return
+	}
+
+	var err error
+	var done string
+	if message.Command() == "grant" {
+		if len(args) == 0 {
+			bot.Send(tgbotapi.NewMessage(chatID, "Укажи роль: subscriber, editor, admin или banned"))
+			return
+		}
+		var role Role
+		role, err = ParseRole(args[0])
+		if err != nil {
+			bot.Send(tgbotapi.NewMessage(chatID, "Не знаю такую роль 🥺 Доступные роли: subscriber, editor, admin, banned"))
+			return
+		}
+		err = access.Grant(message.From.ID, target, role)
+		done = fmt.Sprintf("Пользователю %d назначена роль %s ✅", target, role)
+	} else {
+		err = access.Revoke(message.From.ID, target)
+		done = fmt.Sprintf("С пользователя %d снята роль ✅", target)
+	}
+
+	switch {
+	case errors.Is(err, ErrPermissionDenied):
+		bot.Send(tgbotapi.NewMessage(chatID, "Недостаточно прав: можно управлять только ролями ниже своей 🔒"))
+	case errors.Is(err, ErrConfiguredRole):
+		bot.Send(tgbotapi.NewMessage(chatID, "Роль этого пользователя задана в настройках бота и не меняется командами 🔒"))
+	case err != nil:
+		log.Printf("Ошибка изменения роли пользователя %d: %v", target, err)
+		bot.Send(tgbotapi.NewMessage(chatID, "Ой, не получилось изменить роль 😢 Попробуй позже!"))
+	default:
+		log.Printf("Пользователь %d: %s", message.From.ID, done)
+		bot.Send(tgbotapi.NewMessage(chatID, done))
+	}
+}
+
+// formatRoles форматирует список назначенных ролей
+func formatRoles(assignments []RoleAssignment) string {
+	if len(assignments) == 0 {
+		return "Роли еще никому не назначены 🤷"
+	}
+
+	sort.Slice(assignments, func(i, j int) bool {
+		if roleRanks[assignments[i].Role] != roleRanks[assignments[j].Role] {
+			return roleRanks[assignments[i].Role] > roleRanks[assignments[j].Role]
+		}
+		return assignments[i].UserID < assignments[j].UserID
+	})
+
+	var sb strings.Builder
+	sb.WriteString("👥 Роли пользователей:\n")
+	for _, assignment := range assignments {
+		if assignment.GrantedAt.IsZero() {
+			sb.WriteString(fmt.Sprintf("\n• %d - %s (из настроек)", assignment.UserID, assignment.Role))
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("\n• %d - %s (выдал %d, %s)", assignment.UserID, assignment.Role,
+			assignment.GrantedBy, assignment.GrantedAt.Format("02.01.2006")))
+	}
+
+	return sb.String()
+}
+
+// formatDispatcherStats форматирует метрики очереди обновлений
+func formatDispatcherStats(stats DispatcherStats) string {
+	return fmt.Sprintf("📈 Очередь обновлений:\n"+
+		"• в очереди: %d из %d (максимум %d)\n"+
+		"• чатов в обработке: %d\n"+
+		"• занято обработчиков: %d из %d\n"+
+		"• обработано: %d\n"+
+		"• ожиданий свободного места: %d (всего %s)",
+		stats.QueueDepth, stats.QueueCapacity, stats.MaxQueueDepth,
+		stats.ActiveChats,
+		stats.Busy, stats.Workers,
+		stats.Processed,
+		stats.Throttled, stats.ThrottledFor.Round(time.Millisecond))
+}
 
-			// Ожидание до следующего запуска
-			waitDuration := nextRun.Sub(now)
-			log.Printf("Следующая отправка аналитики через %s", waitDuration)
+// formatJobs форматирует состояние задач планировщика для администратора
+func formatJobs(jobs []JobInfo) string {
+	if len(jobs) == 0 {
+		return "Задач в расписании нет 🤷"
+	}
 
-			timer := time.NewTimer(waitDuration)
-			<-timer.C
+	var sb strings.Builder
+	sb.WriteString("🗓 Расписание рассылок:\n")
+	for _, job := range jobs {
+		lastRun := "еще не запускалась"
+		if !job.LastRun.IsZero() {
+			lastRun = job.LastRun.Format("02.01.2006 15:04")
+		}
+		status := ""
+		if job.Running {
+			status = " ⏳ выполняется"
+		} else if job.LastError != "" {
+			status = " ⚠️ " + job.LastError
+		}
+		sb.WriteString(fmt.Sprintf("\n• %s (%s)%s\n  следующий запуск: %s\n  последний запуск: %s\n",
+			job.Name, job.Spec, status, job.NextRun.Format("02.01.2006 15:04"), lastRun))
+	}
 
-			// Отправляем сигнал в канал
-			ticker <- time.Now()
+	return sb.String()
+}
+
+// formatDeliveryReports форматирует отчеты о рассылках
+func formatDeliveryReports(reports []DeliveryReport) string {
+	if len(reports) == 0 {
+		return "С момента запуска бота рассылок не было 📭"
+	}
+
+	var sb strings.Builder
+	sb.WriteString("📬 Последние рассылки:\n")
+	for _, report := range reports {
+		sb.WriteString(fmt.Sprintf("\n• %s\n  %s\n", report.Started.Format("02.01.2006 15:04"), report.Summary()))
+		for _, e := range report.Errors {
+			sb.WriteString("  ⚠️ " + e + "\n")
 		}
-	}()
+	}
 
-	return ticker
+	return sb.String()
 }
 
-// Отправка ежедневной аналитики всем подписчикам
-func sendDailyAnalytics(bot *tgbotapi.BotAPI, subscribedChats map[int64]bool, aiService *AIService) {
-	log.Printf("Отправка ежедневной аналитики %d подписчикам", len(subscribedChats))
+// handleSchedule показывает или меняет время доставки аналитики для чата
+func handleSchedule(bot *tgbotapi.BotAPI, message *tgbotapi.Message, storage Storage, delivery *DeliveryScheduler) {
+	chatID := message.Chat.ID
+	args := strings.Fields(message.CommandArguments())
+
+	if len(args) == 0 {
+		settings := ChatSettings{}
+		if chat, err := storage.GetChat(chatID); err == nil {
+			settings = chat.Settings
+		}
+		text := fmt.Sprintf("Сейчас я присылаю аналитику в %s ⏰\n\n"+
+			"Чтобы поменять время, напиши например:\n"+
+			"/schedule 09:30 - время по твоему текущему часовому поясу\n"+
+			"/schedule 08:00 Владивосток - время и часовой пояс\n"+
+			"/schedule 11:00 UTC+2 - часовой пояс смещением 💕", delivery.DescribeSchedule(settings))
+		bot.Send(tgbotapi.NewMessage(chatID, text))
+		return
+	}
 
-	analytics, err := aiService.GenerateAnalytics()
+	hour, minute, err := ParseClock(args[0])
 	if err != nil {
-		log.Printf("Ошибка генерации ежедневной аналитики: %v", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "Не поняла время 🥺 Укажи его в формате ЧЧ:ММ, например /schedule 09:30"))
 		return
 	}
 
-	for chatID := range subscribedChats {
-		msg := tgbotapi.NewMessage(chatID, analytics)
-		msg.ParseMode = "Markdown"
-		_, err := bot.Send(msg)
+	timezone := ""
+	if len(args) > 1 {
+		timezone, _, err = ParseTimezone(strings.Join(args[1:], " "))
 		if err != nil {
-			log.Printf("Ошибка отправки аналитики пользователю %d: %v", chatID, err)
+			bot.Send(tgbotapi.NewMessage(chatID, "Не знаю такой часовой пояс 🥺 Попробуй город (Новосибирск), IANA имя (Asia/Novosibirsk) или смещение (UTC+7)"))
+			return
+		}
+	}
+
+	var updated ChatSettings
+	err = storage.UpdateSettings(chatID, func(settings *ChatSettings) {
+		settings.DeliveryTime = fmt.Sprintf("%02d:%02d", hour, minute)
+		if timezone != "" {
+			settings.Timezone = timezone
+		}
+		updated = *settings
+	})
+	if err != nil {
+		log.Printf("Ошибка сохранения расписания чата %d: %v", chatID, err)
+		bot.Send(tgbotapi.NewMessage(chatID, "Ой, не получилось сохранить время 😢 Попробуй позже! 💕"))
+		return
+	}
+
+	bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Готово! ✨ Теперь аналитика будет приходить в %s 💖", delivery.DescribeSchedule(updated))))
+}
+
+// handleStyle обрабатывает команду /style: без аргументов показывает стили,
+// с названием стиля - сохраняет его для чата
+func handleStyle(bot *tgbotapi.BotAPI, message *tgbotapi.Message, storage Storage) {
+	chatID := message.Chat.ID
+	arg := strings.TrimSpace(message.CommandArguments())
+
+	if arg == "" {
+		var current Persona
+		if chat, err := storage.GetChat(chatID); err == nil {
+			current = chat.Settings.Persona
+		}
+		bot.Send(tgbotapi.NewMessage(chatID, formatPersonas(current)))
+		return
+	}
+
+	persona, ok := ParsePersona(arg)
+	if !ok {
+		bot.Send(tgbotapi.NewMessage(chatID, "Не знаю такого стиля 🥺\n\n"+formatPersonas("")))
+		return
+	}
+
+	err := storage.UpdateSettings(chatID, func(settings *ChatSettings) {
+		settings.Persona = persona
+	})
+	if err != nil {
+		log.Printf("Ошибка сохранения стиля чата %d: %v", chatID, err)
+		bot.Send(tgbotapi.NewMessage(chatID, "Ой, не получилось сохранить стиль 😢 Попробуй позже! 💕"))
+		return
+	}
+
+	bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Готово! Теперь выпуски будут в стиле «%s» - %s", persona.Style().Title, persona.Style().Description)))
+}
+
+// handleBudget обрабатывает команду /budget: без аргументов показывает текущий
+// бюджет, с суммой - сохраняет ее для чата
+func handleBudget(bot *tgbotapi.BotAPI, message *tgbotapi.Message, storage Storage) {
+	chatID := message.Chat.ID
+	arg := strings.TrimSpace(message.CommandArguments())
+
+	if arg == "" {
+		budget := defaultBudget
+		if chat, err := storage.GetChat(chatID); err == nil {
+			budget = budgetOrDefault(chat.Settings.Budget)
+		}
+		text := fmt.Sprintf("Сейчас я подбираю рекомендации на %s ₽ 💰\n\n"+
+			"Чтобы поменять сумму, напиши например:\n"+
+			"/budget 50000\n"+
+			"/budget 50 000 ₽\n"+
+			"/budget 50к 💕", formatMoney(budget))
+		bot.Send(tgbotapi.NewMessage(chatID, text))
+		return
+	}
+
+	budget, err := ParseBudget(arg)
+	if err != nil {
+		bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Не поняла сумму 🥺 %v. Например: /budget 50000", err)))
+		return
+	}
+
+	err = storage.UpdateSettings(chatID, func(settings *ChatSettings) {
+		settings.Budget = budget
+	})
+	if err != nil {
+		log.Printf("Ошибка сохранения бюджета чата %d: %v", chatID, err)
+		bot.Send(tgbotapi.NewMessage(chatID, "Ой, не получилось сохранить сумму 😢 Попробуй позже! 💕"))
+		return
+	}
+
+	bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Готово! ✨ Теперь я буду подбирать, куда вложить %s ₽ 💖", formatMoney(budget))))
+}
+
+// isQuestion сообщает, что обычное сообщение адресовано боту: написано
+// в личном чате или ответом на сообщение бота в группе
+func isQuestion(bot *tgbotapi.BotAPI, message *tgbotapi.Message) bool {
+	if strings.TrimSpace(message.Text) == "" {
+		return false
+	}
+	if message.Chat.IsPrivate() {
+		return true
+	}
+	reply := message.ReplyToMessage
+	return reply != nil && reply.From != nil && reply.From.ID == bot.Self.ID
+}
+
+// handleQuestion отвечает на вопрос в свободной форме: заглушка обновляется
+// по мере генерации ответа
+func handleQuestion(ctx context.Context, bot *tgbotapi.BotAPI, message *tgbotapi.Message, storage Storage, assistant *ChatAssistant, question string) {
+	chatID := message.Chat.ID
+	userID := message.From.ID
+
+	if strings.TrimSpace(question) == "" {
+		bot.Send(tgbotapi.NewMessage(chatID, "Напиши вопрос после команды, например: /ask стоит ли сейчас покупать Сбер? 💬"))
+		return
+	}
+
+	sentMsg, err := bot.Send(tgbotapi.NewMessage(chatID, "Думаю над ответом... ⏳"))
+	if err != nil {
+		log.Printf("Ошибка отправки сообщения: %v", err)
+		return
+	}
+	live := NewLiveMessage(bot, chatID, sentMsg.MessageID)
+
+	settings := ChatSettings{}
+	if chat, err := storage.GetChat(chatID); err == nil {
+		settings = chat.Settings
+	}
+
+	ctx = WithUsageSource(ctx, UsageSource{UserID: userID, ChatID: chatID})
+	answer, err := assistant.Ask(ctx, chatID, userID, question, settings, live.Update)
+	if err != nil {
+		var rateLimit *RateLimitError
+		text := "Извини, не получилось ответить 😢 Попробуй позже! 💕"
+		switch {
+		case errors.As(err, &rateLimit):
+			text = fmt.Sprintf("Ты задаешь вопросы быстрее, чем я успеваю думать 🥺 Следующий можно будет задать через %s ⏳", formatWait(rateLimit.RetryAfter))
+		case errors.Is(err, ErrQuestionTooLong):
+			text = "Ой, вопрос слишком длинный 🥺 Попробуй сформулировать покороче!"
+		case errors.Is(err, ErrChatDisabled):
+			text = "Сейчас я не отвечаю на вопросы, но аналитика по-прежнему доступна командой /analytics 💕"
+		case errors.Is(err, ErrUsageLimitReached):
+			text = "Лимит на ответы в этом месяце исчерпан 😢 Аналитика по-прежнему доступна командой /analytics 💕"
+		case errors.Is(err, ErrCircuitOpen):
+			text = "Сервис аналитики сейчас недоступен 😢 Попробуй через пару минут! 💕"
+		case errors.Is(err, ErrComplianceBlocked):
+			text = "Извини, на этот вопрос я не могу ответить 🙈 Я не даю обещаний доходности и не делюсь непроверенной информацией. Попробуй спросить иначе! 💕"
+		default:
+			log.Printf("Ошибка ответа на вопрос в чате %d: %v", chatID, err)
 		}
+		live.Fail(text)
+		return
+	}
+
+	live.Finish(answer)
+}
+
+// formatWait выводит время ожидания в минутах (или секундах, если меньше минуты)
+func formatWait(d time.Duration) string {
+	if d < time.Minute {
+		return fmt.Sprintf("%d сек.", int(math.Ceil(d.Seconds())))
 	}
+	return fmt.Sprintf("%d мин.", int(math.Ceil(d.Minutes())))
 }
@@ -6,9 +6,9 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -19,9 +19,15 @@ type MarketData struct {
 	USDRate          float64     `json:"usd_rate"`
 	EURRate          float64     `json:"eur_rate"`
 	TopStocks        []StockInfo `json:"top_stocks"`
+	Stocks           []StockInfo `json:"stocks,omitempty"` // все полученные акции, для подбора по бюджету
 	RecommendedStock StockInfo   `json:"recommended_stock"`
 	MarketTrend      string      `json:"market_trend"` // "up", "down", "stable"
 	MarketNews       []NewsItem  `json:"market_news"`
+	Session          SessionInfo `json:"session"`
+	// Признаки того, что биржа не ответила и вместо данных подставлены заглушки:
+	// сверять с ними выпуск бессмысленно
+	StubIndices bool `json:"stub_indices,omitempty"`
+	StubStocks  bool `json:"stub_stocks,omitempty"`
 }
 
 // StockInfo содержит информацию об акции
@@ -31,9 +37,48 @@ type StockInfo struct {
 	Price     float64 `json:"price"`
 	Change    float64 `json:"change"` // изменение в процентах
 	Currency  string  `json:"currency"`
+	LotSize   int     `json:"lot_size,omitempty"` // бумаг в лоте, 0 - неизвестно
 	SourceURL string  `json:"source_url,omitempty"`
 }
 
+// LotCost возвращает стоимость одного лота; если размер лота неизвестен,
+// лотом считается одна бумага
+func (s StockInfo) LotCost() float64 {
+	if s.LotSize > 1 {
+		return s.Price * float64(s.LotSize)
+	}
+	return s.Price
+}
+
+// topStocksLimit сколько самых торгуемых акций передается модели
+const topStocksLimit = 5
+
+// ForBudget возвращает копию данных, в которой из самых торгуемых акций
+// остались только те, лот которых укладывается в бюджет, а рекомендуемая
+// акция выбрана среди них. Если не подходит ни одна акция, RecommendedStock пустая.
+func (d *MarketData) ForBudget(budget float64) *MarketData {
+	filtered := *d
+	candidates := d.Stocks
+	if len(candidates) == 0 {
+		candidates = d.TopStocks
+	}
+
+	filtered.TopStocks = nil
+	filtered.RecommendedStock = StockInfo{}
+	for _, stock := range candidates {
+		if stock.Price <= 0 || stock.LotCost() > budget {
+			continue
+		}
+		if filtered.RecommendedStock.Ticker == "" || stock.Change > filtered.RecommendedStock.Change {
+			filtered.RecommendedStock = stock
+		}
+		if len(filtered.TopStocks) < topStocksLimit {
+			filtered.TopStocks = append(filtered.TopStocks, stock)
+		}
+	}
+	return &filtered
+}
+
 // NewsItem содержит новость о рынке
 type NewsItem struct {
 	Title     string    `json:"title"`
@@ -45,20 +90,72 @@ type NewsItem struct {
 
 // MarketDataService предоставляет данные о рынке
 type MarketDataService struct {
-	client *http.Client
+	client   *http.Client
+	config   MarketDataConfig
+	calendar *TradingCalendar
+
+	// Последний снимок рынка, переиспользуется в течение config.SnapshotTTL
+	mu         sync.Mutex
+	snapshot   *MarketData
+	snapshotAt time.Time
+	pinned     bool // снимок закреплен методом Pin и не обновляется
 }
 
 // NewMarketDataService создает новый экземпляр MarketDataService
-func NewMarketDataService() *MarketDataService {
+func NewMarketDataService(config MarketDataConfig, calendar *TradingCalendar) *MarketDataService {
 	return &MarketDataService{
 		client: &http.Client{
 			Timeout: 10 * time.Second,
 		},
+		config:   config,
+		calendar: calendar,
 	}
 }
 
-// GetMarketData получает актуальные данные о рынке
+// GetMarketData возвращает актуальные данные о рынке. Снимок переиспользуется
+// в течение config.SnapshotTTL и не должен изменяться вызывающей стороной.
+// Снимки с заглушками вместо данных биржи не переиспользуются.
 func (s *MarketDataService) GetMarketData() (*MarketData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.snapshot != nil && (s.pinned || time.Since(s.snapshotAt) < s.config.SnapshotTTL) {
+		return s.snapshot, nil
+	}
+
+	data, err := s.fetchMarketData()
+	if err != nil {
+		return nil, err
+	}
+	s.snapshot, s.snapshotAt = nil, time.Time{}
+	if !data.StubIndices && !data.StubStocks {
+		s.snapshot, s.snapshotAt = data, time.Now()
+	}
+	return data, nil
+}
+
+// Invalidate сбрасывает сохраненный снимок рынка
+func (s *MarketDataService) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pinned {
+		return
+	}
+	s.snapshot, s.snapshotAt = nil, time.Time{}
+}
+
+// Pin закрепляет снимок рынка: GetMarketData всегда возвращает его без
+// запросов к бирже. Так выпуски генерируются по сохраненным снимкам (команда eval).
+func (s *MarketDataService) Pin(data *MarketData) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.snapshot, s.snapshotAt, s.pinned = data, time.Now(), true
+}
+
+// fetchMarketData запрашивает данные о рынке у источников
+func (s *MarketDataService) fetchMarketData() (*MarketData, error) {
 	// Получаем данные Московской биржи
 	moexData, err := s.getMOEXData()
 	if err != nil {
@@ -70,6 +167,7 @@ func (s *MarketDataService) GetMarketData() (*MarketData, error) {
 			USDRate:     90.5,   // Заглушка
 			EURRate:     98.7,   // Заглушка
 			MarketTrend: "stable",
+			StubIndices: true,
 		}
 	}
 
@@ -93,7 +191,12 @@ func (s *MarketDataService) GetMarketData() (*MarketData, error) {
 			{Ticker: "LKOH", Name: "Лукойл", Price: 7046.5, Change: -0.35, Currency: "RUB"},
 		}
 	}
+	moexData.Stocks = stocks
 	moexData.TopStocks = stocks
+	if len(stocks) > topStocksLimit {
+		moexData.TopStocks = stocks[:topStocksLimit]
+	}
+	moexData.StubStocks = err != nil
 
 	// Определяем рекомендуемую акцию (пример, в реальности нужен анализ)
 	if len(stocks) > 0 {
@@ -116,6 +219,9 @@ func (s *MarketDataService) GetMarketData() (*MarketData, error) {
 		}
 	}
 
+	// Состояние торговой сессии, чтобы AI не выдавал выходные цены за сегодняшние
+	moexData.Session = s.calendar.Session(time.Now())
+
 	return moexData, nil
 }
 
@@ -133,7 +239,7 @@ type MoexAPIResponse struct {
 // getMOEXData получает данные с Мосбиржи
 func (s *MarketDataService) getMOEXData() (*MarketData, error) {
 	// URL API Московской Биржи для получения информации по индексам
-	moexURL := "https://iss.moex.com/iss/engines/stock/markets/index/securities.json?iss.meta=off&iss.only=securities,marketdata"
+	moexURL := s.config.ISSBaseURL + "/engines/stock/markets/index/securities.json?iss.meta=off&iss.only=securities,marketdata"
 
 	resp, err := s.client.Get(moexURL)
 	if err != nil {
@@ -205,7 +311,7 @@ func (s *MarketDataService) getMOEXData() (*MarketData, error) {
 	}
 
 	// Получаем курсы валют
-	currencyURL := "https://iss.moex.com/iss/statistics/engines/currency/markets/selt/rates.json?iss.meta=off"
+	currencyURL := s.config.ISSBaseURL + "/statistics/engines/currency/markets/selt/rates.json?iss.meta=off"
 	currResp, err := s.client.Get(currencyURL)
 	if err != nil {
 		log.Printf("Ошибка при запросе к MOEX API для валют: %v", err)
@@ -248,7 +354,7 @@ func (s *MarketDataService) getMOEXData() (*MarketData, error) {
 // getTopStocks получает информацию о топовых акциях
 func (s *MarketDataService) getTopStocks() ([]StockInfo, error) {
 	// URL для получения данных о торгуемых акциях
-	stocksURL := "https://iss.moex.com/iss/engines/stock/markets/shares/securities.json?iss.meta=off&iss.only=securities,marketdata&sort_column=VALTODAY&sort_order=desc&limit=20"
+	stocksURL := s.config.ISSBaseURL + "/engines/stock/markets/shares/securities.json?iss.meta=off&iss.only=securities,marketdata&sort_column=VALTODAY&sort_order=desc&limit=20"
 
 	resp, err := s.client.Get(stocksURL)
 	if err != nil {
@@ -298,7 +404,7 @@ func (s *MarketDataService) getTopStocks() ([]StockInfo, error) {
 	}
 
 	// Определяем индексы нужных столбцов
-	var tickerIdx, shortNameIdx, boardIdIdx int = -1, -1, -1
+	var tickerIdx, shortNameIdx, boardIdIdx, lotSizeIdx int = -1, -1, -1, -1
 	for i, col := range columns {
 		colName, ok := col.(string)
 		if !ok {
@@ -311,6 +417,8 @@ func (s *MarketDataService) getTopStocks() ([]StockInfo, error) {
 			shortNameIdx = i
 		case "BOARDID":
 			boardIdIdx = i
+		case "LOTSIZE":
+			lotSizeIdx = i
 		}
 	}
 
@@ -336,6 +444,7 @@ func (s *MarketDataService) getTopStocks() ([]StockInfo, error) {
 	// Собираем акции
 	stocks := []StockInfo{}
 	stockMap := make(map[string]*StockInfo) // используем для объединения данных по одному тикеру
+	var order []string                      // тикеры в порядке ответа биржи
 
 	// Сначала заполняем информацию из securities
 	for i, sec := range secData {
@@ -360,6 +469,13 @@ func (s *MarketDataService) getTopStocks() ([]StockInfo, error) {
 			name = ticker
 		}
 
+		var lotSize int
+		if lotSizeIdx >= 0 && lotSizeIdx < len(secArray) {
+			if lotVal, ok := secArray[lotSizeIdx].(float64); ok {
+				lotSize = int(lotVal)
+			}
+		}
+
 		// Ищем соответствующие данные маркетдаты
 		if i < len(mdData) {
 			mdArray, ok := mdData[i].([]interface{})
@@ -397,15 +513,19 @@ func (s *MarketDataService) getTopStocks() ([]StockInfo, error) {
 				Price:    price,
 				Change:   change,
 				Currency: "RUB",
+				LotSize:  lotSize,
 			}
 
+			if _, seen := stockMap[ticker]; !seen {
+				order = append(order, ticker)
+			}
 			stockMap[ticker] = &stock
 		}
 	}
 
-	// Преобразуем map в slice
-	for _, stock := range stockMap {
-		stocks = append(stocks, *stock)
+	// Преобразуем map в slice, сохраняя порядок по обороту
+	for _, ticker := range order {
+		stocks = append(stocks, *stockMap[ticker])
 	}
 
 	// Если мы не получили никаких акций, возвращаем ошибку
@@ -413,11 +533,6 @@ func (s *MarketDataService) getTopStocks() ([]StockInfo, error) {
 		return nil, fmt.Errorf("не удалось получить информацию об акциях")
 	}
 
-	// Ограничиваем список до 5 акций
-	if len(stocks) > 5 {
-		stocks = stocks[:5]
-	}
-
 	return stocks, nil
 }
 
@@ -449,7 +564,7 @@ func (s *MarketDataService) getMarketNews() ([]NewsItem, error) {
 	}
 
 	// Пытаемся получить реальные новости
-	newsAPI := os.Getenv("NEWS_API_KEY")
+	newsAPI := s.config.NewsAPIKey
 	if newsAPI != "" {
 		realNews, err := s.fetchNewsFromAPI(newsAPI)
 		if err == nil && len(realNews) > 0 {
@@ -457,7 +572,7 @@ func (s *MarketDataService) getMarketNews() ([]NewsItem, error) {
 		}
 	}
 
-	gAPI := os.Getenv("NEWS_G_API_KEY")
+	gAPI := s.config.GNewsAPIKey
 	if gAPI != "" {
 		realNews, err := s.fetchGNewsFromAPI(gAPI)
 		if err == nil && len(realNews) > 0 {
@@ -584,6 +699,13 @@ func (s *MarketDataService) fetchNewsFromAPI(apiKey string) ([]NewsItem, error)
 func (s *MarketDataService) FormatMarketDataForAI(data *MarketData) string {
 	var sb strings.Builder
 
+	// Торговая сессия
+	sb.WriteString(fmt.Sprintf("🕒 ТОРГОВАЯ СЕССИЯ (%s): %s\n", data.Session.Date, data.Session.Describe()))
+	if !data.Session.IsOpen() {
+		sb.WriteString(fmt.Sprintf("- Цены ниже - по итогам последних торгов (%s)\n", data.Session.LastTradingDay))
+	}
+	sb.WriteString("\n")
+
 	// Индексы и курсы валют
 	sb.WriteString(fmt.Sprintf("📊 ИНДЕКСЫ:\n"))
 	sb.WriteString(fmt.Sprintf("- Индекс Мосбиржи: %.2f\n", data.IndexMOEX))
@@ -603,22 +725,33 @@ func (s *MarketDataService) FormatMarketDataForAI(data *MarketData) string {
 		} else {
 			change = fmt.Sprintf("%.2f%%", stock.Change)
 		}
-		sb.WriteString(fmt.Sprintf("- %s (%s): %.2f %s (%s)\n",
-			stock.Name, stock.Ticker, stock.Price, stock.Currency, change))
+		lot := ""
+		if stock.LotSize > 0 {
+			lot = fmt.Sprintf(", лот %d шт. = %.2f %s", stock.LotSize, stock.LotCost(), stock.Currency)
+		}
+		sb.WriteString(fmt.Sprintf("- %s (%s): %.2f %s (%s)%s\n",
+			stock.Name, stock.Ticker, stock.Price, stock.Currency, change, lot))
+	}
+	if len(data.TopStocks) == 0 {
+		sb.WriteString("- Ни одна из самых торгуемых акций не укладывается в бюджет\n")
 	}
 	sb.WriteString("\n")
 
 	// Рекомендуемая акция
 	sb.WriteString("💎 РЕКОМЕНДАЦИЯ:\n")
-	sb.WriteString(fmt.Sprintf("- %s (%s): %.2f %s (изменение: %.2f%%)\n\n",
-		data.RecommendedStock.Name, data.RecommendedStock.Ticker,
-		data.RecommendedStock.Price, data.RecommendedStock.Currency,
-		data.RecommendedStock.Change))
+	if data.RecommendedStock.Ticker != "" {
+		sb.WriteString(fmt.Sprintf("- %s (%s): %.2f %s (изменение: %.2f%%)\n\n",
+			data.RecommendedStock.Name, data.RecommendedStock.Ticker,
+			data.RecommendedStock.Price, data.RecommendedStock.Currency,
+			data.RecommendedStock.Change))
+	} else {
+		sb.WriteString("- Подходящей по бюджету акции нет, рассмотри фонд, облигацию или вклад\n\n")
+	}
 
 	// Новости рынка
 	sb.WriteString("📰 ПОСЛЕДНИЕ НОВОСТИ:\n")
 	for _, news := range data.MarketNews {
-		sb.WriteString(fmt.Sprintf("- %s %s (Источник: %s, %s)\n",
+		sb.WriteString(fmt.Sprintf("- %s (Источник: %s, %s)\n",
 			news.Title, news.Source, news.Timestamp.Format("02.01.2006")))
 	}
 	println(sb.String())
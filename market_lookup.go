@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Данные, которые модель запрашивает сама через инструменты: котировка,
+// свечи и дивиденды одной бумаги из ISS Мосбиржи и поиск новостей
+
+// maxCandles больше свечей модели не передается, чтобы не раздувать запрос
+const maxCandles = 60
+
+// tickerPattern допустимый тикер Мосбиржи
+var tickerPattern = regexp.MustCompile(`^[A-Z0-9]{1,12}$`)
+
+// Quote котировка акции на основном режиме торгов TQBR
+type Quote struct {
+	StockInfo
+	Open       float64 `json:"open,omitempty"`
+	High       float64 `json:"high,omitempty"`
+	Low        float64 `json:"low,omitempty"`
+	PrevClose  float64 `json:"prev_close,omitempty"`
+	ValueToday float64 `json:"value_today,omitempty"` // оборот за день в рублях
+	Time       string  `json:"time,omitempty"`        // время последней сделки
+}
+
+// Candle свеча за период
+type Candle struct {
+	Begin  string  `json:"begin"`
+	Open   float64 `json:"open"`
+	Close  float64 `json:"close"`
+	High   float64 `json:"high"`
+	Low    float64 `json:"low"`
+	Volume float64 `json:"volume"`
+}
+
+// Dividend выплата дивидендов
+type Dividend struct {
+	RegistryCloseDate string  `json:"registry_close_date"` // дата закрытия реестра
+	Value             float64 `json:"value"`               // на одну акцию
+	Currency          string  `json:"currency"`
+}
+
+// candlePeriod интервал свечей ISS и глубина истории для периода
+type candlePeriod struct {
+	interval int // 60 - час, 24 - день, 7 - неделя
+	days     int
+	limit    int // сколько последних свечей оставить, 0 - maxCandles
+}
+
+// candlePeriods периоды, за которые можно запросить свечи
+var candlePeriods = map[string]candlePeriod{
+	"day":     {interval: 60, days: 4, limit: 12},
+	"week":    {interval: 24, days: 7},
+	"month":   {interval: 24, days: 31},
+	"quarter": {interval: 7, days: 92},
+	"year":    {interval: 7, days: 365},
+}
+
+// issTable таблица ответа ISS: названия столбцов и строки значений
+type issTable struct {
+	Columns []string        `json:"columns"`
+	Data    [][]interface{} `json:"data"`
+}
+
+// rows возвращает строки таблицы как словари по названию столбца
+func (t issTable) rows() []map[string]interface{} {
+	rows := make([]map[string]interface{}, 0, len(t.Data))
+	for _, values := range t.Data {
+		row := make(map[string]interface{}, len(t.Columns))
+		for i, column := range t.Columns {
+			if i < len(values) {
+				row[column] = values[i]
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// issFloat возвращает число из ячейки ISS, которое может прийти числом или строкой
+func issFloat(value interface{}) float64 {
+	switch v := value.(type) {
+	case float64:
+		return v
+	case string:
+		f, _ := strconv.ParseFloat(v, 64)
+		return f
+	}
+	return 0
+}
+
+// issString возвращает строку из ячейки ISS
+func issString(value interface{}) string {
+	s, _ := value.(string)
+	return s
+}
+
+// normalizeTicker приводит тикер к верхнему регистру и проверяет его
+func normalizeTicker(ticker string) (string, error) {
+	ticker = strings.ToUpper(strings.TrimSpace(ticker))
+	if !tickerPattern.MatchString(ticker) {
+		return "", fmt.Errorf("некорректный тикер %q", ticker)
+	}
+	return ticker, nil
+}
+
+// GetQuote возвращает текущую котировку акции ticker
+func (s *MarketDataService) GetQuote(ctx context.Context, ticker string) (*Quote, error) {
+	ticker, err := normalizeTicker(ticker)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Securities issTable `json:"securities"`
+		Marketdata issTable `json:"marketdata"`
+	}
+	quoteURL := s.config.ISSBaseURL + "/engines/stock/markets/shares/boards/TQBR/securities/" + ticker +
+		".json?iss.meta=off&iss.only=securities,marketdata"
+	if err := s.getISS(ctx, quoteURL, &resp); err != nil {
+		return nil, err
+	}
+
+	securities, marketdata := resp.Securities.rows(), resp.Marketdata.rows()
+	if len(securities) == 0 || len(marketdata) == 0 {
+		return nil, fmt.Errorf("акция %s не найдена на Мосбирже", ticker)
+	}
+	sec, md := securities[0], marketdata[0]
+
+	quote := &Quote{
+		StockInfo: StockInfo{
+			Ticker:    ticker,
+			Name:      issString(sec["SHORTNAME"]),
+			Price:     issFloat(md["LAST"]),
+			Change:    issFloat(md["LASTTOPREVPRICE"]),
+			Currency:  "RUB",
+			LotSize:   int(issFloat(sec["LOTSIZE"])),
+			SourceURL: "https://www.moex.com/ru/issue.aspx?code=" + ticker,
+		},
+		Open:       issFloat(md["OPEN"]),
+		High:       issFloat(md["HIGH"]),
+		Low:        issFloat(md["LOW"]),
+		PrevClose:  issFloat(sec["PREVPRICE"]),
+		ValueToday: issFloat(md["VALTODAY"]),
+		Time:       issString(md["UPDATETIME"]),
+	}
+	if quote.Price == 0 {
+		// До первой сделки дня цены нет, берем цену закрытия прошлой сессии
+		quote.Price = quote.PrevClose
+	}
+	return quote, nil
+}
+
+// GetCandles возвращает свечи акции ticker за период: day (часовые свечи),
+// week и month (дневные), quarter и year (недельные)
+func (s *MarketDataService) GetCandles(ctx context.Context, ticker, period string) ([]Candle, error) {
+	ticker, err := normalizeTicker(ticker)
+	if err != nil {
+		return nil, err
+	}
+	p, ok := candlePeriods[period]
+	if !ok {
+		return nil, fmt.Errorf("неизвестный период %q, допустимы day, week, month, quarter, year", period)
+	}
+
+	var resp struct {
+		Candles issTable `json:"candles"`
+	}
+	from := time.Now().AddDate(0, 0, -p.days).Format("2006-01-02")
+	candlesURL := fmt.Sprintf("%s/engines/stock/markets/shares/boards/TQBR/securities/%s/candles.json?iss.meta=off&interval=%d&from=%s",
+		s.config.ISSBaseURL, ticker, p.interval, from)
+	if err := s.getISS(ctx, candlesURL, &resp); err != nil {
+		return nil, err
+	}
+
+	var candles []Candle
+	for _, row := range resp.Candles.rows() {
+		candles = append(candles, Candle{
+			Begin:  issString(row["begin"]),
+			Open:   issFloat(row["open"]),
+			Close:  issFloat(row["close"]),
+			High:   issFloat(row["high"]),
+			Low:    issFloat(row["low"]),
+			Volume: issFloat(row["volume"]),
+		})
+	}
+	if len(candles) == 0 {
+		return nil, fmt.Errorf("нет свечей %s за период %s", ticker, period)
+	}
+
+	limit := p.limit
+	if limit == 0 {
+		limit = maxCandles
+	}
+	if len(candles) > limit {
+		candles = candles[len(candles)-limit:]
+	}
+	return candles, nil
+}
+
+// GetDividends возвращает историю дивидендов акции ticker
+func (s *MarketDataService) GetDividends(ctx context.Context, ticker string) ([]Dividend, error) {
+	ticker, err := normalizeTicker(ticker)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Dividends issTable `json:"dividends"`
+	}
+	if err := s.getISS(ctx, s.config.ISSBaseURL+"/securities/"+ticker+"/dividends.json?iss.meta=off", &resp); err != nil {
+		return nil, err
+	}
+
+	var dividends []Dividend
+	for _, row := range resp.Dividends.rows() {
+		dividends = append(dividends, Dividend{
+			RegistryCloseDate: issString(row["registryclosedate"]),
+			Value:             issFloat(row["value"]),
+			Currency:          issString(row["currencyid"]),
+		})
+	}
+	return dividends, nil
+}
+
+// SearchNews ищет новости по запросу query через NewsAPI или GNews,
+// в зависимости от того, какой ключ задан
+func (s *MarketDataService) SearchNews(ctx context.Context, query string) ([]NewsItem, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, fmt.Errorf("пустой запрос")
+	}
+
+	switch {
+	case s.config.NewsAPIKey != "":
+		var resp struct {
+			Status   string `json:"status"`
+			Message  string `json:"message"`
+			Articles []struct {
+				Title       string `json:"title"`
+				Description string `json:"description"`
+				URL         string `json:"url"`
+				PublishedAt string `json:"publishedAt"`
+				Source      struct {
+					Name string `json:"name"`
+				} `json:"source"`
+			} `json:"articles"`
+		}
+		newsURL := fmt.Sprintf("https://newsapi.org/v2/everything?q=%s&language=ru&sortBy=publishedAt&pageSize=5&apiKey=%s",
+			url.QueryEscape(query), s.config.NewsAPIKey)
+		if err := s.getJSON(ctx, newsURL, &resp); err != nil {
+			return nil, err
+		}
+		if resp.Status != "ok" {
+			return nil, fmt.Errorf("ошибка News API: %s", resp.Message)
+		}
+
+		var news []NewsItem
+		for _, article := range resp.Articles {
+			published, _ := time.Parse(time.RFC3339, article.PublishedAt)
+			news = append(news, NewsItem{
+				Title:     article.Title,
+				Content:   article.Description,
+				Source:    article.Source.Name,
+				URL:       article.URL,
+				Timestamp: published,
+			})
+		}
+		return news, nil
+
+	case s.config.GNewsAPIKey != "":
+		var resp NewsGApi
+		newsURL := fmt.Sprintf("https://gnews.io/api/v4/search?q=%s&lang=ru&max=5&apikey=%s",
+			url.QueryEscape(query), s.config.GNewsAPIKey)
+		if err := s.getJSON(ctx, newsURL, &resp); err != nil {
+			return nil, err
+		}
+
+		var news []NewsItem
+		for _, article := range resp.Articles {
+			news = append(news, NewsItem{
+				Title:     article.Title,
+				Content:   article.Description,
+				Source:    article.Source.Name,
+				URL:       article.Url,
+				Timestamp: article.PublishedAt,
+			})
+		}
+		return news, nil
+	}
+	return nil, fmt.Errorf("поиск новостей недоступен: не задан ключ NEWS_API_KEY или NEWS_G_API_KEY")
+}
+
+// getISS выполняет запрос к ISS Мосбиржи
+func (s *MarketDataService) getISS(ctx context.Context, issURL string, result interface{}) error {
+	if err := s.getJSON(ctx, issURL, result); err != nil {
+		return fmt.Errorf("ошибка при запросе к MOEX API: %w", err)
+	}
+	return nil
+}
+
+// getJSON выполняет GET запрос и разбирает ответ в формате JSON
+func (s *MarketDataService) getJSON(ctx context.Context, rawURL string, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		// В адресе запроса к API новостей есть ключ, его нельзя показывать
+		// ни в логах, ни модели
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения ответа: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("код ответа %d", resp.StatusCode)
+	}
+	if err := json.Unmarshal(body, result); err != nil {
+		return fmt.Errorf("ошибка парсинга ответа: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Названия инструментов, которые может вызвать модель
+const (
+	ToolGetQuote     = "get_quote"
+	ToolGetCandles   = "get_candles"
+	ToolGetDividends = "get_dividends"
+	ToolSearchNews   = "search_news"
+)
+
+// toolsExhaustedNote просьба ответить без инструментов, когда лимит вызовов исчерпан
+const toolsExhaustedNote = "Лимит запросов данных исчерпан. Ответь на исходный запрос по уже полученным данным, не запрашивая новые."
+
+// MarketTools инструменты, которыми модель во время генерации сама запрашивает
+// у MarketDataService данные, не попавшие в промпт: котировку любой акции,
+// свечи, дивиденды и новости
+type MarketTools struct {
+	market *MarketDataService
+	config ToolsConfig
+}
+
+// NewMarketTools создает инструменты модели; если они отключены, возвращает nil
+func NewMarketTools(market *MarketDataService, config ToolsConfig) *MarketTools {
+	if !config.Enabled {
+		return nil
+	}
+	return &MarketTools{market: market, config: config}
+}
+
+// Definitions возвращает описания инструментов для провайдера
+func (t *MarketTools) Definitions() []ToolDefinition {
+	if t == nil {
+		return nil
+	}
+
+	ticker := map[string]interface{}{
+		"type":        "string",
+		"description": "Тикер акции на Мосбирже, например SBER",
+	}
+	return []ToolDefinition{
+		{
+			Name:        ToolGetQuote,
+			Description: "Текущая котировка акции Мосбиржи: цена, изменение за день в процентах, лот, цены открытия, максимум и минимум, оборот",
+			Parameters: map[string]interface{}{
+				"type":       "object",
+				"properties": map[string]interface{}{"ticker": ticker},
+				"required":   []string{"ticker"},
+			},
+		},
+		{
+			Name:        ToolGetCandles,
+			Description: "Свечи акции за период: day - часовые за последние торговые часы, week и month - дневные, quarter и year - недельные",
+			Parameters: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"ticker": ticker,
+					"period": map[string]interface{}{
+						"type": "string",
+						"enum": []string{"day", "week", "month", "quarter", "year"},
+					},
+				},
+				"required": []string{"ticker", "period"},
+			},
+		},
+		{
+			Name:        ToolGetDividends,
+			Description: "История дивидендов акции: даты закрытия реестра и размер выплаты на акцию",
+			Parameters: map[string]interface{}{
+				"type":       "object",
+				"properties": map[string]interface{}{"ticker": ticker},
+				"required":   []string{"ticker"},
+			},
+		},
+		{
+			Name:        ToolSearchNews,
+			Description: "Поиск свежих новостей по запросу, например по названию компании",
+			Parameters: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"query": map[string]interface{}{
+						"type":        "string",
+						"description": "Поисковый запрос на русском языке",
+					},
+				},
+				"required": []string{"query"},
+			},
+		},
+	}
+}
+
+// Execute выполняет вызов инструмента и возвращает результат в формате JSON.
+// Ошибка тоже возвращается модели текстом, чтобы она могла обойтись без данных.
+func (t *MarketTools) Execute(ctx context.Context, call ToolCall) string {
+	ctx, cancel := context.WithTimeout(ctx, t.config.CallTimeout)
+	defer cancel()
+
+	started := time.Now()
+	result, err := t.execute(ctx, call)
+	if err == nil {
+		var data []byte
+		if data, err = json.Marshal(result); err == nil {
+			log.Printf("Инструмент %s(%s) выполнен за %s", call.Name, call.Arguments, time.Since(started).Round(time.Millisecond))
+			return string(data)
+		}
+	}
+
+	log.Printf("Ошибка инструмента %s(%s): %v", call.Name, call.Arguments, err)
+	data, _ := json.Marshal(map[string]string{"error": err.Error()})
+	return string(data)
+}
+
+// execute разбирает аргументы и запрашивает данные у MarketDataService
+func (t *MarketTools) execute(ctx context.Context, call ToolCall) (interface{}, error) {
+	var args struct {
+		Ticker string `json:"ticker"`
+		Period string `json:"period"`
+		Query  string `json:"query"`
+	}
+	if call.Arguments != "" {
+		if err := json.Unmarshal([]byte(call.Arguments), &args); err != nil {
+			return nil, fmt.Errorf("некорректные аргументы: %w", err)
+		}
+	}
+
+	switch call.Name {
+	case ToolGetQuote:
+		return t.market.GetQuote(ctx, args.Ticker)
+	case ToolGetCandles:
+		return t.market.GetCandles(ctx, args.Ticker, args.Period)
+	case ToolGetDividends:
+		return t.market.GetDividends(ctx, args.Ticker)
+	case ToolSearchNews:
+		return t.market.SearchNews(ctx, args.Query)
+	}
+	return nil, fmt.Errorf("неизвестный инструмент %q", call.Name)
+}
+
+// complete генерирует ответ, разрешая модели вызывать инструменты. Вызовы
+// выполняются, а результаты возвращаются модели, пока она не ответит текстом.
+// После MaxCalls вызовов или TotalTimeout на их выполнение модель отвечает по
+// уже полученным данным. Каждый ход генерируется потоком с onText: текст
+// хода, закончившегося вызовом инструментов, заменяется текстом следующего.
+func (s *AIService) complete(ctx context.Context, provider LLMProvider, req CompletionRequest, onText StreamFunc) (*Completion, error) {
+	if s.tools == nil {
+		return streamCompletion(ctx, provider, req, onText)
+	}
+
+	messages := append([]AIMessage(nil), req.Messages...)
+	calls := 0
+	var spent time.Duration
+	for {
+		toolReq := req
+		toolReq.Messages = messages
+		toolReq.Tools = s.tools.Definitions()
+		completion, err := streamCompletion(ctx, provider, toolReq, onText)
+		if err != nil {
+			return nil, err
+		}
+		if len(completion.ToolCalls) == 0 {
+			return completion, nil
+		}
+
+		if calls+len(completion.ToolCalls) > s.tools.config.MaxCalls || spent >= s.tools.config.TotalTimeout {
+			log.Printf("Лимит инструментов исчерпан (%d вызовов, %s), ответ без новых данных", calls, spent.Round(time.Millisecond))
+			break
+		}
+
+		messages = append(messages, AIMessage{Role: "assistant", Content: completion.Text, ToolCalls: completion.ToolCalls})
+		for _, call := range completion.ToolCalls {
+			started := time.Now()
+			callCtx, cancel := context.WithTimeout(ctx, s.tools.config.TotalTimeout-spent)
+			result := s.tools.Execute(callCtx, call)
+			cancel()
+			spent += time.Since(started)
+			calls++
+			messages = append(messages, AIMessage{Role: "tool", Content: result, ToolCallID: call.ID})
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Полученные данные передаются текстом: без описаний инструментов
+	// провайдеры не принимают историю с их вызовами
+	finalReq := req
+	finalReq.Messages = plainMessages(append(messages, AIMessage{Role: "user", Content: toolsExhaustedNote}))
+	return streamCompletion(ctx, provider, finalReq, onText)
+}
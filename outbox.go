@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Ограничения исходящих сообщений
+const (
+	outboxWorkers     = 4                // одновременных запросов к Telegram при рассылке
+	outboxBaseBackoff = time.Second      // пауза перед первым повтором при временной ошибке
+	outboxMaxBackoff  = 30 * time.Second // максимальная пауза между повторами
+	privateChatRate   = 1.0              // сообщений в секунду в личный чат
+	chatBucketIdle    = 2 * time.Minute  // через сколько простоя лимит чата забывается
+	chatBucketsPrune  = 1000             // размер таблицы лимитов, после которого она чистится
+	maxReportErrors   = 5                // ошибок в отчете о рассылке
+	maxDeliveryReport = 20               // отчетов о рассылках в памяти
+	floodPauseLogMin  = 5 * time.Second  // паузы короче не логируются
+)
+
+// TokenBucket ограничивает частоту событий: rate событий в секунду с запасом burst
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket создает заполненный ограничитель
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Wait ждет свободный токен или отмену ctx
+func (b *TokenBucket) Wait(ctx context.Context) error {
+	for {
+		wait := b.take(time.Now())
+		if wait == 0 {
+			return nil
+		}
+		if err := sleepContext(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// take пополняет запас на момент now и забирает токен. Если токена нет,
+// возвращает, сколько ждать до следующего.
+func (b *TokenBucket) take(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if now.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration(math.Ceil((1 - b.tokens) / b.rate * float64(time.Second)))
+}
+
+// idle сообщает, что ограничитель давно не использовался и снова заполнен
+func (b *TokenBucket) idle(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return now.Sub(b.last) > chatBucketIdle
+}
+
+// OutboundMessage исходящее текстовое сообщение
+type OutboundMessage struct {
+	ChatID    int64
+	Text      string
+	ParseMode string
+}
+
+// SendResult результат отправки одного сообщения
+type SendResult struct {
+	ChatID      int64
+	Attempts    int  // запросов к Telegram
+	RateLimited int  // сколько раз Telegram ответил 429
+	Blocked     bool // пользователь заблокировал бота или чат недоступен
+	Err         error
+}
+
+// Outbox отправляет сообщения с учетом лимитов Telegram: общий лимит бота,
+// лимит на группу (20 сообщений в минуту) и на личный чат (1 сообщение в секунду).
+// Ответ 429 приостанавливает все отправки на retry_after, временные ошибки
+// повторяются с экспоненциальной паузой.
+type Outbox struct {
+	bot       *tgbotapi.BotAPI
+	global    *TokenBucket
+	groupRate float64 // сообщений в секунду в группу
+	retries   int
+
+	mu          sync.Mutex
+	chats       map[int64]*TokenBucket
+	pausedUntil time.Time
+}
+
+// NewOutbox создает очередь исходящих сообщений. globalRate - сообщений в секунду
+// всего, groupRate - сообщений в минуту в одну группу, retries - повторов после
+// неудачной попытки.
+func NewOutbox(bot *tgbotapi.BotAPI, globalRate, groupRate, retries int) *Outbox {
+	return &Outbox{
+		bot:       bot,
+		global:    NewTokenBucket(float64(globalRate), globalRate),
+		groupRate: float64(groupRate) / time.Minute.Seconds(),
+		retries:   retries,
+		chats:     make(map[int64]*TokenBucket),
+	}
+}
+
+// SendAll отправляет сообщения параллельно несколькими обработчиками и возвращает
+// результаты в порядке msgs. onResult вызывается сразу после отправки каждого
+// сообщения (из разных горутин). После отмены ctx оставшиеся сообщения не
+// отправляются, их результат содержит ошибку ctx.
+func (o *Outbox) SendAll(ctx context.Context, msgs []OutboundMessage, onResult func(SendResult)) []SendResult {
+	results := make([]SendResult, len(msgs))
+	next := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < outboxWorkers && w < len(msgs); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = o.Send(ctx, msgs[i])
+				if onResult != nil {
+					onResult(results[i])
+				}
+			}
+		}()
+	}
+
+	for i := range msgs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	return results
+}
+
+// Send отправляет одно сообщение, повторяя попытку при флуд-контроле и временных
+// ошибках. Если Telegram не разобрал разметку, сообщение один раз отправляется
+// простым текстом.
+func (o *Outbox) Send(ctx context.Context, msg OutboundMessage) SendResult {
+	result := SendResult{ChatID: msg.ChatID}
+	backoff := outboxBaseBackoff
+
+	for {
+		if err := o.wait(ctx, msg.ChatID); err != nil {
+			// Ожидание прервано остановкой: сообщение не отправлено из-за нее,
+			// а не из-за прошлой временной ошибки
+			result.Err = err
+			return result
+		}
+
+		request := tgbotapi.NewMessage(msg.ChatID, msg.Text)
+		request.ParseMode = msg.ParseMode
+
+		result.Attempts++
+		_, err := o.bot.Send(request)
+		result.Err = err
+		if err == nil {
+			return result
+		}
+
+		var apiErr *tgbotapi.Error
+		isAPIErr := errors.As(err, &apiErr)
+		if isAPIErr && isBlockedError(apiErr) {
+			result.Blocked = true
+			return result
+		}
+		if msg.ParseMode != "" && isMarkdownError(err) {
+			// Разметка сломана - отправляем тот же текст без нее, один раз
+			log.Printf("Telegram не разобрал разметку сообщения в чат %d, отправляем без нее: %v", msg.ChatID, err)
+			msg.ParseMode = ""
+			continue
+		}
+		if result.Attempts > o.retries {
+			return result
+		}
+
+		switch {
+		case isAPIErr && apiErr.RetryAfter > 0:
+			// Флуд-контроль: Telegram сам говорит, сколько ждать
+			result.RateLimited++
+			o.pause(time.Duration(apiErr.RetryAfter) * time.Second)
+		case !isAPIErr || apiErr.Code >= http.StatusInternalServerError:
+			// Сетевая ошибка или сбой на стороне Telegram
+			delay := jitter(backoff)
+			log.Printf("Временная ошибка отправки в чат %d (попытка %d), повтор через %s: %v",
+				msg.ChatID, result.Attempts, delay.Round(time.Millisecond), err)
+			if err := sleepContext(ctx, delay); err != nil {
+				result.Err = err
+				return result
+			}
+			backoff *= 2
+			if backoff > outboxMaxBackoff {
+				backoff = outboxMaxBackoff
+			}
+		default:
+			// Ошибка запроса (например, разметка) - повтор не поможет
+			return result
+		}
+	}
+}
+
+// wait ждет окончания паузы флуд-контроля и свободного места в лимитах бота и чата
+func (o *Outbox) wait(ctx context.Context, chatID int64) error {
+	o.mu.Lock()
+	pause := time.Until(o.pausedUntil)
+	bucket := o.chatBucketLocked(chatID)
+	o.mu.Unlock()
+
+	if pause > 0 {
+		if err := sleepContext(ctx, pause); err != nil {
+			return err
+		}
+	}
+	if err := bucket.Wait(ctx); err != nil {
+		return err
+	}
+	return o.global.Wait(ctx)
+}
+
+// pause приостанавливает все отправки на d
+func (o *Outbox) pause(d time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	until := time.Now().Add(d)
+	if until.After(o.pausedUntil) {
+		o.pausedUntil = until
+		if d >= floodPauseLogMin {
+			log.Printf("Флуд-контроль Telegram: отправка сообщений приостановлена на %s", d)
+		}
+	}
+}
+
+// chatBucketLocked возвращает ограничитель чата, создавая его при необходимости.
+// Группы имеют отрицательный ID. Вызывается под блокировкой o.mu.
+func (o *Outbox) chatBucketLocked(chatID int64) *TokenBucket {
+	if bucket, ok := o.chats[chatID]; ok {
+		return bucket
+	}
+
+	if len(o.chats) >= chatBucketsPrune {
+		now := time.Now()
+		for id, bucket := range o.chats {
+			if bucket.idle(now) {
+				delete(o.chats, id)
+			}
+		}
+	}
+
+	rate := privateChatRate
+	if chatID < 0 {
+		rate = o.groupRate
+	}
+	bucket := NewTokenBucket(rate, 1)
+	o.chats[chatID] = bucket
+	return bucket
+}
+
+// isBlockedError сообщает, что чат недоступен боту: пользователь заблокировал
+// бота, удалил аккаунт или бота исключили из группы
+func isBlockedError(err *tgbotapi.Error) bool {
+	if err.Code == http.StatusForbidden {
+		return true
+	}
+	return strings.Contains(err.Message, "bot was blocked") ||
+		strings.Contains(err.Message, "chat not found") ||
+		strings.Contains(err.Message, "user is deactivated")
+}
+
+// jitter возвращает случайную паузу от d/2 до d
+func jitter(d time.Duration) time.Duration {
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// sleepContext ждет d или отмену ctx
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// DeliveryReport итоги одной рассылки
+type DeliveryReport struct {
+	Job         string
+	Scheduled   time.Time
+	Started     time.Time
+	Finished    time.Time
+	Total       int
+	Delivered   int
+	Failed      int
+	Blocked     int // чаты, заблокировавшие бота
+	Interrupted int // не отправлено из-за остановки бота
+	Retries     int // повторных попыток
+	RateLimited int // ответов 429
+	Errors      []string
+}
+
+// NewDeliveryReport собирает отчет по результатам отправки
+func NewDeliveryReport(job string, scheduled, started time.Time, results []SendResult) DeliveryReport {
+	report := DeliveryReport{
+		Job:       job,
+		Scheduled: scheduled,
+		Started:   started,
+		Finished:  time.Now(),
+		Total:     len(results),
+	}
+
+	for _, r := range results {
+		if r.Attempts > 1 {
+			report.Retries += r.Attempts - 1
+		}
+		report.RateLimited += r.RateLimited
+
+		switch {
+		case r.Err == nil:
+			report.Delivered++
+		case errors.Is(r.Err, context.Canceled) || errors.Is(r.Err, context.DeadlineExceeded):
+			report.Interrupted++
+		case r.Blocked:
+			report.Blocked++
+		default:
+			report.Failed++
+			if len(report.Errors) < maxReportErrors {
+				report.Errors = append(report.Errors, fmt.Sprintf("чат %d: %v", r.ChatID, r.Err))
+			}
+		}
+	}
+
+	return report
+}
+
+// Summary возвращает однострочное описание отчета для лога и сообщений
+func (r DeliveryReport) Summary() string {
+	summary := fmt.Sprintf("%s: доставлено %d из %d за %s", r.Job, r.Delivered, r.Total,
+		r.Finished.Sub(r.Started).Round(time.Second))
+	if r.Failed > 0 {
+		summary += fmt.Sprintf(", ошибок %d", r.Failed)
+	}
+	if r.Blocked > 0 {
+		summary += fmt.Sprintf(", заблокировали бота %d", r.Blocked)
+	}
+	if r.Interrupted > 0 {
+		summary += fmt.Sprintf(", прервано %d", r.Interrupted)
+	}
+	if r.Retries > 0 {
+		summary += fmt.Sprintf(", повторов %d", r.Retries)
+	}
+	if r.RateLimited > 0 {
+		summary += fmt.Sprintf(", флуд-контроль %d", r.RateLimited)
+	}
+	return summary
+}
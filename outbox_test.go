@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestTokenBucketTake(t *testing.T) {
+	start := time.Date(2024, time.March, 11, 10, 0, 0, 0, time.UTC)
+
+	// call обращение к ограничителю через after после start
+	type call struct {
+		after    time.Duration
+		wantWait time.Duration // 0 - токен выдан
+	}
+
+	tests := []struct {
+		name  string
+		rate  float64
+		burst int
+		calls []call
+	}{
+		{
+			name:  "запас выдается сразу",
+			rate:  1,
+			burst: 3,
+			calls: []call{{0, 0}, {0, 0}, {0, 0}, {0, time.Second}},
+		},
+		{
+			name:  "после запаса - с частотой rate",
+			rate:  20,
+			burst: 1,
+			calls: []call{{0, 0}, {0, 50 * time.Millisecond}, {50 * time.Millisecond, 0}, {60 * time.Millisecond, 40 * time.Millisecond}},
+		},
+		{
+			name:  "частичное ожидание",
+			rate:  2,
+			burst: 1,
+			calls: []call{{0, 0}, {200 * time.Millisecond, 300 * time.Millisecond}, {500 * time.Millisecond, 0}},
+		},
+		{
+			name:  "запас не превышает burst",
+			rate:  1,
+			burst: 2,
+			calls: []call{{0, 0}, {0, 0}, {time.Hour, 0}, {time.Hour, 0}, {time.Hour, time.Second}},
+		},
+		{
+			name:  "редкий лимит",
+			rate:  0.25,
+			burst: 1,
+			calls: []call{{0, 0}, {time.Second, 3 * time.Second}, {4 * time.Second, 0}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bucket := NewTokenBucket(tt.rate, tt.burst)
+			bucket.last = start
+
+			for i, c := range tt.calls {
+				if wait := bucket.take(start.Add(c.after)); wait != c.wantWait {
+					t.Errorf("обращение %d через %s: ожидание %s, ожидалось %s", i+1, c.after, wait, c.wantWait)
+				}
+			}
+		})
+	}
+}
+
+func TestTokenBucketIdle(t *testing.T) {
+	start := time.Date(2024, time.March, 11, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		after time.Duration
+		want  bool
+	}{
+		{"недавно использовался", time.Minute, false},
+		{"на границе", chatBucketIdle, false},
+		{"давно не использовался", chatBucketIdle + time.Second, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bucket := NewTokenBucket(1, 1)
+			bucket.last = start
+			bucket.take(start)
+			if got := bucket.idle(start.Add(tt.after)); got != tt.want {
+				t.Errorf("idle() через %s = %v, ожидалось %v", tt.after, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenBucketWaitCancel(t *testing.T) {
+	bucket := NewTokenBucket(0.001, 1)
+	if err := bucket.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait() с запасом = %v", err)
+	}
+
+	// Следующий токен через 1000 секунд: Wait должен завершиться отменой ctx
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := bucket.Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait() без токенов = %v, ожидалось %v", err, context.Canceled)
+	}
+}
+
+func TestIsBlockedError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  tgbotapi.Error
+		want bool
+	}{
+		{"бот заблокирован", tgbotapi.Error{Code: http.StatusForbidden, Message: "Forbidden: bot was blocked by the user"}, true},
+		{"чат не найден", tgbotapi.Error{Code: http.StatusBadRequest, Message: "Bad Request: chat not found"}, true},
+		{"аккаунт удален", tgbotapi.Error{Code: http.StatusForbidden, Message: "Forbidden: user is deactivated"}, true},
+		{"ошибка разметки", tgbotapi.Error{Code: http.StatusBadRequest, Message: "Bad Request: can't parse entities"}, false},
+		{"флуд-контроль", tgbotapi.Error{Code: http.StatusTooManyRequests, Message: "Too Many Requests"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isBlockedError(&tt.err); got != tt.want {
+				t.Errorf("isBlockedError(%q) = %v, ожидалось %v", tt.err.Message, got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeTelegram отвечает на getMe и на каждую отправку сообщения ответом sendReply
+func fakeTelegram(t *testing.T, sendReply string) *tgbotapi.BotAPI {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/getMe") {
+			w.Write([]byte(`{"ok":true,"result":{"id":1,"is_bot":true,"username":"test_bot"}}`))
+			return
+		}
+		w.Write([]byte(sendReply))
+	}))
+	t.Cleanup(server.Close)
+
+	bot, err := tgbotapi.NewBotAPIWithClient("token", server.URL+"/bot%s/%s", server.Client())
+	if err != nil {
+		t.Fatalf("NewBotAPIWithClient: %v", err)
+	}
+	return bot
+}
+
+func TestOutboxSendInterrupted(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+	}{
+		{"пауза перед повтором", `{"ok":false,"error_code":502,"description":"Bad Gateway"}`},
+		{"флуд-контроль", `{"ok":false,"error_code":429,"description":"Too Many Requests","parameters":{"retry_after":60}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outbox := NewOutbox(fakeTelegram(t, tt.reply), 30, 20, 3)
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			result := outbox.Send(ctx, OutboundMessage{ChatID: 42, Text: "выпуск"})
+			if result.Attempts != 1 {
+				t.Errorf("попыток %d, ожидалась 1", result.Attempts)
+			}
+			// Прерванная отправка считается прерванной, а не неудачной
+			if !errors.Is(result.Err, context.DeadlineExceeded) {
+				t.Errorf("ошибка = %v, ожидалась ошибка контекста", result.Err)
+			}
+		})
+	}
+}
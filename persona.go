@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Persona стиль, в котором бот пишет выпуски для чата
+type Persona string
+
+const (
+	PersonaCute         Persona = "cute"         // милый, с кучей эмодзи
+	PersonaNeutral      Persona = "neutral"      // спокойный дружелюбный тон
+	PersonaProfessional Persona = "professional" // деловой стиль без эмодзи
+	PersonaBrief        Persona = "brief"        // коротко и по делу
+)
+
+// DefaultPersona стиль для чатов, которые его не выбирали
+const DefaultPersona = PersonaCute
+
+// PersonaStyle системный промпт и правила оформления выпуска в стиле
+type PersonaStyle struct {
+	Title       string // название для команды /style
+	Description string
+	Prompt      string // название шаблона системного промпта
+	Emoji       bool   // эмодзи в тексте и заголовках; false - удаляются из выпуска
+	Greeting    bool   // приветствие и прощание
+	SideHustle  bool   // совет по подработке
+}
+
+// personas стили в порядке вывода в команде /style
+var personas = []Persona{PersonaCute, PersonaNeutral, PersonaProfessional, PersonaBrief}
+
+// personaStyles настройки каждого стиля
+var personaStyles = map[Persona]PersonaStyle{
+	PersonaCute: {
+		Title:       "милый",
+		Description: "ласково и с кучей эмодзи 💖",
+		Prompt:      PromptSystem,
+		Emoji:       true,
+		Greeting:    true,
+		SideHustle:  true,
+	},
+	PersonaNeutral: {
+		Title:       "нейтральный",
+		Description: "спокойно и дружелюбно, без сюсюканья",
+		Prompt:      "system-neutral",
+		Emoji:       true,
+		Greeting:    true,
+		SideHustle:  true,
+	},
+	PersonaProfessional: {
+		Title:       "деловой",
+		Description: "как обзор аналитика, без эмодзи и приветствий",
+		Prompt:      "system-professional",
+		SideHustle:  true,
+	},
+	PersonaBrief: {
+		Title:       "кратко",
+		Description: "только рынок, рекомендация и почему",
+		Prompt:      "system-brief",
+		Emoji:       true,
+	},
+}
+
+// ParsePersona распознает стиль по названию на английском или русском
+func ParsePersona(s string) (Persona, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for _, p := range personas {
+		if s == string(p) || s == personaStyles[p].Title {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// OrDefault возвращает стиль или DefaultPersona, если стиль не выбран или неизвестен
+func (p Persona) OrDefault() Persona {
+	if _, ok := personaStyles[p]; ok {
+		return p
+	}
+	return DefaultPersona
+}
+
+// Style возвращает настройки стиля
+func (p Persona) Style() PersonaStyle {
+	return personaStyles[p.OrDefault()]
+}
+
+// formatPersonas описывает доступные стили и отмечает текущий
+func formatPersonas(current Persona) string {
+	var sb strings.Builder
+	sb.WriteString("Стиль выпусков:\n")
+	for _, p := range personas {
+		style := personaStyles[p]
+		mark := "  "
+		if p == current.OrDefault() {
+			mark = "✅"
+		}
+		sb.WriteString(fmt.Sprintf("\n%s %s (%s) - %s", mark, style.Title, p, style.Description))
+	}
+	sb.WriteString("\n\nВыбрать: /style деловой или /style professional")
+	return sb.String()
+}
+
+// stripEmoji удаляет эмодзи и лишние пробелы, оставшиеся на их месте
+func stripEmoji(text string) string {
+	var sb strings.Builder
+	for _, r := range text {
+		if isEmoji(r) {
+			continue
+		}
+		sb.WriteRune(r)
+	}
+
+	lines := strings.Split(sb.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.FieldsFunc(line, func(r rune) bool { return r == ' ' }), " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// isEmoji сообщает, что символ - эмодзи или его модификатор
+func isEmoji(r rune) bool {
+	switch {
+	case r >= 0x1F000 && r <= 0x1FAFF: // пиктограммы, смайлики, флаги
+		return true
+	case r >= 0x2600 && r <= 0x27BF: // символы и дингбаты: ☀ ✨ ❗
+		return true
+	case r >= 0x2B00 && r <= 0x2BFF: // стрелки и звезды: ⬆ ⭐
+		return true
+	case r == 0x200D || (r >= 0xFE00 && r <= 0xFE0F): // соединитель и вариации
+		return true
+	case r == 0x231A, r == 0x231B, r == 0x23F0, r == 0x23F3: // ⌚ ⏰ ⏳
+		return true
+	}
+	return false
+}
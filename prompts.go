@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// builtinPrompts шаблоны промптов, встроенные в бинарный файл
+//
+//go:embed prompts/*.tmpl
+var builtinPrompts embed.FS
+
+// Названия шаблонов промптов
+const (
+	PromptSystem    = "system"    // системный промпт стиля по умолчанию: роль и стиль модели
+	PromptAnalytics = "analytics" // запрос выпуска аналитики
+	PromptFeedback  = "feedback"  // ответ модели, если выпуск не прошел проверку
+	PromptChat      = "chat"      // системный промпт режима вопросов
+	PromptSummary   = "summary"   // краткое содержание длинного диалога
+)
+
+// requiredPrompts шаблоны, без которых бот не может работать: запрос выпуска,
+// ответ на ошибки, промпты режима вопросов и системные промпты всех стилей
+func requiredPrompts() []string {
+	names := []string{PromptAnalytics, PromptFeedback, PromptChat, PromptSummary}
+	for _, p := range personas {
+		names = append(names, personaStyles[p].Prompt)
+	}
+	return names
+}
+
+// promptFileName имя файла шаблона: <название>.v<версия>.tmpl
+var promptFileName = regexp.MustCompile(`^([a-z0-9_-]+)\.v(\d+)\.tmpl$`)
+
+// PromptData переменные, доступные в шаблонах промптов
+type PromptData struct {
+	Date       string                 // день выпуска, ГГГГ-ММ-ДД
+	Kind       AnalyticsKind          // вид выпуска
+	Persona    Persona                // стиль выпуска
+	Budget     float64                // сумма для вложения, в рублях
+	Session    SessionInfo            // состояние торговой сессии
+	Market     *MarketData            // данные о рынке, nil - недоступны
+	MarketText string                 // данные о рынке в виде текста для модели
+	History    []RecommendationRecord // рекомендации прошлых выпусков
+	Schema     string                 // описание формата ответа
+	Errors     string                 // ошибки проверки ответа (для feedback)
+	Summary    string                 // краткое содержание прошлой части диалога (для chat и summary)
+	Messages   []AIMessage            // сообщения диалога, которые нужно пересказать (для summary)
+}
+
+// promptTemplate одна версия шаблона
+type promptTemplate struct {
+	name    string
+	version int
+	source  string // "встроенный" или путь к файлу
+	tmpl    *template.Template
+}
+
+// PromptRegistry хранит именованные версии шаблонов промптов: встроенные
+// и из каталога настроек, который может переопределять встроенные версии
+// и добавлять новые. Для каждого шаблона используется закрепленная в
+// настройках версия, а если версия не закреплена - последняя.
+type PromptRegistry struct {
+	templates map[string][]*promptTemplate // версии по возрастанию
+	active    map[string]*promptTemplate
+}
+
+// promptFuncs функции, доступные в шаблонах промптов
+var promptFuncs = template.FuncMap{
+	"money": formatMoney,
+	"date":  formatReportDate,
+}
+
+// NewPromptRegistry загружает шаблоны промптов и выбирает активные версии
+func NewPromptRegistry(config PromptsConfig) (*PromptRegistry, error) {
+	r := &PromptRegistry{
+		templates: make(map[string][]*promptTemplate),
+		active:    make(map[string]*promptTemplate),
+	}
+
+	if err := r.load(builtinPrompts, "prompts", "встроенный"); err != nil {
+		return nil, err
+	}
+	if config.Dir != "" {
+		if err := r.load(os.DirFS(config.Dir), ".", config.Dir); err != nil {
+			return nil, err
+		}
+	}
+
+	var errs []error
+	for _, name := range requiredPrompts() {
+		if len(r.templates[name]) == 0 {
+			errs = append(errs, fmt.Errorf("нет шаблона промпта %s", name))
+		}
+	}
+	names := make([]string, 0, len(config.Versions))
+	for name := range config.Versions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if r.find(name, config.Versions[name]) == nil {
+			errs = append(errs, fmt.Errorf("нет версии %d шаблона промпта %s", config.Versions[name], name))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	for name, versions := range r.templates {
+		r.active[name] = versions[len(versions)-1]
+		if version, ok := config.Versions[name]; ok {
+			r.active[name] = r.find(name, version)
+		}
+	}
+	return r, nil
+}
+
+// load добавляет шаблоны из каталога dir файловой системы fsys, заменяя
+// версии, загруженные раньше
+func (r *PromptRegistry) load(fsys fs.FS, dir, source string) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения каталога шаблонов промптов %s: %w", source, err)
+	}
+
+	for _, entry := range entries {
+		match := promptFileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		name := match[1]
+		version, _ := strconv.Atoi(match[2])
+
+		content, err := fs.ReadFile(fsys, filepath.ToSlash(filepath.Join(dir, entry.Name())))
+		if err != nil {
+			return fmt.Errorf("ошибка чтения шаблона промпта %s: %w", entry.Name(), err)
+		}
+		tmpl, err := template.New(entry.Name()).Funcs(promptFuncs).Parse(string(content))
+		if err != nil {
+			return fmt.Errorf("ошибка разбора шаблона промпта %s (%s): %w", entry.Name(), source, err)
+		}
+
+		pt := &promptTemplate{name: name, version: version, source: source, tmpl: tmpl}
+		if source != "встроенный" {
+			pt.source = filepath.Join(source, entry.Name())
+		}
+		r.add(pt)
+	}
+	return nil
+}
+
+// add добавляет версию шаблона, сохраняя порядок версий
+func (r *PromptRegistry) add(pt *promptTemplate) {
+	versions := r.templates[pt.name]
+	i := sort.Search(len(versions), func(i int) bool { return versions[i].version >= pt.version })
+	if i < len(versions) && versions[i].version == pt.version {
+		versions[i] = pt
+		return
+	}
+	versions = append(versions, nil)
+	copy(versions[i+1:], versions[i:])
+	versions[i] = pt
+	r.templates[pt.name] = versions
+}
+
+// find возвращает версию version шаблона name или nil
+func (r *PromptRegistry) find(name string, version int) *promptTemplate {
+	for _, pt := range r.templates[name] {
+		if pt.version == version {
+			return pt
+		}
+	}
+	return nil
+}
+
+// Render заполняет активную версию шаблона name переменными data
+func (r *PromptRegistry) Render(name string, data PromptData) (string, error) {
+	pt, ok := r.active[name]
+	if !ok {
+		return "", fmt.Errorf("нет шаблона промпта %s", name)
+	}
+	return pt.render(data)
+}
+
+// render заполняет шаблон переменными data
+func (pt *promptTemplate) render(data PromptData) (string, error) {
+	var buf bytes.Buffer
+	if err := pt.tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("ошибка заполнения шаблона промпта %s.v%d: %w", pt.name, pt.version, err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// Versions возвращает активные версии шаблонов, например "analytics.v1, system.v3"
+func (r *PromptRegistry) Versions() string {
+	versions := make([]string, 0, len(r.active))
+	for name, pt := range r.active {
+		versions = append(versions, fmt.Sprintf("%s.v%d", name, pt.version))
+	}
+	sort.Strings(versions)
+	return strings.Join(versions, ", ")
+}
+
+// Check заполняет все версии всех шаблонов примерами переменных - с данными
+// о рынке и без них - и возвращает все ошибки сразу. Вызывается при запуске,
+// чтобы ошибка в шаблоне не обнаружилась только во время рассылки.
+func (r *PromptRegistry) Check() error {
+	samples := samplePromptData()
+
+	names := make([]string, 0, len(r.templates))
+	for name := range r.templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		for _, pt := range r.templates[name] {
+			for _, data := range samples {
+				text, err := pt.render(data)
+				if err == nil && text == "" {
+					err = fmt.Errorf("шаблон промпта %s.v%d (%s) пустой", pt.name, pt.version, pt.source)
+				}
+				if err != nil {
+					errs = append(errs, err)
+					break
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// samplePromptData примеры переменных для проверки шаблонов
+func samplePromptData() []PromptData {
+	market := &MarketData{
+		IndexMOEX:   3000,
+		IndexRTS:    1000,
+		USDRate:     90,
+		EURRate:     100,
+		TopStocks:   []StockInfo{{Ticker: "SBER", Name: "Сбербанк", Price: 300, Change: 1, Currency: "RUB"}},
+		MarketTrend: "stable",
+		Session:     SessionInfo{Date: "2025-01-15", TradingDay: true, Status: SessionMain},
+	}
+	market.RecommendedStock = market.TopStocks[0]
+
+	full := PromptData{
+		Date:       market.Session.Date,
+		Kind:       AnalyticsDaily,
+		Persona:    DefaultPersona,
+		Budget:     defaultBudget,
+		Session:    market.Session,
+		Market:     market,
+		MarketText: "📊 ИНДЕКСЫ:\n- Индекс Мосбиржи: 3000.00\n",
+		History: []RecommendationRecord{
+			{Date: "2025-01-14", Kind: AnalyticsDaily, Instrument: "Газпром", Type: InstrumentShare, Ticker: "GAZP", SideHustle: "кешбэк"},
+		},
+		Schema:  analyticsSchemaPrompt,
+		Errors:  "не заполнено поле market_summary",
+		Summary: "Инвестор спрашивал про Сбербанк, бот объяснил риски.",
+		Messages: []AIMessage{
+			{Role: "user", Content: "Стоит ли сейчас покупать Сбер?"},
+			{Role: "assistant", Content: "Сбербанк торгуется по 300 ₽..."},
+		},
+	}
+	empty := full
+	empty.Kind = AnalyticsRecap
+	empty.Persona = PersonaProfessional
+	empty.Session = SessionInfo{}
+	empty.Market = nil
+	empty.MarketText = ""
+	empty.History = nil
+	empty.Summary = ""
+	empty.Messages = nil
+
+	return []PromptData{full, empty}
+}
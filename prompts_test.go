@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPromptRegistryBuiltin(t *testing.T) {
+	registry, err := NewPromptRegistry(PromptsConfig{})
+	if err != nil {
+		t.Fatalf("NewPromptRegistry: %v", err)
+	}
+	if err := registry.Check(); err != nil {
+		t.Fatalf("встроенные шаблоны не прошли проверку: %v", err)
+	}
+	if versions := registry.Versions(); !strings.Contains(versions, "analytics.v2") || !strings.Contains(versions, "system.v3") {
+		t.Errorf("Versions = %s, ожидались последние версии analytics.v2 и system.v3", versions)
+	}
+
+	registry, err = NewPromptRegistry(PromptsConfig{Versions: map[string]int{PromptAnalytics: 1}})
+	if err != nil {
+		t.Fatalf("NewPromptRegistry: %v", err)
+	}
+	if versions := registry.Versions(); !strings.Contains(versions, "analytics.v1") {
+		t.Errorf("Versions = %s, ожидалась закрепленная версия analytics.v1", versions)
+	}
+
+	if _, err := NewPromptRegistry(PromptsConfig{Versions: map[string]int{PromptAnalytics: 9}}); err == nil {
+		t.Error("закрепление несуществующей версии не вернуло ошибку")
+	}
+}
+
+func TestPromptRegistryDir(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+	write("chat.v1.tmpl", "Переопределенный чат на {{money .Budget}} ₽")
+	write("feedback.v5.tmpl", "Исправь: {{.Errors}}")
+	write("notes.txt", "не шаблон")
+
+	registry, err := NewPromptRegistry(PromptsConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("NewPromptRegistry: %v", err)
+	}
+
+	text, err := registry.Render(PromptChat, PromptData{Budget: 10000})
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	if !strings.HasPrefix(text, "Переопределенный чат на 10") {
+		t.Errorf("Render(chat) = %q, ожидался шаблон из каталога", text)
+	}
+	text, err = registry.Render(PromptFeedback, PromptData{Errors: "нет даты"})
+	if err != nil || text != "Исправь: нет даты" {
+		t.Errorf("Render(feedback) = %q, %v, ожидалась новая версия из каталога", text, err)
+	}
+	if _, err := registry.Render("unknown", PromptData{}); err == nil {
+		t.Error("Render неизвестного шаблона не вернул ошибку")
+	}
+
+	// Ошибка в шаблоне находится при запуске, а не во время рассылки
+	write("summary.v2.tmpl", "{{.Market.IndexMOEX}}")
+	registry, err = NewPromptRegistry(PromptsConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("NewPromptRegistry: %v", err)
+	}
+	if err := registry.Check(); err == nil || !strings.Contains(err.Error(), "summary.v2") {
+		t.Errorf("Check = %v, ожидалась ошибка шаблона summary.v2", err)
+	}
+
+	write("system.v4.tmpl", "{{.Unknown")
+	if _, err := NewPromptRegistry(PromptsConfig{Dir: dir}); err == nil {
+		t.Error("шаблон с синтаксической ошибкой загружен без ошибки")
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// recommendationRetention сколько хранятся записи о рекомендациях
+const recommendationRetention = 365 * 24 * time.Hour
+
+// RecommendationRecord рекомендация и совет по подработке из отправленного выпуска
+type RecommendationRecord struct {
+	Date       string        `json:"date"` // день выпуска, ГГГГ-ММ-ДД
+	Kind       AnalyticsKind `json:"kind"`
+	Persona    Persona       `json:"persona,omitempty"` // стиль выпуска
+	Budget     float64       `json:"budget,omitempty"`  // бюджет выпуска в рублях
+	Instrument string        `json:"instrument"`
+	Type       string        `json:"type"`
+	Ticker     string        `json:"ticker,omitempty"`
+	SideHustle string        `json:"side_hustle"` // тема совета по подработке
+	CreatedAt  time.Time     `json:"created_at"`
+}
+
+// sameIssue проверяет, что записи относятся к одному выпуску: дню, виду,
+// стилю и бюджету
+func (r RecommendationRecord) sameIssue(other RecommendationRecord) bool {
+	return r.Date == other.Date && r.Kind == other.Kind && r.Persona == other.Persona && r.Budget == other.Budget
+}
+
+// RecommendationHistory помнит рекомендации прошлых выпусков, чтобы модель
+// их видела и не повторяла в течение windowDays дней
+type RecommendationHistory struct {
+	storage    Storage
+	windowDays int // 0 - повторы не проверяются
+}
+
+// NewRecommendationHistory создает историю рекомендаций
+func NewRecommendationHistory(storage Storage, windowDays int) *RecommendationHistory {
+	return &RecommendationHistory{storage: storage, windowDays: windowDays}
+}
+
+// Recent возвращает рекомендации выпусков вида kind за windowDays дней до date.
+// Выпуски сравниваются только с выпусками того же вида, а запись за сам день
+// date не возвращается: при повторной генерации в течение дня выпуск может
+// оставить прежнюю рекомендацию.
+func (h *RecommendationHistory) Recent(date string, kind AnalyticsKind) ([]RecommendationRecord, error) {
+	if h == nil || h.windowDays <= 0 {
+		return nil, nil
+	}
+
+	day, err := time.ParseInLocation(calendarDateLayout, date, moscowLocation())
+	if err != nil {
+		return nil, fmt.Errorf("некорректная дата выпуска %q: %w", date, err)
+	}
+	from := day.AddDate(0, 0, -h.windowDays).Format(calendarDateLayout)
+
+	records, err := h.storage.Recommendations(day.AddDate(0, 0, -h.windowDays-1))
+	if err != nil {
+		return nil, err
+	}
+
+	recent := records[:0]
+	for _, record := range records {
+		if record.Kind != kind || record.Date < from || record.Date >= date {
+			continue
+		}
+		recent = append(recent, record)
+	}
+	return recent, nil
+}
+
+// Record сохраняет рекомендацию выпуска kind в стиле persona на бюджет budget.
+// Варианты выпуска за один день хранятся отдельно, и каждый из них учитывается
+// при проверке повторов в следующие дни.
+func (h *RecommendationHistory) Record(report *AnalyticsReport, kind AnalyticsKind, persona Persona, budget float64) {
+	if h == nil {
+		return
+	}
+
+	record := RecommendationRecord{
+		Date:       report.Date,
+		Kind:       kind,
+		Persona:    persona.OrDefault(),
+		Budget:     budgetOrDefault(budget),
+		Instrument: report.Recommendation.Instrument,
+		Type:       report.Recommendation.Type,
+		Ticker:     strings.ToUpper(strings.TrimSpace(report.Recommendation.Ticker)),
+		SideHustle: report.SideHustle.topic(),
+		CreatedAt:  time.Now(),
+	}
+	if err := h.storage.RecordRecommendation(record); err != nil {
+		log.Printf("Ошибка сохранения истории рекомендаций: %v", err)
+	}
+}
+
+// Check возвращает ошибку со всеми повторами рекомендаций из records
+func (h *RecommendationHistory) Check(report *AnalyticsReport, records []RecommendationRecord) error {
+	rec := report.Recommendation
+	ticker := strings.TrimSpace(rec.Ticker)
+	topic := report.SideHustle.topic()
+
+	var errs []error
+	for _, record := range records {
+		switch {
+		case ticker != "" && strings.EqualFold(ticker, record.Ticker):
+			errs = append(errs, fmt.Errorf("тикер %s уже рекомендовался %s, выбери другой инструмент", record.Ticker, record.Date))
+		case ticker == "" && record.Ticker == "" && rec.Type == record.Type && sameText(rec.Instrument, record.Instrument):
+			errs = append(errs, fmt.Errorf("инструмент %q уже рекомендовался %s, выбери другой", record.Instrument, record.Date))
+		}
+		if topic != "" && sameText(topic, record.SideHustle) {
+			errs = append(errs, fmt.Errorf("тема подработки %q уже была %s, предложи другую", record.SideHustle, record.Date))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sameText сравнивает строки без учета регистра и пробелов по краям
+func sameText(a, b string) bool {
+	return strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b))
+}
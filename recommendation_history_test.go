@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecommendationHistory(t *testing.T) {
+	storage, err := NewFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStorage: %v", err)
+	}
+	defer storage.Close()
+	history := NewRecommendationHistory(storage, 7)
+
+	today := time.Now().In(moscowLocation())
+	day := func(offset int) string {
+		return today.AddDate(0, 0, offset).Format(calendarDateLayout)
+	}
+	issue := func(date, ticker, topic string) *AnalyticsReport {
+		return &AnalyticsReport{
+			Date:           date,
+			Recommendation: Recommendation{Instrument: ticker, Type: "share", Ticker: ticker},
+			SideHustle:     SideHustleTip{Title: topic},
+		}
+	}
+
+	history.Record(issue(day(-10), "LKOH", "Репетиторство"), "daily", "", 0)
+	history.Record(issue(day(-3), "sber", "Фриланс"), "daily", "", 0)
+	history.Record(issue(day(-2), "GAZP", "Доставка"), "weekly", "", 0)
+	history.Record(issue(day(0), "YNDX", "Курьер"), "daily", "", 0)
+	// Повторная генерация того же выпуска заменяет запись
+	history.Record(issue(day(-1), "MGNT", "Аренда"), "daily", "", 0)
+	history.Record(issue(day(-1), "MTSS", "Аренда"), "daily", "", 0)
+
+	recent, err := history.Recent(day(0), "daily")
+	if err != nil {
+		t.Fatalf("Recent: %v", err)
+	}
+	var tickers []string
+	for _, record := range recent {
+		tickers = append(tickers, record.Ticker)
+	}
+	if got := strings.Join(tickers, ","); got != "SBER,MTSS" {
+		t.Errorf("Recent = %s, ожидалось SBER,MTSS", got)
+	}
+
+	if err := history.Check(issue(day(0), "ROSN", "Переводы"), recent); err != nil {
+		t.Errorf("Check без повторов: %v", err)
+	}
+	err = history.Check(issue(day(0), "Sber", " аренда "), recent)
+	if err == nil || !strings.Contains(err.Error(), "SBER") || !strings.Contains(err.Error(), "Аренда") {
+		t.Errorf("Check с повторами тикера и темы: %v", err)
+	}
+
+	if recent, err := NewRecommendationHistory(storage, 0).Recent(day(0), "daily"); err != nil || recent != nil {
+		t.Errorf("Recent с отключенной проверкой = %v, %v", recent, err)
+	}
+}
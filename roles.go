@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Role роль пользователя бота
+type Role string
+
+const (
+	RoleBanned     Role = "banned"     // заблокирован, бот игнорирует все команды
+	RoleGuest      Role = "guest"      // роль не назначена
+	RoleSubscriber Role = "subscriber" // может подписываться и запрашивать аналитику
+	RoleEditor     Role = "editor"     // управляет рассылками и контентом
+	RoleAdmin      Role = "admin"      // назначает роли
+	RoleOwner      Role = "owner"      // владелец бота, задается в настройках
+)
+
+// roleRanks упорядочивает роли по уровню доступа
+var roleRanks = map[Role]int{
+	RoleBanned:     -1,
+	RoleGuest:      0,
+	RoleSubscriber: 1,
+	RoleEditor:     2,
+	RoleAdmin:      3,
+	RoleOwner:      4,
+}
+
+// ParseRole разбирает название роли
+func ParseRole(input string) (Role, error) {
+	role := Role(strings.ToLower(strings.TrimSpace(input)))
+	if _, ok := roleRanks[role]; !ok || role == RoleGuest {
+		return "", fmt.Errorf("неизвестная роль %q", input)
+	}
+	return role, nil
+}
+
+// AtLeast проверяет, что роль не ниже required
+func (r Role) AtLeast(required Role) bool {
+	return roleRanks[r] >= roleRanks[required]
+}
+
+// RoleAssignment назначение роли пользователю
+type RoleAssignment struct {
+	UserID    int64     `json:"user_id"`
+	Role      Role      `json:"role"`
+	GrantedBy int64     `json:"granted_by"`
+	GrantedAt time.Time `json:"granted_at"`
+}
+
+// commandRoles минимальная роль для каждой команды бота. Команды, которых
+// нет в списке, бот не обрабатывает.
+var commandRoles = map[string]Role{
+	"start":       RoleGuest,
+	"subscribe":   RoleSubscriber,
+	"unsubscribe": RoleSubscriber,
+	"schedule":    RoleSubscriber,
+	"style":       RoleSubscriber,
+	"budget":      RoleSubscriber,
+	"analytics":   RoleSubscriber,
+	"ask":         RoleSubscriber, // и обычные сообщения-вопросы
+	"forget":      RoleSubscriber,
+	"jobs":        RoleEditor,
+	"deliveries":  RoleEditor,
+	"status":      RoleAdmin,
+	"usage":       RoleAdmin,
+	"compliance":  RoleAdmin,
+	"refresh":     RoleAdmin,
+	"roles":       RoleAdmin,
+	"grant":       RoleAdmin,
+	"revoke":      RoleAdmin,
+}
+
+// Ошибки управления ролями
+var (
+	ErrUnknownCommand   = errors.New("неизвестная команда")
+	ErrPermissionDenied = errors.New("недостаточно прав")
+	ErrConfiguredRole   = errors.New("роль задана в настройках бота и не может быть изменена командой")
+)
+
+// AccessControl определяет роли пользователей и проверяет права на команды.
+// Владельцы и администраторы из настроек имеют приоритет над ролями из хранилища.
+type AccessControl struct {
+	storage    Storage
+	configured map[int64]Role
+}
+
+// NewAccessControl создает проверку прав с владельцами и администраторами из настроек
+func NewAccessControl(cfg *Config, storage Storage) *AccessControl {
+	configured := make(map[int64]Role, len(cfg.Owners)+len(cfg.Admins))
+	for _, id := range cfg.Admins {
+		configured[id] = RoleAdmin
+	}
+	for _, id := range cfg.Owners {
+		configured[id] = RoleOwner
+	}
+
+	return &AccessControl{
+		storage:    storage,
+		configured: configured,
+	}
+}
+
+// RoleOf возвращает роль пользователя
+func (a *AccessControl) RoleOf(userID int64) (Role, error) {
+	if role, ok := a.configured[userID]; ok {
+		return role, nil
+	}
+
+	assignment, ok, err := a.storage.GetRole(userID)
+	if err != nil {
+		return RoleGuest, err
+	}
+	if !ok {
+		return RoleGuest, nil
+	}
+	return assignment.Role, nil
+}
+
+// Authorize проверяет, может ли пользователь выполнить команду, и возвращает его роль
+func (a *AccessControl) Authorize(userID int64, command string) (Role, error) {
+	required, ok := commandRoles[command]
+	if !ok {
+		return RoleGuest, ErrUnknownCommand
+	}
+
+	role, err := a.RoleOf(userID)
+	if err != nil {
+		return role, fmt.Errorf("ошибка получения роли пользователя %d: %w", userID, err)
+	}
+	if role == RoleBanned || !role.AtLeast(required) {
+		return role, ErrPermissionDenied
+	}
+
+	return role, nil
+}
+
+// Grant назначает пользователю target роль от имени actor. Назначать можно
+// только роли ниже собственной и только пользователям с ролью ниже собственной.
+func (a *AccessControl) Grant(actor, target int64, role Role) error {
+	if err := a.checkManage(actor, target, role); err != nil {
+		return err
+	}
+
+	return a.storage.SetRole(RoleAssignment{
+		UserID:    target,
+		Role:      role,
+		GrantedBy: actor,
+		GrantedAt: time.Now(),
+	})
+}
+
+// Revoke снимает роль с пользователя target от имени actor
+func (a *AccessControl) Revoke(actor, target int64) error {
+	if err := a.checkManage(actor, target, RoleGuest); err != nil {
+		return err
+	}
+
+	return a.storage.DeleteRole(target)
+}
+
+// Assignments возвращает назначения ролей: сначала из настроек, затем из хранилища
+func (a *AccessControl) Assignments() ([]RoleAssignment, error) {
+	assignments := make([]RoleAssignment, 0, len(a.configured))
+	for id, role := range a.configured {
+		assignments = append(assignments, RoleAssignment{UserID: id, Role: role})
+	}
+
+	stored, err := a.storage.Roles()
+	if err != nil {
+		return nil, err
+	}
+	for _, assignment := range stored {
+		if _, ok := a.configured[assignment.UserID]; !ok {
+			assignments = append(assignments, assignment)
+		}
+	}
+
+	return assignments, nil
+}
+
+// checkManage проверяет, может ли actor изменить роль target на role
+func (a *AccessControl) checkManage(actor, target int64, role Role) error {
+	if _, ok := a.configured[target]; ok {
+		return ErrConfiguredRole
+	}
+
+	actorRole, err := a.RoleOf(actor)
+	if err != nil {
+		return err
+	}
+	targetRole, err := a.RoleOf(target)
+	if err != nil {
+		return err
+	}
+
+	if !actorRole.AtLeast(RoleAdmin) ||
+		roleRanks[targetRole] >= roleRanks[actorRole] ||
+		roleRanks[role] >= roleRanks[actorRole] {
+		return ErrPermissionDenied
+	}
+
+	return nil
+}
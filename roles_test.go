@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseRole(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Role
+		wantErr bool
+	}{
+		{"editor", RoleEditor, false},
+		{" Admin ", RoleAdmin, false},
+		{"banned", RoleBanned, false},
+		{"guest", "", true},
+		{"root", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseRole(tt.input)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseRole(%q) = %q, %v, ожидалось %q, ошибка: %v", tt.input, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestAccessControl(t *testing.T) {
+	storage, err := NewFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStorage: %v", err)
+	}
+	defer storage.Close()
+
+	const (
+		owner  = 1
+		admin  = 2
+		editor = 10
+		user   = 11
+		other  = 12
+	)
+	access := NewAccessControl(&Config{Owners: []int64{owner}, Admins: []int64{admin}}, storage)
+
+	// Назначения ролей от имени actor
+	grants := []struct {
+		actor, target int64
+		role          Role
+		wantErr       error
+	}{
+		{admin, editor, RoleEditor, nil},
+		{admin, user, RoleSubscriber, nil},
+		{editor, other, RoleSubscriber, ErrPermissionDenied}, // редактор не управляет ролями
+		{admin, other, RoleAdmin, ErrPermissionDenied},       // только роли ниже своей
+		{owner, other, RoleAdmin, nil},
+		{admin, other, RoleBanned, ErrPermissionDenied}, // нельзя менять роль равного
+		{owner, admin, RoleGuest, ErrConfiguredRole},
+	}
+	for _, tt := range grants {
+		if err := access.Grant(tt.actor, tt.target, tt.role); !errors.Is(err, tt.wantErr) {
+			t.Errorf("Grant(%d, %d, %s): ошибка %v, ожидалась %v", tt.actor, tt.target, tt.role, err, tt.wantErr)
+		}
+	}
+
+	checks := []struct {
+		user    int64
+		command string
+		wantErr error
+	}{
+		{user, "start", nil},
+		{user, "analytics", nil},
+		{user, "jobs", ErrPermissionDenied},
+		{editor, "jobs", nil},
+		{editor, "grant", ErrPermissionDenied},
+		{other, "grant", nil},
+		{owner, "revoke", nil},
+		{99, "start", nil},
+		{99, "subscribe", ErrPermissionDenied},
+		{user, "unknown", ErrUnknownCommand},
+	}
+	for _, tt := range checks {
+		if _, err := access.Authorize(tt.user, tt.command); !errors.Is(err, tt.wantErr) {
+			t.Errorf("Authorize(%d, %s): ошибка %v, ожидалась %v", tt.user, tt.command, err, tt.wantErr)
+		}
+	}
+
+	// Заблокированный пользователь не выполняет даже /start
+	if err := access.Grant(admin, user, RoleBanned); err != nil {
+		t.Fatalf("Grant: %v", err)
+	}
+	if _, err := access.Authorize(user, "start"); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("Authorize заблокированного: ошибка %v, ожидалась ErrPermissionDenied", err)
+	}
+
+	if err := access.Revoke(admin, user); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if role, _ := access.RoleOf(user); role != RoleGuest {
+		t.Errorf("роль после Revoke = %s, ожидалась guest", role)
+	}
+
+	assignments, err := access.Assignments()
+	if err != nil {
+		t.Fatalf("Assignments: %v", err)
+	}
+	if len(assignments) != 4 {
+		t.Errorf("Assignments = %+v, ожидалось 4 назначения", assignments)
+	}
+}
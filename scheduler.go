@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// JobFunc выполняет задачу планировщика; now - запланированное время запуска.
+// Задача должна быть идемпотентной для одного и того же запланированного времени:
+// при догоняющем запуске после простоя она может быть вызвана повторно.
+type JobFunc func(now time.Time) error
+
+// JobStore сохраняет время последнего успешного запуска задач между перезапусками
+type JobStore interface {
+	LastJobRun(name string) (time.Time, error)
+	SaveJobRun(name string, scheduled time.Time) error
+}
+
+// JobInfo описывает состояние зарегистрированной задачи
+type JobInfo struct {
+	Name      string
+	Spec      string
+	LastRun   time.Time // запланированное время последнего успешного запуска
+	NextRun   time.Time
+	LastError string
+	Running   bool
+}
+
+// scheduledJob задача планировщика с разобранным расписанием
+type scheduledJob struct {
+	name     string
+	spec     string
+	schedule cron.Schedule
+	run      JobFunc
+	lastRun  time.Time
+	nextRun  time.Time
+	lastErr  error
+	running  bool
+	persist  bool // сохранять время запуска в JobStore
+}
+
+// Scheduler запускает именованные задачи по cron-расписанию. Если запуск был
+// пропущен из-за простоя бота, а с момента пропуска прошло не больше gracePeriod,
+// задача запускается сразу после регистрации.
+type Scheduler struct {
+	mu          sync.Mutex
+	location    *time.Location
+	store       JobStore
+	gracePeriod time.Duration
+	jobs        map[string]*scheduledJob
+	wake        chan struct{}
+	stop        chan struct{}
+	stopped     bool
+	running     sync.WaitGroup
+}
+
+// NewScheduler создает планировщик, интерпретирующий расписания в часовом поясе loc
+func NewScheduler(loc *time.Location, store JobStore, gracePeriod time.Duration) *Scheduler {
+	return &Scheduler{
+		location:    loc,
+		store:       store,
+		gracePeriod: gracePeriod,
+		jobs:        make(map[string]*scheduledJob),
+		wake:        make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+	}
+}
+
+// Register добавляет задачу с cron-выражением из пяти полей (минуты, часы,
+// день месяца, месяц, день недели) или дескриптором вида @daily, @every 1h
+func (s *Scheduler) Register(name, spec string, run JobFunc) error {
+	return s.register(name, spec, run, true)
+}
+
+// RegisterTransient добавляет задачу, время запуска которой не сохраняется
+// в JobStore: для частых задач (например, каждую минуту), которые сами
+// отслеживают, что уже сделано. Такие задачи не догоняются после простоя.
+func (s *Scheduler) RegisterTransient(name, spec string, run JobFunc) error {
+	return s.register(name, spec, run, false)
+}
+
+// register разбирает расписание и добавляет задачу
+func (s *Scheduler) register(name, spec string, run JobFunc, persist bool) error {
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return fmt.Errorf("некорректное расписание задачи %s (%q): %w", name, spec, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.jobs[name]; exists {
+		return fmt.Errorf("задача %s уже зарегистрирована", name)
+	}
+
+	var lastRun time.Time
+	if persist {
+		if lastRun, err = s.store.LastJobRun(name); err != nil {
+			return fmt.Errorf("ошибка чтения последнего запуска задачи %s: %w", name, err)
+		}
+	}
+
+	now := time.Now().In(s.location)
+	nextRun := schedule.Next(now)
+	if missed, ok := s.missedRun(schedule, lastRun, now); ok {
+		log.Printf("Задача %s пропустила запуск %s, выполняем догоняющий запуск", name, missed.Format(time.RFC3339))
+		nextRun = missed
+	}
+
+	s.jobs[name] = &scheduledJob{
+		name:     name,
+		spec:     spec,
+		schedule: schedule,
+		run:      run,
+		lastRun:  lastRun,
+		nextRun:  nextRun,
+		persist:  persist,
+	}
+	s.notify()
+
+	return nil
+}
+
+// missedRun ищет последний запланированный запуск, который был пропущен после
+// lastRun и попадает в окно gracePeriod. Задачи, которые ни разу не запускались,
+// не догоняются.
+func (s *Scheduler) missedRun(schedule cron.Schedule, lastRun, now time.Time) (time.Time, bool) {
+	if lastRun.IsZero() || s.gracePeriod <= 0 {
+		return time.Time{}, false
+	}
+
+	from := now.Add(-s.gracePeriod)
+	if lastRun.After(from) {
+		from = lastRun
+	}
+
+	missed := schedule.Next(from.In(s.location))
+	if missed.IsZero() || missed.After(now) {
+		return time.Time{}, false
+	}
+	for next := schedule.Next(missed); !next.IsZero() && !next.After(now); next = schedule.Next(next) {
+		missed = next
+	}
+
+	return missed, true
+}
+
+// Jobs возвращает состояние всех задач, упорядоченных по времени следующего запуска
+func (s *Scheduler) Jobs() []JobInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	infos := make([]JobInfo, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		info := JobInfo{
+			Name:    job.name,
+			Spec:    job.spec,
+			LastRun: job.lastRun,
+			NextRun: job.nextRun,
+			Running: job.running,
+		}
+		if job.lastErr != nil {
+			info.LastError = job.lastErr.Error()
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].NextRun.Before(infos[j].NextRun)
+	})
+
+	return infos
+}
+
+// Start запускает цикл планировщика в отдельной горутине
+func (s *Scheduler) Start() {
+	go s.loop()
+}
+
+// loop ожидает ближайший запуск и выполняет задачи, время которых наступило
+func (s *Scheduler) loop() {
+	for {
+		wait := s.untilNextRun()
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-s.wake:
+				timer.Stop()
+				continue
+			case <-s.stop:
+				timer.Stop()
+				return
+			}
+		}
+
+		s.runDue(time.Now().In(s.location))
+	}
+}
+
+// untilNextRun возвращает время до ближайшего запуска любой задачи
+func (s *Scheduler) untilNextRun() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.jobs) == 0 {
+		return time.Hour
+	}
+
+	var earliest time.Time
+	for _, job := range s.jobs {
+		if earliest.IsZero() || job.nextRun.Before(earliest) {
+			earliest = job.nextRun
+		}
+	}
+
+	return time.Until(earliest)
+}
+
+// runDue запускает задачи, время которых наступило. Задача, предыдущий запуск
+// которой еще не завершился, пропускает очередной запуск.
+func (s *Scheduler) runDue(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopped {
+		return
+	}
+
+	for _, job := range s.jobs {
+		if job.nextRun.After(now) {
+			continue
+		}
+
+		scheduled := job.nextRun
+		job.nextRun = job.schedule.Next(now)
+
+		if job.running {
+			log.Printf("Задача %s еще выполняется, пропускаем запуск %s", job.name, scheduled.Format(time.RFC3339))
+			continue
+		}
+
+		job.running = true
+		s.running.Add(1)
+		go s.execute(job, scheduled)
+	}
+}
+
+// execute выполняет задачу и сохраняет время успешного запуска
+func (s *Scheduler) execute(job *scheduledJob, scheduled time.Time) {
+	var err error
+	defer s.running.Done()
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("паника: %v", r)
+		}
+
+		if err != nil {
+			log.Printf("Ошибка выполнения задачи %s (%s): %v", job.name, scheduled.Format(time.RFC3339), err)
+		} else if job.persist {
+			if saveErr := s.store.SaveJobRun(job.name, scheduled); saveErr != nil {
+				log.Printf("Ошибка сохранения запуска задачи %s: %v", job.name, saveErr)
+			}
+		}
+
+		s.mu.Lock()
+		job.running = false
+		job.lastErr = err
+		if err == nil {
+			job.lastRun = scheduled
+		}
+		s.mu.Unlock()
+	}()
+
+	err = job.run(scheduled)
+}
+
+// Stop прекращает запуск новых задач и ждет завершения выполняющихся,
+// но не дольше, чем позволяет ctx
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.stopped {
+		s.stopped = true
+		close(s.stop)
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("задачи планировщика не завершились: %w", ctx.Err())
+	}
+}
+
+// notify будит цикл планировщика для пересчета ближайшего запуска
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// memoryJobStore хранит время запусков задач в памяти
+type memoryJobStore struct {
+	mu   sync.Mutex
+	runs map[string]time.Time
+}
+
+func newMemoryJobStore() *memoryJobStore {
+	return &memoryJobStore{runs: make(map[string]time.Time)}
+}
+
+func (s *memoryJobStore) LastJobRun(name string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.runs[name], nil
+}
+
+func (s *memoryJobStore) SaveJobRun(name string, scheduled time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.runs[name] = scheduled
+	return nil
+}
+
+func TestSchedulerRegister(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		wantErr bool
+	}{
+		{"пять полей", "0 9 * * 1-5", false},
+		{"дескриптор", "@every 1h", false},
+		{"каждую минуту", "* * * * *", false},
+		{"лишнее поле", "0 0 9 * * *", true},
+		{"пустое расписание", "", true},
+		{"мусор", "когда-нибудь", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewScheduler(time.UTC, newMemoryJobStore(), 0)
+			err := s.Register("job", tt.spec, func(time.Time) error { return nil })
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Register(%q) = %v, ожидалась ошибка: %v", tt.spec, err, tt.wantErr)
+			}
+		})
+	}
+
+	s := NewScheduler(time.UTC, newMemoryJobStore(), 0)
+	if err := s.Register("job", "@daily", func(time.Time) error { return nil }); err != nil {
+		t.Fatalf("Register() = %v", err)
+	}
+	if err := s.Register("job", "@hourly", func(time.Time) error { return nil }); err == nil {
+		t.Error("повторная регистрация задачи с тем же именем должна возвращать ошибку")
+	}
+}
+
+func TestSchedulerRunDue(t *testing.T) {
+	failure := errors.New("сбой")
+
+	tests := []struct {
+		name      string
+		transient bool
+		err       error
+		wantSaved bool
+	}{
+		{"успешный запуск сохраняется", false, nil, true},
+		{"неудачный запуск не сохраняется", false, failure, false},
+		{"запуск частой задачи не сохраняется", true, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemoryJobStore()
+			s := NewScheduler(time.UTC, store, 0)
+
+			var ran []time.Time
+			run := func(scheduled time.Time) error {
+				ran = append(ran, scheduled)
+				return tt.err
+			}
+			register := s.Register
+			if tt.transient {
+				register = s.RegisterTransient
+			}
+			if err := register("job", "@every 1h", run); err != nil {
+				t.Fatalf("регистрация: %v", err)
+			}
+			scheduled := s.Jobs()[0].NextRun
+
+			// До срока задача не запускается, после - один раз за срок
+			s.runDue(scheduled.Add(-time.Second))
+			s.runDue(scheduled)
+			if err := s.Stop(context.Background()); err != nil {
+				t.Fatalf("Stop() = %v", err)
+			}
+
+			if len(ran) != 1 || !ran[0].Equal(scheduled) {
+				t.Fatalf("запуски %v, ожидался один запуск %s", ran, scheduled)
+			}
+			saved, _ := store.LastJobRun("job")
+			if saved.Equal(scheduled) != tt.wantSaved {
+				t.Errorf("сохраненный запуск %s, ожидалось сохранение: %v", saved, tt.wantSaved)
+			}
+
+			job := s.Jobs()[0]
+			if job.NextRun.Equal(scheduled) {
+				t.Errorf("следующий запуск не сдвинулся: %s", job.NextRun)
+			}
+			if (job.LastError != "") != (tt.err != nil) {
+				t.Errorf("последняя ошибка %q, ожидалась ошибка: %v", job.LastError, tt.err != nil)
+			}
+		})
+	}
+}
+
+func TestSchedulerMissedRun(t *testing.T) {
+	day := func(d, hour, minute int) time.Time {
+		return time.Date(2024, time.March, d, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name        string
+		spec        string
+		gracePeriod time.Duration
+		lastRun     time.Time
+		now         time.Time
+		want        time.Time
+		wantOK      bool
+	}{
+		{
+			name:        "задача ни разу не запускалась",
+			spec:        "0 9 * * *",
+			gracePeriod: 3 * time.Hour,
+			now:         day(11, 10, 0),
+		},
+		{
+			name:        "догоняющий запуск отключен",
+			spec:        "0 9 * * *",
+			gracePeriod: 0,
+			lastRun:     day(10, 9, 0),
+			now:         day(11, 10, 0),
+		},
+		{
+			name:        "пропущенный запуск в пределах окна",
+			spec:        "0 9 * * *",
+			gracePeriod: 3 * time.Hour,
+			lastRun:     day(10, 9, 0),
+			now:         day(11, 10, 0),
+			want:        day(11, 9, 0),
+			wantOK:      true,
+		},
+		{
+			name:        "пропущенный запуск в конце окна",
+			spec:        "0 9 * * *",
+			gracePeriod: 3 * time.Hour,
+			lastRun:     day(10, 9, 0),
+			now:         day(11, 11, 59),
+			want:        day(11, 9, 0),
+			wantOK:      true,
+		},
+		{
+			name:        "пропущенный запуск вне окна",
+			spec:        "0 9 * * *",
+			gracePeriod: 3 * time.Hour,
+			lastRun:     day(10, 9, 0),
+			now:         day(11, 12, 0),
+		},
+		{
+			name:        "запуск уже выполнен",
+			spec:        "0 9 * * *",
+			gracePeriod: 3 * time.Hour,
+			lastRun:     day(11, 9, 0),
+			now:         day(11, 10, 0),
+		},
+		{
+			name:        "следующий запуск еще не наступил",
+			spec:        "0 9 * * *",
+			gracePeriod: 3 * time.Hour,
+			lastRun:     day(10, 9, 0),
+			now:         day(11, 8, 59),
+		},
+		{
+			name:        "из нескольких пропущенных выбирается последний",
+			spec:        "0 * * * *",
+			gracePeriod: 3 * time.Hour,
+			lastRun:     day(11, 5, 0),
+			now:         day(11, 9, 30),
+			want:        day(11, 9, 0),
+			wantOK:      true,
+		},
+		{
+			name:        "после простоя догоняется только последний запуск",
+			spec:        "0 9 * * *",
+			gracePeriod: 72 * time.Hour,
+			lastRun:     day(8, 9, 0),
+			now:         day(11, 9, 30),
+			want:        day(11, 9, 0),
+			wantOK:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := cron.ParseStandard(tt.spec)
+			if err != nil {
+				t.Fatalf("некорректное расписание %q: %v", tt.spec, err)
+			}
+			s := NewScheduler(time.UTC, nil, tt.gracePeriod)
+
+			got, ok := s.missedRun(schedule, tt.lastRun, tt.now)
+			if ok != tt.wantOK {
+				t.Fatalf("missedRun() найден = %v, ожидалось %v", ok, tt.wantOK)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("missedRun() = %s, ожидалось %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrChatNotFound возвращается, если чат ни разу не взаимодействовал с ботом
+var ErrChatNotFound = errors.New("чат не найден")
+
+// ChatSettings содержит пользовательские настройки чата
+type ChatSettings struct {
+	DeliveryTime string    `json:"delivery_time,omitempty"` // ЧЧ:ММ, пусто - время по умолчанию
+	Timezone     string    `json:"timezone,omitempty"`      // пусто - DefaultTimezone
+	Persona      Persona   `json:"persona,omitempty"`       // пусто - DefaultPersona
+	Budget       float64   `json:"budget,omitempty"`        // в рублях, 0 - defaultBudget
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// ChatRecord описывает чат, подписку и его настройки
+type ChatRecord struct {
+	ChatID         int64        `json:"chat_id"`
+	Subscribed     bool         `json:"subscribed"`
+	SubscribedAt   time.Time    `json:"subscribed_at"`
+	UnsubscribedAt time.Time    `json:"unsubscribed_at"`
+	Settings       ChatSettings `json:"settings"`
+	// Deliveries время последней доставки по каждой рассылке (daily, premarket, ...)
+	Deliveries map[string]time.Time `json:"deliveries,omitempty"`
+}
+
+// LastDelivery возвращает время последней доставки рассылки job в чат
+func (c ChatRecord) LastDelivery(job string) time.Time {
+	return c.Deliveries[job]
+}
+
+// Storage хранит подписчиков и настройки чатов между перезапусками бота
+type Storage interface {
+	// Subscribe подписывает чат на ежедневную аналитику
+	Subscribe(chatID int64) error
+	// Unsubscribe отписывает чат от ежедневной аналитики
+	Unsubscribe(chatID int64) error
+	// IsSubscribed проверяет, подписан ли чат
+	IsSubscribed(chatID int64) (bool, error)
+	// Subscribers возвращает все подписанные чаты
+	Subscribers() ([]ChatRecord, error)
+	// GetChat возвращает запись чата или ErrChatNotFound
+	GetChat(chatID int64) (ChatRecord, error)
+	// UpdateSettings изменяет настройки чата, создавая запись при необходимости
+	UpdateSettings(chatID int64, update func(*ChatSettings)) error
+	// MarkDelivered запоминает время доставки рассылки job в чат
+	MarkDelivered(chatID int64, job string, at time.Time) error
+	// LastJobRun возвращает запланированное время последнего успешного запуска задачи
+	LastJobRun(name string) (time.Time, error)
+	// SaveJobRun запоминает запланированное время успешного запуска задачи
+	SaveJobRun(name string, scheduled time.Time) error
+	// GetRole возвращает назначенную пользователю роль
+	GetRole(userID int64) (RoleAssignment, bool, error)
+	// SetRole сохраняет назначение роли
+	SetRole(assignment RoleAssignment) error
+	// DeleteRole снимает роль с пользователя
+	DeleteRole(userID int64) error
+	// Roles возвращает все назначения ролей
+	Roles() ([]RoleAssignment, error)
+	// RecordUsage сохраняет запись о вызове модели
+	RecordUsage(record UsageRecord) error
+	// Usage возвращает записи о вызовах модели начиная с since, в порядке времени
+	Usage(since time.Time) ([]UsageRecord, error)
+	// RecordCompliance сохраняет запись о вмешательстве проверки соответствия требованиям
+	RecordCompliance(record ComplianceRecord) error
+	// ComplianceLog возвращает записи о вмешательствах начиная с since, в порядке времени
+	ComplianceLog(since time.Time) ([]ComplianceRecord, error)
+	// RecordRecommendation сохраняет рекомендацию выпуска, заменяя прежнюю
+	// запись того же выпуска (дня, вида, стиля и бюджета)
+	RecordRecommendation(record RecommendationRecord) error
+	// Recommendations возвращает рекомендации начиная с since, в порядке времени
+	Recommendations(since time.Time) ([]RecommendationRecord, error)
+	// Conversation возвращает память диалога чата (пустую, если диалога не было)
+	Conversation(chatID int64) (Conversation, error)
+	// SaveConversation сохраняет память диалога чата; пустой диалог удаляется
+	SaveConversation(chatID int64, conversation Conversation) error
+	// Close сбрасывает данные на диск и освобождает ресурсы
+	Close() error
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Имена файлов хранилища внутри каталога данных
+const (
+	storageFileName         = "storage.json"
+	usageLogFileName        = "usage.jsonl"
+	complianceLogFileName   = "compliance.jsonl"
+	recommendationsFileName = "recommendations.jsonl"
+	conversationsDirName    = "conversations"
+)
+
+// fileStorageState содержимое файла хранилища: небольшое и редко меняющееся
+// состояние. Журналы и диалоги хранятся в отдельных файлах.
+type fileStorageState struct {
+	Chats   map[int64]*ChatRecord    `json:"chats"`
+	JobRuns map[string]time.Time     `json:"job_runs"`
+	Roles   map[int64]RoleAssignment `json:"roles"`
+
+	// Поля прежнего формата: при первом запуске переносятся в отдельные файлы
+	Usage           []UsageRecord          `json:"usage,omitempty"`
+	Compliance      []ComplianceRecord     `json:"compliance,omitempty"`
+	Recommendations []RecommendationRecord `json:"recommendations,omitempty"`
+	Conversations   map[int64]Conversation `json:"conversations,omitempty"`
+}
+
+// FileStorage хранит данные бота в каталоге данных: чаты, роли и запуски задач
+// в storage.json, журналы вызовов модели, проверки соответствия и рекомендаций
+// в дописываемых файлах JSON Lines, диалоги в отдельном файле на чат
+type FileStorage struct {
+	mu    sync.Mutex
+	path  string
+	state fileStorageState
+
+	usage           *appendLog[UsageRecord]
+	compliance      *appendLog[ComplianceRecord]
+	recommendations *appendLog[RecommendationRecord]
+	conversations   *conversationFiles
+}
+
+// NewFileStorage открывает (или создает) файловое хранилище в каталоге dataDir
+func NewFileStorage(dataDir string) (*FileStorage, error) {
+	if err := os.MkdirAll(dataDir, 0o755); err != nil {
+		return nil, fmt.Errorf("ошибка создания каталога данных %s: %w", dataDir, err)
+	}
+
+	s := &FileStorage{
+		path: filepath.Join(dataDir, storageFileName),
+	}
+
+	content, err := os.ReadFile(s.path)
+	switch {
+	case os.IsNotExist(err):
+		// Файла еще нет - начинаем с пустого хранилища
+	case err != nil:
+		return nil, fmt.Errorf("ошибка чтения хранилища %s: %w", s.path, err)
+	default:
+		if err := json.Unmarshal(content, &s.state); err != nil {
+			return nil, fmt.Errorf("ошибка парсинга хранилища %s: %w", s.path, err)
+		}
+	}
+
+	if s.state.Chats == nil {
+		s.state.Chats = make(map[int64]*ChatRecord)
+	}
+	if s.state.JobRuns == nil {
+		s.state.JobRuns = make(map[string]time.Time)
+	}
+	if s.state.Roles == nil {
+		s.state.Roles = make(map[int64]RoleAssignment)
+	}
+
+	if err := s.openLogs(dataDir); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	// Перенесенные данные прежнего формата больше не хранятся в storage.json
+	if s.state.Usage != nil || s.state.Compliance != nil || s.state.Recommendations != nil || s.state.Conversations != nil {
+		s.state.Usage = nil
+		s.state.Compliance = nil
+		s.state.Recommendations = nil
+		s.state.Conversations = nil
+		if err := s.saveLocked(); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// openLogs открывает журналы и диалоги, перенося в них данные прежнего формата
+func (s *FileStorage) openLogs(dataDir string) error {
+	var err error
+	s.usage, err = openAppendLog(filepath.Join(dataDir, usageLogFileName), usageRetention,
+		func(record UsageRecord) time.Time { return record.Time }, nil, s.state.Usage)
+	if err != nil {
+		return err
+	}
+	s.compliance, err = openAppendLog(filepath.Join(dataDir, complianceLogFileName), complianceRetention,
+		func(record ComplianceRecord) time.Time { return record.Time }, nil, s.state.Compliance)
+	if err != nil {
+		return err
+	}
+	s.recommendations, err = openAppendLog(filepath.Join(dataDir, recommendationsFileName), recommendationRetention,
+		func(record RecommendationRecord) time.Time { return record.CreatedAt },
+		func(existing, record RecommendationRecord) bool { return existing.sameIssue(record) },
+		s.state.Recommendations)
+	if err != nil {
+		return err
+	}
+	s.conversations, err = openConversationFiles(filepath.Join(dataDir, conversationsDirName), s.state.Conversations)
+	return err
+}
+
+// Subscribe подписывает чат на ежедневную аналитику
+func (s *FileStorage) Subscribe(chatID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chat := s.chatLocked(chatID)
+	if chat.Subscribed {
+		return nil
+	}
+	chat.Subscribed = true
+	chat.SubscribedAt = time.Now()
+
+	return s.saveLocked()
+}
+
+// Unsubscribe отписывает чат от ежедневной аналитики
+func (s *FileStorage) Unsubscribe(chatID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chat, ok := s.state.Chats[chatID]
+	if !ok || !chat.Subscribed {
+		return nil
+	}
+	chat.Subscribed = false
+	chat.UnsubscribedAt = time.Now()
+
+	return s.saveLocked()
+}
+
+// IsSubscribed проверяет, подписан ли чат
+func (s *FileStorage) IsSubscribed(chatID int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chat, ok := s.state.Chats[chatID]
+	return ok && chat.Subscribed, nil
+}
+
+// Subscribers возвращает все подписанные чаты, упорядоченные по ID
+func (s *FileStorage) Subscribers() ([]ChatRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subscribers := make([]ChatRecord, 0, len(s.state.Chats))
+	for _, chat := range s.state.Chats {
+		if chat.Subscribed {
+			subscribers = append(subscribers, *chat)
+		}
+	}
+	sort.Slice(subscribers, func(i, j int) bool {
+		return subscribers[i].ChatID < subscribers[j].ChatID
+	})
+
+	return subscribers, nil
+}
+
+// GetChat возвращает запись чата или ErrChatNotFound
+func (s *FileStorage) GetChat(chatID int64) (ChatRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chat, ok := s.state.Chats[chatID]
+	if !ok {
+		return ChatRecord{}, ErrChatNotFound
+	}
+	return *chat, nil
+}
+
+// UpdateSettings изменяет настройки чата, создавая запись при необходимости
+func (s *FileStorage) UpdateSettings(chatID int64, update func(*ChatSettings)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chat := s.chatLocked(chatID)
+	update(&chat.Settings)
+	chat.Settings.UpdatedAt = time.Now()
+
+	return s.saveLocked()
+}
+
+// MarkDelivered запоминает время доставки рассылки job в чат
+func (s *FileStorage) MarkDelivered(chatID int64, job string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chat := s.chatLocked(chatID)
+	if chat.Deliveries == nil {
+		chat.Deliveries = make(map[string]time.Time)
+	}
+	chat.Deliveries[job] = at
+
+	return s.saveLocked()
+}
+
+// LastJobRun возвращает запланированное время последнего успешного запуска задачи
+func (s *FileStorage) LastJobRun(name string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.state.JobRuns[name], nil
+}
+
+// SaveJobRun запоминает запланированное время успешного запуска задачи
+func (s *FileStorage) SaveJobRun(name string, scheduled time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.state.JobRuns[name] = scheduled
+
+	return s.saveLocked()
+}
+
+// GetRole возвращает назначенную пользователю роль
+func (s *FileStorage) GetRole(userID int64) (RoleAssignment, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	assignment, ok := s.state.Roles[userID]
+	return assignment, ok, nil
+}
+
+// SetRole сохраняет назначение роли
+func (s *FileStorage) SetRole(assignment RoleAssignment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.state.Roles[assignment.UserID] = assignment
+
+	return s.saveLocked()
+}
+
+// DeleteRole снимает роль с пользователя
+func (s *FileStorage) DeleteRole(userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.state.Roles[userID]; !ok {
+		return nil
+	}
+	delete(s.state.Roles, userID)
+
+	return s.saveLocked()
+}
+
+// Roles возвращает все назначения ролей, упорядоченные по ID пользователя
+func (s *FileStorage) Roles() ([]RoleAssignment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	assignments := make([]RoleAssignment, 0, len(s.state.Roles))
+	for _, assignment := range s.state.Roles {
+		assignments = append(assignments, assignment)
+	}
+	sort.Slice(assignments, func(i, j int) bool {
+		return assignments[i].UserID < assignments[j].UserID
+	})
+
+	return assignments, nil
+}
+
+// RecordUsage дописывает запись о вызове модели в журнал
+func (s *FileStorage) RecordUsage(record UsageRecord) error {
+	return s.usage.Append(record)
+}
+
+// Usage возвращает записи о вызовах модели начиная с since, в порядке времени
+func (s *FileStorage) Usage(since time.Time) ([]UsageRecord, error) {
+	return s.usage.Since(since), nil
+}
+
+// RecordCompliance дописывает запись о вмешательстве в журнал
+func (s *FileStorage) RecordCompliance(record ComplianceRecord) error {
+	return s.compliance.Append(record)
+}
+
+// ComplianceLog возвращает записи о вмешательствах начиная с since, в порядке времени
+func (s *FileStorage) ComplianceLog(since time.Time) ([]ComplianceRecord, error) {
+	return s.compliance.Since(since), nil
+}
+
+// RecordRecommendation дописывает рекомендацию выпуска в журнал; она заменяет
+// прежнюю запись того же выпуска (дня, вида, стиля и бюджета)
+func (s *FileStorage) RecordRecommendation(record RecommendationRecord) error {
+	return s.recommendations.Append(record)
+}
+
+// Recommendations возвращает рекомендации начиная с since, в порядке времени
+func (s *FileStorage) Recommendations(since time.Time) ([]RecommendationRecord, error) {
+	return s.recommendations.Since(since), nil
+}
+
+// Conversation возвращает память диалога чата (пустую, если диалога не было)
+func (s *FileStorage) Conversation(chatID int64) (Conversation, error) {
+	return s.conversations.Get(chatID), nil
+}
+
+// SaveConversation сохраняет память диалога чата; пустой диалог удаляется
+func (s *FileStorage) SaveConversation(chatID int64, conversation Conversation) error {
+	return s.conversations.Save(chatID, conversation)
+}
+
+// Close сбрасывает данные на диск и закрывает журналы
+func (s *FileStorage) Close() error {
+	s.mu.Lock()
+	err := s.saveLocked()
+	s.mu.Unlock()
+
+	return errors.Join(err, s.usage.Close(), s.compliance.Close(), s.recommendations.Close())
+}
+
+// chatLocked возвращает запись чата, создавая ее при необходимости.
+// Вызывается под блокировкой s.mu.
+func (s *FileStorage) chatLocked(chatID int64) *ChatRecord {
+	chat, ok := s.state.Chats[chatID]
+	if !ok {
+		chat = &ChatRecord{ChatID: chatID}
+		s.state.Chats[chatID] = chat
+	}
+	return chat
+}
+
+// saveLocked атомарно записывает состояние в файл через временный файл.
+// Вызывается под блокировкой s.mu.
+func (s *FileStorage) saveLocked() error {
+	content, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("ошибка маршалинга хранилища: %w", err)
+	}
+
+	return writeFileAtomic(s.path, content)
+}
+
+// conversationFiles хранит память диалогов по файлу на чат, чтобы ход диалога
+// переписывал только файл своего чата
+type conversationFiles struct {
+	mu            sync.Mutex
+	dir           string
+	conversations map[int64]Conversation
+}
+
+// openConversationFiles читает диалоги из каталога dir. Если каталога еще нет,
+// в него переносятся диалоги legacy из прежнего формата хранилища.
+func openConversationFiles(dir string, legacy map[int64]Conversation) (*conversationFiles, error) {
+	c := &conversationFiles{
+		dir:           dir,
+		conversations: make(map[int64]Conversation),
+	}
+
+	entries, err := os.ReadDir(dir)
+	switch {
+	case os.IsNotExist(err):
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("ошибка создания каталога диалогов %s: %w", dir, err)
+		}
+		for chatID, conversation := range legacy {
+			if err := c.Save(chatID, conversation); err != nil {
+				return nil, err
+			}
+		}
+		return c, nil
+	case err != nil:
+		return nil, fmt.Errorf("ошибка чтения каталога диалогов %s: %w", dir, err)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		chatID, err := strconv.ParseInt(strings.TrimSuffix(name, ".json"), 10, 64)
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка чтения диалога %s: %w", path, err)
+		}
+		var conversation Conversation
+		if err := json.Unmarshal(content, &conversation); err != nil {
+			return nil, fmt.Errorf("ошибка парсинга диалога %s: %w", path, err)
+		}
+		c.conversations[chatID] = conversation
+	}
+
+	return c, nil
+}
+
+// Get возвращает копию памяти диалога чата
+func (c *conversationFiles) Get(chatID int64) Conversation {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conversation := c.conversations[chatID]
+	conversation.Messages = append([]AIMessage(nil), conversation.Messages...)
+	return conversation
+}
+
+// Save записывает файл диалога чата; пустой диалог удаляет файл
+func (c *conversationFiles) Save(chatID int64, conversation Conversation) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	path := filepath.Join(c.dir, strconv.FormatInt(chatID, 10)+".json")
+	if conversation.Empty() {
+		delete(c.conversations, chatID)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("ошибка удаления диалога %s: %w", path, err)
+		}
+		return nil
+	}
+
+	content, err := json.Marshal(conversation)
+	if err != nil {
+		return fmt.Errorf("ошибка маршалинга диалога: %w", err)
+	}
+	if err := writeFileAtomic(path, content); err != nil {
+		return err
+	}
+	c.conversations[chatID] = conversation
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// reopenStorage закрывает хранилище и открывает его заново из того же каталога
+func reopenStorage(t *testing.T, storage *FileStorage, dir string) *FileStorage {
+	t.Helper()
+	if err := storage.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	reopened, err := NewFileStorage(dir)
+	if err != nil {
+		t.Fatalf("NewFileStorage: %v", err)
+	}
+	t.Cleanup(func() { reopened.Close() })
+	return reopened
+}
+
+func TestFileStorageSubscriptions(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := NewFileStorage(dir)
+	if err != nil {
+		t.Fatalf("NewFileStorage: %v", err)
+	}
+
+	if _, err := storage.GetChat(1); !errors.Is(err, ErrChatNotFound) {
+		t.Fatalf("GetChat неизвестного чата: ошибка %v, ожидалась ErrChatNotFound", err)
+	}
+	for _, chatID := range []int64{3, 1, 2} {
+		if err := storage.Subscribe(chatID); err != nil {
+			t.Fatalf("Subscribe(%d): %v", chatID, err)
+		}
+	}
+	if err := storage.Unsubscribe(2); err != nil {
+		t.Fatalf("Unsubscribe: %v", err)
+	}
+	if err := storage.UpdateSettings(3, func(settings *ChatSettings) {
+		settings.DeliveryTime = "08:30"
+	}); err != nil {
+		t.Fatalf("UpdateSettings: %v", err)
+	}
+
+	storage = reopenStorage(t, storage, dir)
+
+	subscribers, err := storage.Subscribers()
+	if err != nil {
+		t.Fatalf("Subscribers: %v", err)
+	}
+	if len(subscribers) != 2 || subscribers[0].ChatID != 1 || subscribers[1].ChatID != 3 {
+		t.Fatalf("Subscribers = %+v, ожидались чаты 1 и 3", subscribers)
+	}
+	if subscribers[1].Settings.DeliveryTime != "08:30" {
+		t.Errorf("время доставки = %q, ожидалось 08:30", subscribers[1].Settings.DeliveryTime)
+	}
+
+	chat, err := storage.GetChat(2)
+	if err != nil {
+		t.Fatalf("GetChat: %v", err)
+	}
+	if chat.Subscribed || chat.UnsubscribedAt.IsZero() {
+		t.Errorf("чат 2 = %+v, ожидалась отписка со временем", chat)
+	}
+	if subscribed, _ := storage.IsSubscribed(2); subscribed {
+		t.Error("IsSubscribed(2) = true после отписки")
+	}
+}
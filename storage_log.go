@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// logCompactInterval как часто журнал переписывается без устаревших записей
+const logCompactInterval = 24 * time.Hour
+
+// appendLog журнал в файле JSON Lines: каждая запись дописывается в конец файла
+// одной строкой, и файл не переписывается целиком. Записи держатся в памяти для
+// выборок по времени. Записи старше retention удаляются из файла при открытии
+// и не чаще раза в logCompactInterval при записи.
+type appendLog[T any] struct {
+	mu        sync.Mutex
+	path      string
+	retention time.Duration
+	timeOf    func(T) time.Time
+	// replaces сообщает, что новая запись заменяет прежнюю; nil - записи не заменяются
+	replaces func(existing, record T) bool
+
+	records   []T // в порядке времени
+	file      *os.File
+	compacted time.Time
+}
+
+// openAppendLog открывает (или создает) журнал path. Если файла еще нет, в него
+// переносятся записи legacy из прежнего формата хранилища.
+func openAppendLog[T any](path string, retention time.Duration, timeOf func(T) time.Time, replaces func(existing, record T) bool, legacy []T) (*appendLog[T], error) {
+	l := &appendLog[T]{
+		path:      path,
+		retention: retention,
+		timeOf:    timeOf,
+		replaces:  replaces,
+	}
+
+	content, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		for _, record := range legacy {
+			l.addLocked(record)
+		}
+	case err != nil:
+		return nil, fmt.Errorf("ошибка чтения журнала %s: %w", path, err)
+	default:
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		for {
+			var record T
+			if err := decoder.Decode(&record); err != nil {
+				if !errors.Is(err, io.EOF) {
+					// Обрыв последней строки при аварийном завершении - остальное читается
+					log.Printf("Журнал %s прочитан не полностью: %v", path, err)
+				}
+				break
+			}
+			l.addLocked(record)
+		}
+	}
+
+	// Файл переписывается без устаревших записей и затем только дополняется
+	if err := l.compactLocked(time.Now()); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Append дописывает запись в конец журнала
+func (l *appendLog[T]) Append(record T) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Замененная запись остается в файле до сжатия, а при чтении ее заменяет новая
+	l.addLocked(record)
+
+	if now := time.Now(); now.Sub(l.compacted) >= logCompactInterval {
+		return l.compactLocked(now)
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("ошибка маршалинга записи журнала: %w", err)
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("ошибка записи журнала %s: %w", l.path, err)
+	}
+	return nil
+}
+
+// Since возвращает записи начиная с since, в порядке времени
+func (l *appendLog[T]) Since(since time.Time) []T {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	from := sort.Search(len(l.records), func(i int) bool {
+		return !l.timeOf(l.records[i]).Before(since)
+	})
+	records := make([]T, len(l.records)-from)
+	copy(records, l.records[from:])
+	return records
+}
+
+// Close закрывает файл журнала; для неоткрытого журнала ничего не делает
+func (l *appendLog[T]) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// addLocked добавляет прочитанную запись в память, убирая замененные ею.
+// Вызывается под блокировкой l.mu или до начала работы с журналом.
+func (l *appendLog[T]) addLocked(record T) {
+	if l.replaces != nil {
+		l.removeReplacedLocked(record)
+	}
+	l.records = append(l.records, record)
+}
+
+// removeReplacedLocked удаляет из памяти записи, которые заменяет record.
+// Вызывается под блокировкой l.mu.
+func (l *appendLog[T]) removeReplacedLocked(record T) {
+	kept := l.records[:0]
+	for _, existing := range l.records {
+		if !l.replaces(existing, record) {
+			kept = append(kept, existing)
+		}
+	}
+	l.records = kept
+}
+
+// compactLocked удаляет записи старше retention и атомарно переписывает файл
+// оставшимися записями. Вызывается под блокировкой l.mu.
+func (l *appendLog[T]) compactLocked(now time.Time) error {
+	cutoff := now.Add(-l.retention)
+	expired := sort.Search(len(l.records), func(i int) bool {
+		return !l.timeOf(l.records[i]).Before(cutoff)
+	})
+	l.records = append([]T(nil), l.records[expired:]...)
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, record := range l.records {
+		if err := encoder.Encode(record); err != nil {
+			return fmt.Errorf("ошибка маршалинга записи журнала: %w", err)
+		}
+	}
+
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+	if err := writeFileAtomic(l.path, buf.Bytes()); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("ошибка открытия журнала %s: %w", l.path, err)
+	}
+	l.file = file
+	l.compacted = now
+	return nil
+}
+
+// writeFileAtomic записывает файл через временный файл, чтобы при сбое
+// не остался наполовину записанный файл
+func writeFileAtomic(path string, content []byte) error {
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0o644); err != nil {
+		return fmt.Errorf("ошибка записи %s: %w", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("ошибка сохранения %s: %w", path, err)
+	}
+	return nil
+}
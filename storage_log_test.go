@@ -0,0 +1,130 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAppendLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.jsonl")
+	timeOf := func(record UsageRecord) time.Time { return record.Time }
+	now := time.Now()
+
+	// Запись старше срока хранения удаляется при открытии
+	legacy := []UsageRecord{
+		{Time: now.Add(-2 * time.Hour), Model: "expired"},
+		{Time: now.Add(-30 * time.Minute), Model: "legacy"},
+	}
+	usage, err := openAppendLog(path, time.Hour, timeOf, nil, legacy)
+	if err != nil {
+		t.Fatalf("openAppendLog: %v", err)
+	}
+	if err := usage.Append(UsageRecord{Time: now, Model: "new"}); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if err := usage.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// При повторном открытии прежний формат не переносится: файл уже есть
+	usage, err = openAppendLog(path, time.Hour, timeOf, nil, []UsageRecord{{Time: now, Model: "ignored"}})
+	if err != nil {
+		t.Fatalf("openAppendLog: %v", err)
+	}
+	defer usage.Close()
+
+	var models []string
+	for _, record := range usage.Since(now.Add(-time.Hour)) {
+		models = append(models, record.Model)
+	}
+	if got := strings.Join(models, ","); got != "legacy,new" {
+		t.Errorf("записи журнала = %s, ожидалось legacy,new", got)
+	}
+	if got := len(usage.Since(now.Add(-time.Minute))); got != 1 {
+		t.Errorf("записей за последнюю минуту = %d, ожидалась 1", got)
+	}
+}
+
+func TestAppendLogReplaces(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recommendations.jsonl")
+	open := func() *appendLog[RecommendationRecord] {
+		t.Helper()
+		recommendations, err := openAppendLog(path, time.Hour,
+			func(record RecommendationRecord) time.Time { return record.CreatedAt },
+			func(existing, record RecommendationRecord) bool { return existing.sameIssue(record) },
+			nil)
+		if err != nil {
+			t.Fatalf("openAppendLog: %v", err)
+		}
+		return recommendations
+	}
+
+	now := time.Now()
+	recommendations := open()
+	for i, ticker := range []string{"SBER", "GAZP"} {
+		record := RecommendationRecord{Date: "2026-10-16", Kind: "daily", Ticker: ticker, CreatedAt: now.Add(time.Duration(i) * time.Second)}
+		if err := recommendations.Append(record); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	recommendations.Close()
+
+	// Замененная запись остается в файле до сжатия, но не читается
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if lines := strings.Count(string(content), "\n"); lines != 2 {
+		t.Errorf("строк в файле = %d, ожидалось 2", lines)
+	}
+
+	recommendations = open()
+	defer recommendations.Close()
+	records := recommendations.Since(time.Time{})
+	if len(records) != 1 || records[0].Ticker != "GAZP" {
+		t.Errorf("рекомендации = %+v, ожидалась одна запись GAZP", records)
+	}
+}
+
+func TestFileStorageMigratesLegacyLogs(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now().UTC().Truncate(time.Second)
+	legacy := `{"chats": {}, "usage": [{"time": "` + now.Format(time.RFC3339) + `", "model": "legacy"}],
+		"conversations": {"5": {"messages": [{"role": "user", "content": "привет"}]}}}`
+	if err := os.WriteFile(filepath.Join(dir, storageFileName), []byte(legacy), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	storage, err := NewFileStorage(dir)
+	if err != nil {
+		t.Fatalf("NewFileStorage: %v", err)
+	}
+	storage = reopenStorage(t, storage, dir)
+
+	usage, _ := storage.Usage(now.Add(-time.Hour))
+	if len(usage) != 1 || usage[0].Model != "legacy" {
+		t.Errorf("Usage = %+v, ожидалась перенесенная запись", usage)
+	}
+	conversation, _ := storage.Conversation(5)
+	if len(conversation.Messages) != 1 || conversation.Messages[0].Content != "привет" {
+		t.Errorf("Conversation = %+v, ожидался перенесенный диалог", conversation)
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, storageFileName))
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if strings.Contains(string(content), "usage") || strings.Contains(string(content), "conversations") {
+		t.Errorf("storage.json после переноса содержит журналы: %s", content)
+	}
+
+	if err := storage.SaveConversation(5, Conversation{}); err != nil {
+		t.Fatalf("SaveConversation: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, conversationsDirName, "5.json")); !os.IsNotExist(err) {
+		t.Errorf("файл пустого диалога не удален: %v", err)
+	}
+}
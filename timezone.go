@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTimezone часовой пояс по умолчанию для подписчиков
+const DefaultTimezone = "Europe/Moscow"
+
+// timezoneAliases сопоставляет привычные названия часовых поясов России с IANA
+var timezoneAliases = map[string]string{
+	"msk":             "Europe/Moscow",
+	"мск":             "Europe/Moscow",
+	"москва":          "Europe/Moscow",
+	"калининград":     "Europe/Kaliningrad",
+	"самара":          "Europe/Samara",
+	"екатеринбург":    "Asia/Yekaterinburg",
+	"омск":            "Asia/Omsk",
+	"новосибирск":     "Asia/Novosibirsk",
+	"красноярск":      "Asia/Krasnoyarsk",
+	"иркутск":         "Asia/Irkutsk",
+	"якутск":          "Asia/Yakutsk",
+	"владивосток":     "Asia/Vladivostok",
+	"магадан":         "Asia/Magadan",
+	"камчатка":        "Asia/Kamchatka",
+	"петропавловск":   "Asia/Kamchatka",
+	"южно-сахалинск":  "Asia/Sakhalin",
+	"санкт-петербург": "Europe/Moscow",
+	"спб":             "Europe/Moscow",
+}
+
+// loadLocation загружает часовой пояс по имени, а для Москвы при отсутствии
+// базы часовых поясов использует фиксированное смещение UTC+3
+func loadLocation(name string) (*time.Location, error) {
+	loc, err := time.LoadLocation(name)
+	if err == nil {
+		return loc, nil
+	}
+	if name == DefaultTimezone {
+		return time.FixedZone("MSK", 3*60*60), nil
+	}
+	return nil, err
+}
+
+// moscowLocation возвращает часовой пояс Москвы
+func moscowLocation() *time.Location {
+	loc, _ := loadLocation(DefaultTimezone)
+	return loc
+}
+
+// ParseTimezone разбирает часовой пояс, заданный пользователем: IANA имя
+// (Asia/Vladivostok), название города (Владивосток) или смещение (UTC+10, +10, GMT+3:30).
+// Возвращает нормализованное имя, которое можно сохранить в настройках.
+func ParseTimezone(input string) (string, *time.Location, error) {
+	value := strings.TrimSpace(input)
+	if value == "" {
+		return "", nil, fmt.Errorf("часовой пояс не указан")
+	}
+
+	if alias, ok := timezoneAliases[strings.ToLower(value)]; ok {
+		value = alias
+	}
+
+	if offset, ok, err := parseUTCOffset(value); ok {
+		if err != nil {
+			return "", nil, err
+		}
+		name := formatUTCOffset(offset)
+		return name, time.FixedZone(name, offset), nil
+	}
+
+	loc, err := loadLocation(value)
+	if err != nil {
+		return "", nil, fmt.Errorf("неизвестный часовой пояс %q", input)
+	}
+	return value, loc, nil
+}
+
+// parseUTCOffset разбирает смещение вида UTC+3, GMT-2, +10 или +5:30.
+// Второе значение сообщает, похожа ли строка на смещение вообще.
+func parseUTCOffset(value string) (int, bool, error) {
+	upper := strings.ToUpper(value)
+	upper = strings.TrimPrefix(upper, "UTC")
+	upper = strings.TrimPrefix(upper, "GMT")
+	if upper == "" {
+		return 0, true, nil
+	}
+	if upper[0] != '+' && upper[0] != '-' {
+		return 0, false, nil
+	}
+
+	sign := 1
+	if upper[0] == '-' {
+		sign = -1
+	}
+
+	hoursPart, minutesPart, hasMinutes := strings.Cut(upper[1:], ":")
+	hours, err := strconv.Atoi(hoursPart)
+	if err != nil || hours > 14 {
+		return 0, true, fmt.Errorf("некорректное смещение часового пояса %q", value)
+	}
+	minutes := 0
+	if hasMinutes {
+		minutes, err = strconv.Atoi(minutesPart)
+		if err != nil || minutes >= 60 {
+			return 0, true, fmt.Errorf("некорректное смещение часового пояса %q", value)
+		}
+	}
+
+	return sign * (hours*60*60 + minutes*60), true, nil
+}
+
+// formatUTCOffset форматирует смещение в виде UTC+3 или UTC+5:30
+func formatUTCOffset(offset int) string {
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+	hours := offset / 3600
+	minutes := (offset % 3600) / 60
+	if minutes == 0 {
+		return fmt.Sprintf("UTC%s%d", sign, hours)
+	}
+	return fmt.Sprintf("UTC%s%d:%02d", sign, hours, minutes)
+}
+
+// ParseClock разбирает время в формате ЧЧ:ММ
+func ParseClock(input string) (hour, minute int, err error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(input))
+	if err != nil {
+		return 0, 0, fmt.Errorf("некорректное время %q, ожидается формат ЧЧ:ММ", input)
+	}
+	return t.Hour(), t.Minute(), nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimezone(t *testing.T) {
+	tests := []struct {
+		input      string
+		wantName   string
+		wantOffset int // смещение от UTC в секундах
+		wantErr    bool
+	}{
+		{"Europe/Moscow", "Europe/Moscow", 3 * 3600, false},
+		{" мск ", "Europe/Moscow", 3 * 3600, false},
+		{"Владивосток", "Asia/Vladivostok", 10 * 3600, false},
+		{"Asia/Yekaterinburg", "Asia/Yekaterinburg", 5 * 3600, false},
+		{"utc+10", "UTC+10", 10 * 3600, false},
+		{"GMT-2", "UTC-2", -2 * 3600, false},
+		{"+5:30", "UTC+5:30", 5*3600 + 30*60, false},
+		{"UTC", "UTC+0", 0, false},
+		{"UTC+15", "", 0, true},
+		{"Марс", "", 0, true},
+		{"", "", 0, true},
+	}
+
+	// Смещение проверяется на фиксированную дату, чтобы не зависеть от дня запуска
+	at := time.Date(2024, time.January, 15, 12, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			name, loc, err := ParseTimezone(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTimezone(%q) ошибка = %v, ожидалась ошибка: %v", tt.input, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if name != tt.wantName {
+				t.Errorf("ParseTimezone(%q) имя = %q, ожидалось %q", tt.input, name, tt.wantName)
+			}
+			if _, offset := at.In(loc).Zone(); offset != tt.wantOffset {
+				t.Errorf("ParseTimezone(%q) смещение = %d, ожидалось %d", tt.input, offset, tt.wantOffset)
+			}
+		})
+	}
+}
+
+func TestParseUTCOffset(t *testing.T) {
+	tests := []struct {
+		input      string
+		wantOffset int
+		wantOK     bool
+		wantErr    bool
+	}{
+		{"UTC+3", 3 * 3600, true, false},
+		{"gmt-2", -2 * 3600, true, false},
+		{"+10", 10 * 3600, true, false},
+		{"+5:30", 5*3600 + 30*60, true, false},
+		{"-3:30", -(3*3600 + 30*60), true, false},
+		{"UTC+14", 14 * 3600, true, false},
+		{"UTC", 0, true, false},
+		{"Asia/Omsk", 0, false, false},
+		{"3", 0, false, false},
+		{"UTC+15", 0, true, true},
+		{"+3:60", 0, true, true},
+		{"+3:", 0, true, true},
+		{"+x", 0, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			offset, ok, err := parseUTCOffset(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseUTCOffset(%q) ошибка = %v, ожидалась ошибка: %v", tt.input, err, tt.wantErr)
+			}
+			if ok != tt.wantOK {
+				t.Errorf("parseUTCOffset(%q) похоже на смещение = %v, ожидалось %v", tt.input, ok, tt.wantOK)
+			}
+			if offset != tt.wantOffset {
+				t.Errorf("parseUTCOffset(%q) = %d, ожидалось %d", tt.input, offset, tt.wantOffset)
+			}
+		})
+	}
+}
+
+func TestParseClock(t *testing.T) {
+	tests := []struct {
+		input      string
+		wantHour   int
+		wantMinute int
+		wantErr    bool
+	}{
+		{"09:30", 9, 30, false},
+		{" 7:05 ", 7, 5, false},
+		{"00:00", 0, 0, false},
+		{"23:59", 23, 59, false},
+		{"24:00", 0, 0, true},
+		{"12:60", 0, 0, true},
+		{"1230", 0, 0, true},
+		{"9 утра", 0, 0, true},
+		{"", 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			hour, minute, err := ParseClock(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseClock(%q) ошибка = %v, ожидалась ошибка: %v", tt.input, err, tt.wantErr)
+			}
+			if hour != tt.wantHour || minute != tt.wantMinute {
+				t.Errorf("ParseClock(%q) = %02d:%02d, ожидалось %02d:%02d", tt.input, hour, minute, tt.wantHour, tt.wantMinute)
+			}
+		})
+	}
+}
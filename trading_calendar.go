@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// calendarDateLayout формат дат в торговом календаре
+const calendarDateLayout = "2006-01-02"
+
+// SessionStatus состояние торгов на Московской бирже
+type SessionStatus string
+
+const (
+	SessionPreOpen    SessionStatus = "pre_open"    // торговый день, основная сессия еще не началась
+	SessionMain       SessionStatus = "main"        // идет основная сессия
+	SessionClosing    SessionStatus = "closing"     // аукцион закрытия и перерыв до вечерней сессии
+	SessionEvening    SessionStatus = "evening"     // идет вечерняя сессия
+	SessionClosed     SessionStatus = "closed"      // торговый день завершен
+	SessionNonTrading SessionStatus = "non_trading" // выходной или праздник
+)
+
+// Расписание торгов фондового рынка (московское время, минуты от полуночи)
+const (
+	mainSessionOpen     = 10 * 60
+	mainSessionClose    = 18*60 + 50
+	eveningSessionOpen  = 19*60 + 5
+	eveningSessionClose = 23*60 + 50
+)
+
+// SessionInfo описывает торговую сессию на момент времени
+type SessionInfo struct {
+	Date             string        `json:"date"`
+	TradingDay       bool          `json:"trading_day"`
+	Status           SessionStatus `json:"status"`
+	LastTradingDay   string        `json:"last_trading_day"`
+	NextTradingDay   string        `json:"next_trading_day"`
+	IsSpecialSession bool          `json:"is_special_session"` // торги в выходной день
+}
+
+// IsOpen сообщает, идут ли сейчас торги
+func (s SessionInfo) IsOpen() bool {
+	return s.Status == SessionMain || s.Status == SessionEvening
+}
+
+// Describe возвращает описание сессии на русском для промпта и сообщений
+func (s SessionInfo) Describe() string {
+	switch s.Status {
+	case SessionPreOpen:
+		return "торговый день, основная сессия еще не началась"
+	case SessionMain:
+		return "идет основная торговая сессия"
+	case SessionClosing:
+		return "основная сессия завершена, ожидается вечерняя сессия"
+	case SessionEvening:
+		return "идет вечерняя торговая сессия"
+	case SessionClosed:
+		return "торги на сегодня завершены"
+	default:
+		return fmt.Sprintf("биржа сегодня не работает, последний торговый день %s", s.LastTradingDay)
+	}
+}
+
+// calendarFile формат локального файла торгового календаря
+type calendarFile struct {
+	Holidays    []string `json:"holidays"`     // будние дни без торгов
+	TradingDays []string `json:"trading_days"` // выходные дни с торгами
+}
+
+// TradingCalendar знает торговые дни Московской биржи. Источники в порядке
+// приоритета: локальный файл, календарь ISS, правило "будни - торговые дни".
+type TradingCalendar struct {
+	client     *http.Client
+	issBaseURL string
+	filePath   string
+	location   *time.Location
+
+	mu       sync.RWMutex
+	issDays  map[string]bool
+	fileDays map[string]bool
+}
+
+// NewTradingCalendar создает календарь с локальным файлом filePath (может отсутствовать)
+func NewTradingCalendar(issBaseURL, filePath string) *TradingCalendar {
+	return &TradingCalendar{
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+		issBaseURL: issBaseURL,
+		filePath:   filePath,
+		location:   moscowLocation(),
+		issDays:    make(map[string]bool),
+		fileDays:   make(map[string]bool),
+	}
+}
+
+// Refresh перечитывает локальный файл и загружает календарь ISS на текущий и следующий год.
+// Ошибки источников не фатальны: календарь продолжает работать на оставшихся данных.
+func (c *TradingCalendar) Refresh() {
+	if err := c.loadFile(); err != nil {
+		log.Printf("Ошибка загрузки торгового календаря из файла: %v", err)
+	}
+
+	now := time.Now().In(c.location)
+	from := time.Date(now.Year(), 1, 1, 0, 0, 0, 0, c.location)
+	till := time.Date(now.Year()+1, 12, 31, 0, 0, 0, 0, c.location)
+	if err := c.loadISS(from, till); err != nil {
+		log.Printf("Ошибка загрузки торгового календаря MOEX ISS: %v", err)
+	}
+}
+
+// loadFile загружает праздники и особые торговые дни из локального файла
+func (c *TradingCalendar) loadFile() error {
+	if c.filePath == "" {
+		return nil
+	}
+
+	content, err := os.ReadFile(c.filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("ошибка чтения %s: %w", c.filePath, err)
+	}
+
+	var file calendarFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return fmt.Errorf("ошибка парсинга %s: %w", c.filePath, err)
+	}
+
+	days := make(map[string]bool, len(file.Holidays)+len(file.TradingDays))
+	for _, day := range file.Holidays {
+		if _, err := time.Parse(calendarDateLayout, day); err != nil {
+			return fmt.Errorf("некорректная дата праздника %q в %s", day, c.filePath)
+		}
+		days[day] = false
+	}
+	for _, day := range file.TradingDays {
+		if _, err := time.Parse(calendarDateLayout, day); err != nil {
+			return fmt.Errorf("некорректная дата торгового дня %q в %s", day, c.filePath)
+		}
+		days[day] = true
+	}
+
+	c.mu.Lock()
+	c.fileDays = days
+	c.mu.Unlock()
+
+	log.Printf("Торговый календарь: загружено %d особых дней из %s", len(days), c.filePath)
+	return nil
+}
+
+// loadISS загружает календарь торговых дней фондового рынка из MOEX ISS
+func (c *TradingCalendar) loadISS(from, till time.Time) error {
+	calendarURL := fmt.Sprintf("%s/calendars.json?iss.meta=off&iss.only=off_days&show_all_days=1&from=%s&till=%s",
+		c.issBaseURL, from.Format(calendarDateLayout), till.Format(calendarDateLayout))
+
+	resp, err := c.client.Get(calendarURL)
+	if err != nil {
+		return fmt.Errorf("ошибка при запросе к MOEX ISS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("MOEX ISS вернул статус %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("ошибка при чтении ответа MOEX ISS: %w", err)
+	}
+
+	var issResp struct {
+		OffDays struct {
+			Columns []string        `json:"columns"`
+			Data    [][]interface{} `json:"data"`
+		} `json:"off_days"`
+	}
+	if err := json.Unmarshal(body, &issResp); err != nil {
+		return fmt.Errorf("ошибка при парсинге ответа MOEX ISS: %w", err)
+	}
+
+	dateIdx, tradedIdx := -1, -1
+	for i, col := range issResp.OffDays.Columns {
+		switch col {
+		case "tradedate":
+			dateIdx = i
+		case "is_traded":
+			tradedIdx = i
+		}
+	}
+	if dateIdx == -1 || tradedIdx == -1 {
+		return fmt.Errorf("не найдены столбцы tradedate/is_traded в календаре MOEX ISS")
+	}
+
+	days := make(map[string]bool, len(issResp.OffDays.Data))
+	for _, row := range issResp.OffDays.Data {
+		if len(row) <= dateIdx || len(row) <= tradedIdx {
+			continue
+		}
+		date, ok := row[dateIdx].(string)
+		if !ok {
+			continue
+		}
+		traded, ok := row[tradedIdx].(float64)
+		if !ok {
+			continue
+		}
+		days[date] = traded != 0
+	}
+
+	c.mu.Lock()
+	c.issDays = days
+	c.mu.Unlock()
+
+	log.Printf("Торговый календарь: загружено %d дней из MOEX ISS", len(days))
+	return nil
+}
+
+// IsTradingDay сообщает, проводятся ли торги в день, содержащий момент t (по Москве)
+func (c *TradingCalendar) IsTradingDay(t time.Time) bool {
+	day := t.In(c.location)
+	date := day.Format(calendarDateLayout)
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if traded, ok := c.fileDays[date]; ok {
+		return traded
+	}
+	if traded, ok := c.issDays[date]; ok {
+		return traded
+	}
+
+	return day.Weekday() != time.Saturday && day.Weekday() != time.Sunday
+}
+
+// Session возвращает состояние торгов на момент t
+func (c *TradingCalendar) Session(t time.Time) SessionInfo {
+	local := t.In(c.location)
+	info := SessionInfo{
+		Date:           local.Format(calendarDateLayout),
+		TradingDay:     c.IsTradingDay(local),
+		LastTradingDay: c.adjacentTradingDay(local, -1).Format(calendarDateLayout),
+		NextTradingDay: c.adjacentTradingDay(local, 1).Format(calendarDateLayout),
+	}
+
+	if !info.TradingDay {
+		info.Status = SessionNonTrading
+		return info
+	}
+
+	info.IsSpecialSession = local.Weekday() == time.Saturday || local.Weekday() == time.Sunday
+
+	minutes := local.Hour()*60 + local.Minute()
+	switch {
+	case minutes < mainSessionOpen:
+		info.Status = SessionPreOpen
+	case minutes < mainSessionClose:
+		info.Status = SessionMain
+	case minutes < eveningSessionOpen:
+		info.Status = SessionClosing
+	case minutes < eveningSessionClose:
+		info.Status = SessionEvening
+	default:
+		info.Status = SessionClosed
+	}
+
+	return info
+}
+
+// adjacentTradingDay ищет ближайший торговый день до (step = -1) или после (step = 1) дня t
+func (c *TradingCalendar) adjacentTradingDay(t time.Time, step int) time.Time {
+	day := t
+	for i := 0; i < 30; i++ {
+		day = day.AddDate(0, 0, step)
+		if c.IsTradingDay(day) {
+			return day
+		}
+	}
+	return day
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// usageRetention сколько хранятся записи о вызовах модели
+const usageRetention = 400 * 24 * time.Hour
+
+// Ограничения отчета /usage
+const (
+	defaultUsageDays = 7
+	maxUsageDays     = 90
+	maxUsageUsers    = 10 // пользователей в отчете, остальные суммируются
+)
+
+// UsageRecord запись об одном вызове модели
+type UsageRecord struct {
+	Time             time.Time     `json:"time"`
+	Provider         string        `json:"provider"`
+	Model            string        `json:"model"`
+	PromptTokens     int           `json:"prompt_tokens"`
+	CompletionTokens int           `json:"completion_tokens"`
+	Estimated        bool          `json:"estimated,omitempty"` // провайдер не сообщил расход, токены оценены по длине текста
+	Latency          time.Duration `json:"latency"`
+	Cost             float64       `json:"cost"`
+	UserID           int64         `json:"user_id,omitempty"` // кто запросил генерацию
+	ChatID           int64         `json:"chat_id,omitempty"`
+	Job              string        `json:"job,omitempty"` // рассылка, запустившая генерацию
+	Error            string        `json:"error,omitempty"`
+}
+
+// UsageSource кто или что запустило вызов модели
+type UsageSource struct {
+	UserID int64
+	ChatID int64
+	Job    string
+}
+
+// usageSourceKey ключ UsageSource в контексте
+type usageSourceKey struct{}
+
+// WithUsageSource добавляет в контекст источник вызовов модели для учета расходов
+func WithUsageSource(ctx context.Context, source UsageSource) context.Context {
+	return context.WithValue(ctx, usageSourceKey{}, source)
+}
+
+// usageSourceFrom возвращает источник вызовов модели из контекста
+func usageSourceFrom(ctx context.Context) UsageSource {
+	source, _ := ctx.Value(usageSourceKey{}).(UsageSource)
+	return source
+}
+
+// UsageTracker записывает каждый вызов модели с расходом токенов и оценкой
+// стоимости и следит за месячным лимитом расходов
+type UsageTracker struct {
+	storage Storage
+	config  UsageConfig
+
+	mu        sync.Mutex
+	month     string  // месяц, за который посчитан monthCost, ГГГГ-ММ
+	monthCost float64 // расходы за текущий месяц
+	overLimit bool
+}
+
+// NewUsageTracker создает учет расходов и подсчитывает расходы текущего месяца
+func NewUsageTracker(storage Storage, config UsageConfig) (*UsageTracker, error) {
+	t := &UsageTracker{
+		storage: storage,
+		config:  config,
+	}
+
+	now := time.Now().In(moscowLocation())
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	records, err := storage.Usage(monthStart)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения расходов: %w", err)
+	}
+	t.month = now.Format("2006-01")
+	for _, record := range records {
+		t.monthCost += record.Cost
+	}
+	t.overLimit = t.limitReached()
+
+	return t, nil
+}
+
+// Record сохраняет вызов модели. Если провайдер не сообщил расход токенов,
+// он оценивается по длине текста.
+func (t *UsageTracker) Record(ctx context.Context, provider string, req CompletionRequest, completion *Completion, latency time.Duration, callErr error) {
+	if t == nil {
+		return
+	}
+
+	source := usageSourceFrom(ctx)
+	record := UsageRecord{
+		Time:     time.Now(),
+		Provider: provider,
+		Model:    providerModel(provider),
+		Latency:  latency,
+		UserID:   source.UserID,
+		ChatID:   source.ChatID,
+		Job:      source.Job,
+	}
+	if callErr != nil {
+		record.Error = truncate(callErr.Error(), 200)
+	}
+	if completion != nil {
+		if completion.Model != "" {
+			record.Model = completion.Model
+		}
+		record.PromptTokens = completion.PromptTokens
+		record.CompletionTokens = completion.CompletionTokens
+		if record.PromptTokens == 0 && record.CompletionTokens == 0 {
+			record.PromptTokens = estimateTokens(req.System) + estimateMessagesTokens(req.Messages)
+			record.CompletionTokens = estimateTokens(completion.Text)
+			record.Estimated = true
+		}
+		record.Cost = t.cost(record.Model, providerModel(provider), record.PromptTokens, record.CompletionTokens)
+	}
+
+	if err := t.storage.RecordUsage(record); err != nil {
+		log.Printf("Ошибка сохранения расхода AI: %v", err)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if month := record.Time.In(moscowLocation()).Format("2006-01"); month != t.month {
+		t.month = month
+		t.monthCost = 0
+		t.overLimit = false
+	}
+	t.monthCost += record.Cost
+	if !t.overLimit && t.limitReached() {
+		t.overLimit = true
+		log.Printf("Превышен месячный лимит расходов на AI: %.2f %s из %.2f %s",
+			t.monthCost, t.config.Currency, t.config.MonthlyLimit, t.config.Currency)
+	}
+}
+
+// OverLimit сообщает, что расходы текущего месяца достигли лимита
+func (t *UsageTracker) OverLimit() bool {
+	if t == nil {
+		return false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if time.Now().In(moscowLocation()).Format("2006-01") != t.month {
+		return false // начался новый месяц, расходы обнулятся при следующей записи
+	}
+	return t.overLimit
+}
+
+// MonthCost возвращает расходы за текущий месяц
+func (t *UsageTracker) MonthCost() float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if time.Now().In(moscowLocation()).Format("2006-01") != t.month {
+		return 0
+	}
+	return t.monthCost
+}
+
+// limitReached проверяет лимит. Вызывается под блокировкой t.mu.
+func (t *UsageTracker) limitReached() bool {
+	return t.config.MonthlyLimit > 0 && t.monthCost >= t.config.MonthlyLimit
+}
+
+// cost оценивает стоимость вызова по таблице цен за миллион токенов. Цена ищется
+// сначала для модели из ответа провайдера, затем для модели из настроек.
+func (t *UsageTracker) cost(actual, configured string, promptTokens, completionTokens int) float64 {
+	price, ok := findModelPrice(t.config.Prices, actual)
+	if !ok {
+		price, ok = findModelPrice(t.config.Prices, configured)
+	}
+	if !ok {
+		return 0
+	}
+	return (float64(promptTokens)*price.Prompt + float64(completionTokens)*price.Completion) / 1e6
+}
+
+// findModelPrice ищет цену модели: сначала точное совпадение, затем самый длинный
+// префикс (цена "gpt-4o" подходит для "gpt-4o-2024-08-06")
+func findModelPrice(prices map[string]ModelPrice, model string) (ModelPrice, bool) {
+	if model == "" {
+		return ModelPrice{}, false
+	}
+	if price, ok := prices[model]; ok {
+		return price, true
+	}
+
+	best := ""
+	for name := range prices {
+		if strings.HasPrefix(model, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		return ModelPrice{}, false
+	}
+	return prices[best], true
+}
+
+// providerModel извлекает модель из названия провайдера вида "openai/gpt-4o"
+func providerModel(name string) string {
+	if i := strings.Index(name, "/"); i != -1 {
+		return name[i+1:]
+	}
+	return name
+}
+
+// estimateTokens грубо оценивает число токенов: около четырех символов на токен
+func estimateTokens(text string) int {
+	if text == "" {
+		return 0
+	}
+	return utf8.RuneCountInString(text)/4 + 1
+}
+
+// estimateMessagesTokens оценивает число токенов в сообщениях диалога
+func estimateMessagesTokens(messages []AIMessage) int {
+	tokens := 0
+	for _, message := range messages {
+		tokens += estimateTokens(message.Content)
+	}
+	return tokens
+}
+
+// MeteredProvider записывает каждый вызов провайдера в учет расходов
+type MeteredProvider struct {
+	provider LLMProvider
+	usage    *UsageTracker
+}
+
+// NewMeteredProvider оборачивает провайдера учетом расходов
+func NewMeteredProvider(provider LLMProvider, usage *UsageTracker) *MeteredProvider {
+	return &MeteredProvider{provider: provider, usage: usage}
+}
+
+// Name возвращает название провайдера
+func (p *MeteredProvider) Name() string {
+	return p.provider.Name()
+}
+
+// Complete вызывает провайдера и записывает расход
+func (p *MeteredProvider) Complete(ctx context.Context, req CompletionRequest) (*Completion, error) {
+	return p.Stream(ctx, req, nil)
+}
+
+// Stream вызывает провайдера потоком, если он это умеет, и записывает расход
+func (p *MeteredProvider) Stream(ctx context.Context, req CompletionRequest, onText StreamFunc) (*Completion, error) {
+	started := time.Now()
+	completion, err := streamCompletion(ctx, p.provider, req, onText)
+	p.usage.Record(ctx, p.provider.Name(), req, completion, time.Since(started), err)
+	return completion, err
+}
+
+// UsageTotals сводные показатели расхода
+type UsageTotals struct {
+	Calls            int
+	Errors           int
+	PromptTokens     int
+	CompletionTokens int
+	Cost             float64
+	Latency          time.Duration // суммарное время ответа успешных вызовов
+}
+
+// add учитывает запись в сводных показателях
+func (u *UsageTotals) add(record UsageRecord) {
+	u.Calls++
+	if record.Error != "" {
+		u.Errors++
+	} else {
+		u.Latency += record.Latency
+	}
+	u.PromptTokens += record.PromptTokens
+	u.CompletionTokens += record.CompletionTokens
+	u.Cost += record.Cost
+}
+
+// merge добавляет к показателям другие сводные показатели
+func (u *UsageTotals) merge(other UsageTotals) {
+	u.Calls += other.Calls
+	u.Errors += other.Errors
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.Cost += other.Cost
+	u.Latency += other.Latency
+}
+
+// format выводит показатели одной строкой
+func (u UsageTotals) format(currency string) string {
+	s := fmt.Sprintf("вызовов: %d, токенов: %d + %d, %.3f %s",
+		u.Calls, u.PromptTokens, u.CompletionTokens, u.Cost, currency)
+	if ok := u.Calls - u.Errors; ok > 0 {
+		s += fmt.Sprintf(", ответ в среднем за %s", (u.Latency / time.Duration(ok)).Round(10*time.Millisecond))
+	}
+	if u.Errors > 0 {
+		s += fmt.Sprintf(", ошибок: %d", u.Errors)
+	}
+	return s
+}
+
+// UsageReport расход за несколько дней по дням и по пользователям
+type UsageReport struct {
+	Days   int
+	Total  UsageTotals
+	ByDay  map[string]*UsageTotals // ключ - дата ГГГГ-ММ-ДД по Москве
+	ByUser map[string]*UsageTotals // ключ - пользователь или рассылка
+}
+
+// Report собирает отчет о расходах за последние days дней
+func (t *UsageTracker) Report(days int) (*UsageReport, error) {
+	now := time.Now().In(moscowLocation())
+	from := time.Date(now.Year(), now.Month(), now.Day()-days+1, 0, 0, 0, 0, now.Location())
+	records, err := t.storage.Usage(from)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &UsageReport{
+		Days:   days,
+		ByDay:  make(map[string]*UsageTotals),
+		ByUser: make(map[string]*UsageTotals),
+	}
+	for _, record := range records {
+		day := record.Time.In(moscowLocation()).Format(calendarDateLayout)
+		user := "без источника"
+		switch {
+		case record.Job != "":
+			user = "рассылка " + record.Job
+		case record.UserID != 0:
+			user = fmt.Sprintf("пользователь %d", record.UserID)
+		}
+
+		if report.ByDay[day] == nil {
+			report.ByDay[day] = &UsageTotals{}
+		}
+		report.ByDay[day].add(record)
+		if report.ByUser[user] == nil {
+			report.ByUser[user] = &UsageTotals{}
+		}
+		report.ByUser[user].add(record)
+		report.Total.add(record)
+	}
+	return report, nil
+}
+
+// formatUsage выводит отчет /usage
+func formatUsage(report *UsageReport, usage *UsageTracker) string {
+	var sb strings.Builder
+	currency := usage.config.Currency
+
+	sb.WriteString(fmt.Sprintf("💸 Расход AI за %d дн.\n\n", report.Days))
+	if limit := usage.config.MonthlyLimit; limit > 0 {
+		sb.WriteString(fmt.Sprintf("В этом месяце: %.2f из %.2f %s", usage.MonthCost(), limit, currency))
+		if usage.OverLimit() {
+			if model := usage.config.OverLimitModel; model != "" {
+				sb.WriteString(fmt.Sprintf("\n⚠️ Лимит исчерпан, выпуски генерирует модель %s", model))
+			} else {
+				sb.WriteString("\n⚠️ Лимит исчерпан, выпуски собираются по шаблону без AI")
+			}
+		}
+	} else {
+		sb.WriteString(fmt.Sprintf("В этом месяце: %.2f %s (без лимита)", usage.MonthCost(), currency))
+	}
+
+	if report.Total.Calls == 0 {
+		sb.WriteString("\n\nЗа этот период вызовов модели не было")
+		return sb.String()
+	}
+	sb.WriteString("\n\nВсего: " + report.Total.format(currency))
+
+	days := make([]string, 0, len(report.ByDay))
+	for day := range report.ByDay {
+		days = append(days, day)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(days)))
+	sb.WriteString("\n\n📅 По дням:")
+	for _, day := range days {
+		sb.WriteString(fmt.Sprintf("\n%s - %s", formatReportDate(day), report.ByDay[day].format(currency)))
+	}
+
+	users := make([]string, 0, len(report.ByUser))
+	for user := range report.ByUser {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return report.ByUser[users[i]].Cost > report.ByUser[users[j]].Cost
+	})
+	sb.WriteString("\n\n👤 По пользователям:")
+	var rest UsageTotals
+	for i, user := range users {
+		totals := report.ByUser[user]
+		if i >= maxUsageUsers {
+			rest.merge(*totals)
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("\n%s - %s", user, totals.format(currency)))
+	}
+	if rest.Calls > 0 {
+		sb.WriteString(fmt.Sprintf("\nостальные (%d) - %s", len(users)-maxUsageUsers, rest.format(currency)))
+	}
+
+	return sb.String()
+}